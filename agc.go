@@ -0,0 +1,56 @@
+// Automatic gain control on the amplitude stream.
+//
+// The quantizer picks its threshold from the amplitudes in each group,
+// so a slow fade (QSB) that drags a whole group down still quantizes
+// fine, but one that spans a group boundary misclassifies entire words
+// as silence.  The AGC follows the signal's envelope, rising quickly
+// when a stronger signal arrives (attack) and sinking slowly as it
+// fades (decay), and divides each amplitude by it, so that the signal
+// reaches the quantizer at a steady level.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Level the AGC holds the envelope at: well clear of both the bottom
+// and the top of the int32 range.
+const agcLevel = 1 << 24
+
+// Coefficient of a one-pole smoother with time constant 'tau', updated
+// once per 'step' seconds.
+func smoothing(tau time.Duration, step float64) float64 {
+	if tau <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-step/tau.Seconds())
+}
+
+// Return a stage which applies AGC with the given attack and decay
+// times to a stream of amplitudes measured every 'step' seconds.
+func agcStage(attack, decay time.Duration, step float64) func(chan int32) chan int32 {
+	up := smoothing(attack, step)
+	down := smoothing(decay, step)
+	return func(amplitudes chan int32) chan int32 {
+		out := make(chan int32)
+		go func() {
+			env := 0.0
+			for amp := range amplitudes {
+				a := float64(amp)
+				if a > env {
+					env += up * (a - env)
+				} else {
+					env += down * (a - env)
+				}
+				if env < 1 {
+					env = 1
+				}
+				out <- int32(math.Min(a/env*agcLevel, math.MaxInt32))
+			}
+			close(out)
+		}()
+		return out
+	}
+}
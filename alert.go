@@ -0,0 +1,99 @@
+// Error budget alerts for unattended monitors.
+//
+// A remote receiving site can't tell anyone when its antenna falls
+// down or a new interference source appears, but the decoder notices:
+// more and more marks come out as errors.  The error budget watches the
+// fraction of marks that couldn't be classified over a rolling window,
+// and raises an alert when it passes a threshold (and again when it
+// recovers).  Alerts are logged to standard error, emitted as events,
+// and optionally POSTed as JSON to a webhook.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Don't judge the error rate on fewer marks than this.
+const minBudgetMarks = 50
+
+type errorBudget struct {
+	threshold float64 // alert above this fraction of error marks
+	window    time.Duration
+	webhook   string
+
+	marks    []time.Time // when recent marks were heard...
+	errs     []bool      // ...and whether each was an error
+	nerrs    int
+	alerting bool
+}
+
+func newErrorBudget(threshold float64, window time.Duration, webhook string) *errorBudget {
+	return &errorBudget{threshold: threshold, window: window, webhook: webhook}
+}
+
+func (b *errorBudget) add(s symbol) {
+	if s.tok != dit && s.tok != dah && s.tok != cwError {
+		return
+	}
+	now := time.Now()
+	b.marks = append(b.marks, now)
+	b.errs = append(b.errs, s.tok == cwError)
+	if s.tok == cwError {
+		b.nerrs++
+	}
+	old := 0
+	for old < len(b.marks) && now.Sub(b.marks[old]) > b.window {
+		if b.errs[old] {
+			b.nerrs--
+		}
+		old++
+	}
+	b.marks = b.marks[old:]
+	b.errs = b.errs[old:]
+
+	if len(b.marks) < minBudgetMarks {
+		return
+	}
+	rate := float64(b.nerrs) / float64(len(b.marks))
+	switch {
+	case !b.alerting && rate > b.threshold:
+		b.alerting = true
+		b.alert("error_budget_exceeded", rate)
+	case b.alerting && rate <= b.threshold:
+		b.alerting = false
+		b.alert("error_budget_recovered", rate)
+	}
+}
+
+func (b *errorBudget) alert(kind string, rate float64) {
+	fields := map[string]interface{}{
+		"error_rate": rate,
+		"threshold":  b.threshold,
+		"window":     b.window.String(),
+		"marks":      len(b.marks),
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s: %s%% of the last %s marks were errors\n",
+		human.time(time.Now()), kind, human.float(rate*100, 1), human.int(int64(len(b.marks))))
+	events.emit(kind, fields)
+	if b.webhook != "" {
+		fields["event"] = kind
+		go postWebhook(b.webhook, fields)
+	}
+}
+
+func postWebhook(url string, fields map[string]interface{}) {
+	data, _ := json.Marshal(fields)
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "webhook: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+}
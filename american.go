@@ -0,0 +1,121 @@
+// American Morse.
+//
+// The landline telegraph code, still sent on railroad and museum
+// circuits, isn't the international code.  Its dashes are two units
+// rather than three, L is a long dash of four and the figure 0 a
+// longer one of five, and six letters have a space inside them: O is
+// two dots two units apart, and C is I followed by E.  Spaces are a
+// unit between elements, two within a letter, three between letters
+// and six between words.
+//
+// The tokenizers know only the international dits and dahs, and the
+// Viterbi tokenizer's letters are international ones, so it can't be
+// used.  The others still find the unit and the word gaps well enough,
+// and every symbol they hand out carries its duration and the unit it
+// was judged by.  With -charset american, the text decoder judges the
+// marks and the spaces within words again by those, against American
+// Morse's lengths.  Only the clamp tokenizer needs telling: a two unit
+// dash is on its default dit/dah bound, so it gets bounds of its own,
+// and a dash is never averaged into the unit as three units.
+
+package main
+
+import "math"
+
+// Whether decoding American Morse.  Set by main.
+var americanMorse bool
+
+// The American Morse table.  Dots are '.', dashes '-', the long dash
+// of L '_' and that of 0 '=', and the space within a letter ' '.
+var morseAmerican = map[rune]string{
+	'A': ".-",
+	'B': "-...",
+	'C': ".. .",
+	'D': "-..",
+	'E': ".",
+	'F': ".-.",
+	'G': "--.",
+	'H': "....",
+	'I': "..",
+	'J': "-.-.",
+	'K': "-.-",
+	'L': "_",
+	'M': "--",
+	'N': "-.",
+	'O': ". .",
+	'P': ".....",
+	'Q': "..-.",
+	'R': ". ..",
+	'S': "...",
+	'T': "-",
+	'U': "..-",
+	'V': "...-",
+	'W': ".--",
+	'X': ".-..",
+	'Y': ".. ..",
+	'Z': "... .",
+	'1': ".--.",
+	'2': "..-..",
+	'3': "...-.",
+	'4': "....-",
+	'5': "---",
+	'6': "......",
+	'7': "--..",
+	'8': "-....",
+	'9': "-..-",
+	'0': "=",
+	'&': ". ...",
+	',': ".-.-",
+	'.': "..--..",
+	'?': "-..-.",
+	'!': "---.",
+}
+
+// Lengths, in units, of the marks, and of the spaces within a word:
+// between elements, within a letter and between letters.
+var (
+	americanMarks  = []float64{1, 2, 4, 5}
+	americanSpaces = []float64{1, 2, 3}
+)
+
+// The clamp tokenizer's bounds for American Morse, unless -clamp-bounds
+// is given: between the dot and the dash, the letter and word gaps,
+// and the word gap and a pause.
+var americanClampBounds = [3]float32{1.5, 4.5, 9}
+
+// Return what symbol 's' adds to the letter in progress in American
+// Morse, and the token it stands for: a mark's element, with its
+// token; the space within a letter, as noOp; or nothing, for a space
+// between elements or one that ends the letter.
+func americanElement(s symbol) (string, token) {
+	x := float64(s.duration) / float64(s.unit)
+	if s.tok == dit || s.tok == dah || s.tok == cwError {
+		k := nearestLength(x, americanMarks)
+		if x > 2*americanMarks[len(americanMarks)-1] {
+			return "*", cwError
+		}
+		return []string{".", "-", "_", "="}[k], []token{dit, dah, dah, dah}[k]
+	}
+	if s.tok != noOp && s.tok != endLetter {
+		// The tokenizer's word gaps and pauses stand.
+		return "", s.tok
+	}
+	switch nearestLength(x, americanSpaces) {
+	case 0:
+		return "", noOp
+	case 1:
+		return " ", noOp
+	}
+	return "", endLetter
+}
+
+// Return the index of the length in 'lengths' nearest 'x', by ratio.
+func nearestLength(x float64, lengths []float64) int {
+	k := 0
+	for i, l := range lengths {
+		if math.Abs(math.Log(x/l)) < math.Abs(math.Log(x/lengths[k])) {
+			k = i
+		}
+	}
+	return k
+}
@@ -0,0 +1,133 @@
+// Goertzel filter bank: find CW by its keying.
+//
+// Auto-tune and the skimmer find signals by looking for the strongest
+// carriers in the spectrum.  But a steady carrier, a birdie or a
+// heterodyne, is as strong as any CW signal and carries no Morse at
+// all.  What marks CW out is that it keys on and off.  So the bank
+// runs a row of Goertzel detectors across the passband, each keeping a
+// couple of seconds of its amplitudes, and judges each bin as the SNR
+// meter does, by how far its loud amplitudes stand above its quiet
+// ones.  A steady carrier is loud all the time and noise is quiet all
+// the time; only keying makes the two ends of the range far apart.
+
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Keying SNR a bin must show to count as active, in dB, on the scale
+// of the SNR meter.
+const bankMinSNR = 6
+
+type bankBin struct {
+	Freq float64 // Hz
+	SNR  float64 // dB
+}
+
+type goertzelBank struct {
+	detectors []*goertzel
+	history   [][]int32 // each detector's recent amplitudes
+	pos       int       // next slot in each of 'history'
+	full      bool      // whether 'history' has wrapped yet
+}
+
+// Make a bank of detectors from 'min' to 'max' Hz, spaced half of
+// 'bandwidth' apart so that no signal falls between bins, for audio
+// sampled at 'rate'.  The detectors are Hann windowed, so that a loud
+// signal doesn't show up in every bin, and twice as long as usual to
+// keep their main lobes 'bandwidth' wide.
+func newGoertzelBank(min, max, bandwidth float64, rate int) *goertzelBank {
+	b := &goertzelBank{}
+	for f := min; f <= max; f += bandwidth / 2 {
+		g := newGoertzel(f, bandwidth/2, rate)
+		g.hann()
+		b.detectors = append(b.detectors, g)
+	}
+	return b
+}
+
+// Add 'chunk' to every bin.
+func (b *goertzelBank) add(chunk []int32) {
+	if len(b.detectors) == 0 {
+		return
+	}
+	if b.history == nil {
+		// Chunks are all the same size, so now we know how
+		// many make up the SNR meter's window.
+		n := int(snrWindow*float64(b.detectors[0].rate)) / len(chunk)
+		b.history = make([][]int32, len(b.detectors))
+		for i := range b.history {
+			b.history[i] = make([]int32, n)
+		}
+	}
+	for i, g := range b.detectors {
+		b.history[i][b.pos] = g.amplitude(chunk)
+	}
+	b.pos++
+	if b.pos == len(b.history[0]) {
+		b.pos, b.full = 0, true
+	}
+}
+
+// Return the bins with active CW in them, busiest first.  A signal
+// spills into the bins either side of its own, so only a bin whose
+// keying stands out more than both its neighbours' counts.
+func (b *goertzelBank) active() []bankBin {
+	if !b.full || len(b.detectors) == 0 {
+		return nil
+	}
+	n := len(b.history[0])
+	snrs := make([]float64, len(b.detectors))
+	sorted := make([]int32, n)
+	for i, h := range b.history {
+		copy(sorted, h)
+		sort.Sort(byInt32(sorted))
+		noise := math.Max(float64(sorted[int(snrLow*float64(n))]), 1)
+		signal := math.Max(float64(sorted[int(snrHigh*float64(n))]), noise)
+		snrs[i] = 20*math.Log10(signal/noise) - snrNoiseOnly
+	}
+	var bins []bankBin
+	for i, g := range b.detectors {
+		if snrs[i] < bankMinSNR ||
+			(i > 0 && snrs[i-1] > snrs[i]) ||
+			(i < len(snrs)-1 && snrs[i+1] > snrs[i]) {
+			continue
+		}
+		bins = append(bins, bankBin{Freq: g.freq, SNR: snrs[i]})
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].SNR > bins[j].SNR })
+	return bins
+}
+
+// A detector which keeps 'g' on the busiest bin of 'bank', rechecking
+// every second.
+type bankTuner struct {
+	g     *goertzel
+	bank  *goertzelBank
+	tuned *tunedFreq
+	since int // samples since the last check
+}
+
+func newBankTuner(g *goertzel, bank *goertzelBank, tuned *tunedFreq) *bankTuner {
+	return &bankTuner{g: g, bank: bank, tuned: tuned}
+}
+
+func (t *bankTuner) amplitude(chunk []int32) int32 {
+	t.bank.add(chunk)
+	t.since += len(chunk)
+	if t.since >= t.g.rate && load.level() < 1 {
+		t.since = 0
+		binWidth := float64(t.g.rate) / float64(len(t.g.window))
+		if bins := t.bank.active(); len(bins) > 0 && math.Abs(bins[0].Freq-t.g.freq) >= binWidth/2 {
+			t.g.tune(bins[0].Freq)
+			t.tuned.set(bins[0].Freq)
+			events.emit("tuned", map[string]interface{}{
+				"freq": bins[0].Freq,
+				"snr":  bins[0].SNR,
+			})
+		}
+	}
+	return t.g.amplitude(chunk)
+}
@@ -0,0 +1,238 @@
+// Bayesian decoder.
+//
+// Every tokenizer commits to one unit at a time, and classifies each
+// duration by it as soon as it arrives.  When the unit is in doubt, at
+// the start of a contact or when the speed changes, a wrong guess
+// garbles every letter until the estimate catches up.
+//
+// Like fldigi's CW decoder, this one doesn't guess.  It keeps a
+// hypothesis for each of a range of units, from the slowest speed
+// allowed to the fastest, weighted by how well each explains the
+// durations so far: under each, every class of mark and space is
+// log-normally distributed about its centre, as in confidence.go.  A
+// duration's class is then a vote of all the hypotheses, each by its
+// weight, and the duration is held until the vote is decisive, so that
+// the durations after it can help settle the speed it was sent at.
+// Old evidence is slowly forgotten, so that a new speed can take over.
+
+package main
+
+import "math"
+
+const (
+	// Ratio between neighbouring hypotheses' units.
+	bayesStep = 1.05
+
+	// Share of each hypothesis' log weight forgotten with each
+	// duration.
+	bayesForget = 0.05
+
+	// Chance of its likeliest class at which a duration is committed
+	// to it, and the most durations held undecided regardless.
+	bayesCommit = 0.9
+	bayesHold   = 12
+
+	// Spread of each class under a hypothesis, as for classSpread,
+	// and of the classes beyond the dah and the word gap.
+	bayesSpread        = 0.25
+	bayesOutlierSpread = 0.6
+
+	// How far the dah length moves towards each dah, when it's
+	// learnt (see timing.go).
+	bayesDahRate = 0.1
+)
+
+// Ways of classifying durations, by name: hard leaves it to the
+// tokenizer.
+var decoders = map[string]bool{"hard": true, "bayes": true}
+
+// Centres of the classes of spaces, in units, as in clampCentres, and
+// the chance of each class of mark and space beforehand.
+var (
+	bayesSpaces      = []float64{1, 3, 7, 14}
+	bayesMarkPriors  = []float64{0.5, 0.45, 0.05}
+	bayesSpacePriors = []float64{0.5, 0.3, 0.15, 0.05}
+)
+
+type bayesDecoder struct {
+	window  int           // durations to see before deciding any
+	seen    int           // durations seen, up to 'window'
+	est     *unitEstimate // told of the likeliest unit, if set
+	units   []float64     // each hypothesis' unit, in amplitudes
+	weights []float64     // the log weight of each
+	held    []int32       // durations awaiting a decision
+	kinds   []bool        // whether each of 'held' is a mark
+	dah     float64       // the dah's length, in units
+}
+
+// Make a Bayesian decoder weighing units within 'bounds', which waits
+// for 'window' durations before deciding the first.
+func newBayesDecoder(bounds *unitBounds, window int) *bayesDecoder {
+	b := &bayesDecoder{window: window, dah: 3}
+	for u := float64(bounds.min); u <= float64(bounds.max); u *= bayesStep {
+		b.units = append(b.units, u)
+	}
+	b.weights = make([]float64, len(b.units))
+	return b
+}
+
+// The classes of a mark or space, in units, and their spreads and
+// chances beforehand.  An error is centred as far beyond a dah as a
+// pause beyond a word gap.
+func (b *bayesDecoder) classes(mark bool) (centres, spreads, priors []float64) {
+	if mark {
+		centres = []float64{1, b.dah, b.dah * 7 / 3}
+		return centres, []float64{bayesSpread, bayesSpread, bayesOutlierSpread}, bayesMarkPriors
+	}
+	return bayesSpaces, []float64{bayesSpread, bayesSpread, bayesSpread, bayesOutlierSpread}, bayesSpacePriors
+}
+
+// The chance of a duration 'x' in each class, were the unit 'unit';
+// and the chance of the duration at all, up to a constant.
+func (b *bayesDecoder) likelihoods(x, unit float64, mark bool) (classes []float64, total float64) {
+	centres, spreads, priors := b.classes(mark)
+	classes = make([]float64, len(centres))
+	lx := math.Log(math.Max(x, 1))
+	for i, c := range centres {
+		d := (lx - math.Log(c*unit)) / spreads[i]
+		classes[i] = priors[i] * math.Exp(-d*d/2) / spreads[i]
+		total += classes[i]
+	}
+	return classes, total
+}
+
+func (b *bayesDecoder) tokenize(duration int32, mark bool) []symbol {
+	b.held = append(b.held, duration)
+	b.kinds = append(b.kinds, mark)
+	if mark || keying.gaps {
+		b.weigh(duration, mark)
+	}
+	if b.seen < b.window {
+		// Too little to go on yet.
+		b.seen++
+		return nil
+	}
+	return b.decide(false)
+}
+
+// Weigh each hypothesis by how well it explains 'duration'.
+func (b *bayesDecoder) weigh(duration int32, mark bool) {
+	best := math.Inf(-1)
+	for i, u := range b.units {
+		_, p := b.likelihoods(float64(duration), u, mark)
+		b.weights[i] = (1-bayesForget)*b.weights[i] + math.Log(math.Max(p, 1e-300))
+		best = math.Max(best, b.weights[i])
+	}
+	// Keep the log weights near 0.
+	for i := range b.weights {
+		b.weights[i] -= best
+	}
+}
+
+// Return the held durations the hypotheses have agreed on, in order,
+// or all of them if 'all'.
+func (b *bayesDecoder) decide(all bool) []symbol {
+	var syms []symbol
+	for len(b.held) > 0 {
+		s, sure := b.classify(b.held[0], b.kinds[0])
+		if !sure && !all && len(b.held) <= bayesHold {
+			break
+		}
+		if s.tok == dah && keying.ownDahs {
+			b.dah += bayesDahRate * (float64(s.duration)/float64(s.unit) - b.dah)
+			b.dah = math.Max(b.dah, clusterSpread)
+		}
+		syms = append(syms, s)
+		b.held, b.kinds = b.held[1:], b.kinds[1:]
+		if s.restarts() && !all {
+			// Weigh every speed afresh from the durations
+			// after the pause, and wait for enough of them
+			// again.
+			b.restart(b.held, b.kinds)
+			if b.seen < b.window {
+				break
+			}
+		}
+	}
+	return syms
+}
+
+// Vote on the class of 'duration', and return its symbol and whether
+// the vote was decisive.
+func (b *bayesDecoder) classify(duration int32, mark bool) (symbol, bool) {
+	x := float64(duration)
+	centres, _, _ := b.classes(mark)
+	votes := make([]float64, len(centres))
+	var total, unit float64
+	for i, u := range b.units {
+		w := math.Exp(b.weights[i])
+		classes, p := b.likelihoods(x, u, mark)
+		if p == 0 {
+			continue
+		}
+		for k, c := range classes {
+			votes[k] += w * c / p
+		}
+		total += w
+		unit += w * math.Log(u)
+	}
+	chosen := 0
+	for k := range votes {
+		if votes[k] > votes[chosen] {
+			chosen = k
+		}
+	}
+	conf := 1.0
+	if total > 0 {
+		conf = votes[chosen] / total
+	}
+	var tok token
+	if mark {
+		tok = []token{dit, dah, cwError}[chosen]
+	} else {
+		tok = []token{noOp, endLetter, endWord, pause}[chosen]
+	}
+	u := int32(math.Round(b.units[0]))
+	if total > 0 {
+		u = int32(math.Round(math.Exp(unit / total)))
+	}
+	if b.est != nil {
+		b.est.set(u)
+	}
+	return symbol{tok: tok, duration: duration, unit: u, confidence: conf}, conf >= bayesCommit
+}
+
+// Forget what's been learnt, and weigh the hypotheses by 'durations'
+// alone, which 'marks' says are marks or spaces.
+func (b *bayesDecoder) restart(durations []int32, marks []bool) {
+	for i := range b.weights {
+		b.weights[i] = 0
+	}
+	b.seen, b.dah = 0, 3
+	for i, d := range durations {
+		if marks[i] || keying.gaps {
+			b.weigh(d, marks[i])
+		}
+		if b.seen < b.window {
+			b.seen++
+		}
+	}
+}
+
+func (b *bayesDecoder) flush() []symbol {
+	return b.decide(true)
+}
+
+func (b *bayesDecoder) adapt(e *unitEstimate) {
+	b.est = e
+}
+
+// Start with the hypotheses near the calibrated 'unit' favoured, and
+// without waiting.
+func (b *bayesDecoder) seed(unit int32) {
+	b.seen = b.window
+	for i, u := range b.units {
+		d := math.Log(u/float64(unit)) / bayesSpread
+		b.weights[i] = -d * d / 2
+	}
+}
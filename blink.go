@@ -0,0 +1,68 @@
+// Blinking lamp output, for classroom demonstrations.
+//
+// The decoder hands out each symbol only once its mark or space has
+// ended, and at the start several at once, so they can't simply be
+// shown as they arrive.  Instead the blinker replays them: the lamp is lit for each
+// mark and dark for each space, for as long as the mark or space
+// lasted.  The replay lags the live signal by the tokenizer's delay
+// but keeps its rhythm, so a class can watch the dits and dahs the
+// decoder heard alongside the text it made of them.
+//
+// The lamp is a block on the terminal, or an LED or lamp driver on a
+// GPIO pin.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Symbols queued for replay; if the lamp falls this far behind, the
+// rest are dropped.
+const blinkQueue = 256
+
+type lamp interface {
+	set(on bool)
+}
+
+type terminalLamp struct {
+	w io.Writer
+}
+
+func (t terminalLamp) set(on bool) {
+	if on {
+		fmt.Fprint(t.w, "\r████\x1b[K")
+	} else {
+		fmt.Fprint(t.w, "\r\x1b[K")
+	}
+}
+
+type blinker struct {
+	queue chan symbol
+}
+
+// Start replaying symbols on 'l', for a pipeline whose durations are
+// counted in amplitudes 'step' seconds apart.
+func newBlinker(l lamp, step float64) *blinker {
+	b := &blinker{queue: make(chan symbol, blinkQueue)}
+	go func() {
+		for s := range b.queue {
+			l.set(s.tok == dit || s.tok == dah || s.tok == cwError)
+			time.Sleep(time.Duration(float64(s.duration) * step * float64(time.Second)))
+		}
+		l.set(false)
+	}()
+	return b
+}
+
+func (b *blinker) add(s symbol) {
+	if b == nil {
+		return
+	}
+	select {
+	case b.queue <- s:
+	default:
+	}
+}
@@ -0,0 +1,134 @@
+// Startup calibration.
+//
+// Every quantizer starts out knowing nothing about the band, and the
+// first second or so of decode is garbage while it learns: noise keyed
+// as a string of dits, or a threshold far from where the signal sits.
+// With calibration the decoder first listens for a few seconds without
+// quantizing anything.  From what it heard it measures the noise
+// floor, the signal's peak level and, if there was a signal, its unit,
+// and seeds the quantizer and tokenizer with them.  Then it decodes
+// everything, those first few seconds included.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// How far above its 10th percentile noise alone reaches, with a
+// little to spare: 18 dB, where Rayleigh-distributed noise amplitudes
+// have their 99th percentile about 16 dB up.  A peak any lower than
+// this is just noise.
+const calibrationSpread = 8
+
+type calibration struct {
+	floor, peak float64 // 10th and 99th percentile amplitudes
+	unit        int32   // in amplitudes; 0 if no signal was heard
+}
+
+// A tokenizer which can start from a calibrated unit, rather than
+// estimating its first one from scratch.
+type unitSeeder interface {
+	seed(unit int32)
+}
+
+// Measure the amplitudes 'amps', holding any unit found within
+// 'bounds'.
+func calibrate(amps []int32, bounds *unitBounds) *calibration {
+	c := &calibration{}
+	if len(amps) == 0 {
+		return c
+	}
+	s := append([]int32(nil), amps...)
+	sort.Sort(byInt32(s))
+	c.floor = float64(s[len(s)/10])
+	c.peak = float64(s[len(s)*99/100])
+	if !c.signal() {
+		return c
+	}
+	// Split the marks from the spaces halfway between the levels,
+	// and estimate the unit from the runs, less the first and last,
+	// which were cut short by the start and end of calibration.
+	threshold := math.Sqrt(c.floor * c.peak)
+	var runs []int32
+	var run int32
+	on := float64(amps[0]) >= threshold
+	for _, a := range amps {
+		if (float64(a) >= threshold) == on {
+			run++
+			continue
+		}
+		runs = append(runs, run)
+		on, run = !on, 1
+	}
+	if len(runs) > 8 {
+		c.unit = bounds.limit(calculateUnitDuration(runs[1:]))
+	}
+	return c
+}
+
+// Whether a signal was heard.
+func (c *calibration) signal() bool {
+	return c.peak > calibrationSpread*c.floor
+}
+
+// The lowest amplitude which can be a mark: clear of the noise, but no
+// higher than halfway (in dB) to the signal's peak.
+func (c *calibration) gate() float64 {
+	gate := calibrationSpread * c.floor
+	if c.signal() {
+		gate = math.Min(gate, math.Sqrt(c.floor*c.peak))
+	}
+	return gate
+}
+
+// Return a quantizer which listens to 'd' of amplitudes, measured
+// every 'step' seconds, before quantizing any.  It calibrates from
+// them, seeds 'tz' with the unit if it can take one, and then runs the
+// quantizer 'q' makes for the calibration over all the amplitudes,
+// those it listened to first.
+func calibrating(d time.Duration, step float64, bounds *unitBounds, q func(*calibration) func(chan int32, chan bool), tz tokenizer) func(chan int32, chan bool) {
+	n := int(d.Seconds() / step)
+	return func(amplitudes chan int32, quants chan bool) {
+		var held []int32
+		for len(held) < n {
+			amp, ok := <-amplitudes
+			if !ok {
+				break
+			}
+			held = append(held, amp)
+		}
+		c := calibrate(held, bounds)
+		report := map[string]interface{}{"floor": c.floor, "peak": c.peak}
+		msg := "no signal"
+		if c.signal() {
+			msg = fmt.Sprintf("signal %s dB over noise", human.float(20*math.Log10(c.peak/c.floor), 1))
+		}
+		if c.unit > 0 {
+			wpm := unitWPM(c.unit, step)
+			msg += fmt.Sprintf(", %s WPM", human.float(wpm, 0))
+			report["wpm"] = wpm
+			if s, ok := tz.(unitSeeder); ok {
+				s.seed(c.unit)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: calibrated: %s\n", human.time(time.Now()), msg)
+		events.emit("calibrated", report)
+
+		replay := make(chan int32)
+		go func() {
+			for _, amp := range held {
+				replay <- amp
+			}
+			for amp := range amplitudes {
+				replay <- amp
+			}
+			close(replay)
+		}()
+		q(c)(replay, quants)
+	}
+}
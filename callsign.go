@@ -0,0 +1,70 @@
+// Callsign recognition.
+//
+// An amateur callsign is a prefix of one to three characters, at least
+// one of them a letter, that says which country issued it; a digit;
+// and a suffix of one to four letters.  W1AW, 2E0ABC and 3DA0XY all
+// fit, where 599, QRS and 1234 don't.  A station away from home adds
+// the local prefix in front (F/W1AW), and a portable, mobile or QRP
+// station a designator behind (W1AW/P, W1AW/QRP).
+//
+// The spotter watches the decoded text for words of that shape and
+// logs each as a "callsign" event, for spotting and alerting.  With
+// -dedup, a call already logged within -dedup-ttl isn't logged again.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var callsignPattern = regexp.MustCompile(`^(?:[A-Z0-9]{1,4}/)?(?:[A-Z][A-Z0-9]{0,2}|[0-9][A-Z][A-Z0-9]?)[0-9][A-Z]{1,4}(?:/[A-Z0-9]{1,4})?$`)
+
+// Return whether 'word' is shaped like a callsign.
+func isCallsign(word string) bool {
+	return callsignPattern.MatchString(word)
+}
+
+// Return the callsigns among the words of 'text'.
+func callsigns(text string) []string {
+	var calls []string
+	for _, w := range strings.Fields(strings.ToUpper(text)) {
+		if isCallsign(w) {
+			calls = append(calls, w)
+		}
+	}
+	return calls
+}
+
+// Log the callsigns in decoded text as they're completed.
+type callsignSpotter struct {
+	word  strings.Builder // the word in progress
+	snr   float64         // the latest SNR, in dB
+	dedup *dedupStore     // calls already logged; nil to log every one
+}
+
+// Take the next symbol and the text decoded from it.
+func (c *callsignSpotter) add(s symbol, text string) {
+	c.snr = s.snr
+	for _, r := range text {
+		if r != ' ' && r != '\n' {
+			c.word.WriteRune(r)
+			continue
+		}
+		c.flush()
+	}
+}
+
+// Log the word in progress if it's a callsign, and start the next.
+func (c *callsignSpotter) flush() {
+	word := c.word.String()
+	c.word.Reset()
+	if !isCallsign(word) || !c.dedup.add("call "+word) {
+		return
+	}
+	fields := map[string]interface{}{"call": word, "snr": c.snr}
+	if freq := tuning.get(); freq > 0 {
+		fields["freq"] = freq
+	}
+	events.emit("callsign", fields)
+}
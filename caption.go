@@ -0,0 +1,197 @@
+// Live captioning of the decoded stream, for museum and field-day
+// exhibits.
+//
+// The captioner serves a single big-font web page.  Decoded text is
+// pushed to every open page over server-sent events the moment each
+// letter is decoded, so visitors see copy appear with no
+// polling delay on top of the decoder's own latency.  Error garble is
+// never shown, and words found in an optional blocklist are masked
+// before they reach the screen.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// How many bytes of recent caption text to replay to a newly opened
+// page.
+const captionHistory = 2000
+
+// An undecodable letter, as the text decoder prints it.
+var undecodablePattern = regexp.MustCompile(`\[[-.*]*\]`)
+
+type captioner struct {
+	mu      sync.Mutex
+	text    string // recent caption text
+	dec     *textDecoder
+	word    string          // word held back for the blocklist check
+	blocked map[string]bool // lowercase words never to display
+	clients map[chan string]bool
+}
+
+func newCaptioner(blocked map[string]bool) *captioner {
+	return &captioner{
+		dec:     newTextDecoder(0),
+		blocked: blocked,
+		clients: make(map[chan string]bool),
+	}
+}
+
+// Read a word list: one word per line, blank lines and lines starting
+// with '#' ignored.  Words are lowercased.
+func loadWordList(filename string) (map[string]bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		w := strings.TrimSpace(scanner.Text())
+		if w == "" || strings.HasPrefix(w, "#") {
+			continue
+		}
+		words[strings.ToLower(w)] = true
+	}
+	return words, scanner.Err()
+}
+
+// Feed one symbol into the caption.
+//
+// Without a blocklist, text goes straight out.  With one, each word is
+// held until its end is seen, so that it can be masked as a whole.
+func (c *captioner) add(sym symbol) {
+	s := c.dec.add(sym)
+	if s == "" {
+		return
+	}
+	// Error garble is never shown.
+	s = undecodablePattern.ReplaceAllString(s, "")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.blocked) == 0 {
+		c.publish(s)
+		return
+	}
+	sep := s[len(s)-1:]
+	if sep != " " && sep != "\n" {
+		c.word += s
+		return
+	}
+	w := c.word + strings.TrimSuffix(s, sep)
+	c.word = ""
+	if c.blocked[strings.ToLower(w)] {
+		w = strings.Repeat("*", utf8.RuneCountInString(w))
+	}
+	c.publish(w + sep)
+}
+
+// Append 's' to the caption history and push it to all open pages.
+// Must be called with c.mu held.
+func (c *captioner) publish(s string) {
+	c.text += s
+	if len(c.text) > captionHistory {
+		cut := len(c.text) - captionHistory
+		for cut < len(c.text) && !utf8.RuneStart(c.text[cut]) {
+			cut++
+		}
+		c.text = c.text[cut:]
+	}
+	for ch := range c.clients {
+		select {
+		case ch <- s:
+		default:
+			// Page is not keeping up; it will miss this bit.
+		}
+	}
+}
+
+func (c *captioner) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, captionPage)
+	})
+	mux.HandleFunc("/stream", c.serveStream)
+	return mux
+}
+
+// Server-sent event stream: the caption history first, then each new
+// piece of text as it is decoded.
+func (c *captioner) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := make(chan string, 64)
+	c.mu.Lock()
+	history := c.text
+	c.clients[ch] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.clients, ch)
+		c.mu.Unlock()
+	}()
+
+	send := func(s string) bool {
+		data, _ := json.Marshal(s)
+		_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		return err == nil
+	}
+	if !send(history) {
+		return
+	}
+	for {
+		select {
+		case s := <-ch:
+			if !send(s) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const captionPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Morse decoder</title>
+<style>
+body { background: #000; color: #ff0; margin: 0; }
+#caption { font: bold 8vw monospace; padding: 2vw;
+           white-space: pre-wrap; word-wrap: break-word;
+           position: absolute; bottom: 0; }
+</style>
+</head>
+<body>
+<div id="caption"></div>
+<script>
+var el = document.getElementById("caption");
+var src = new EventSource("/stream");
+src.onmessage = function(e) {
+  var text = el.textContent + JSON.parse(e.data);
+  el.textContent = text.slice(-400);
+};
+src.onopen = function() { el.textContent = ""; };
+</script>
+</body>
+</html>
+`
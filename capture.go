@@ -0,0 +1,280 @@
+// Audio capture from the default input device, or a named one, via
+// portaudio.
+//
+// Two capture modes are offered.  The blocking mode reads the stream
+// from a goroutine; a GC pause there means portaudio overruns and
+// chunks are silently lost, which stretches or shrinks the on/off
+// runs that stage 2 measures.  The callback mode instead lets
+// portaudio's own thread copy each buffer into a ring, which never
+// blocks, and the pipeline drains the ring at its own pace.
+//
+// Devices other than the default can be picked by name.  This is
+// mostly for I2S MEMS microphones on a Raspberry Pi, which show up as
+// an ALSA card (e.g. "snd_rpi_i2s_card"), only run at rates like 48000,
+// and deliver stereo frames with the one microphone on the left or
+// right channel depending on how its L/R pin is strapped.
+
+package main
+
+import (
+	"code.google.com/p/portaudio-go/portaudio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	sampleRate = 44100
+	chunkSize  = 64 // samples per chunk handed to stage 1
+)
+
+type captureSettings struct {
+	rate     int    // samples per second
+	chunk    int    // samples per chunk handed to stage 1
+	buffer   int    // frames per portaudio buffer
+	device   string // input device name, or "" for the default
+	channels int    // channels to open the device with
+	channel  int    // which of those channels to decode
+
+	// If set, combines the channels rather than picking 'channel'.
+	diversity *diversityCombiner
+}
+
+var (
+	normalCapture = captureSettings{
+		rate:     sampleRate,
+		chunk:    chunkSize,
+		buffer:   chunkSize,
+		channels: 1,
+	}
+
+	// For battery-powered field units: a sample rate that still
+	// comfortably covers any CW sidetone, 5ms chunks, and buffers
+	// large enough that the CPU only wakes up ~8 times a second to
+	// take delivery of audio.
+	lowPowerCapture = captureSettings{
+		rate:     8000,
+		chunk:    40,
+		buffer:   1024,
+		channels: 1,
+	}
+)
+
+// Find the input device whose name contains 'name'.
+func findInputDevice(name string) (*portaudio.DeviceInfo, error) {
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, d := range devices {
+		if d.MaxInputChannels == 0 {
+			continue
+		}
+		if strings.Contains(d.Name, name) {
+			return d, nil
+		}
+		names = append(names, strconv.Quote(d.Name))
+	}
+	return nil, fmt.Errorf("no input device matching %q (have %s)", name, strings.Join(names, ", "))
+}
+
+// Open the input stream described by 'cs'.  'buf' is where the audio
+// goes: a slice of samples or a callback, as portaudio takes them.
+func openInput(cs captureSettings, buf interface{}) (*portaudio.Stream, error) {
+	if cs.device == "" && cs.channels == 1 {
+		return portaudio.OpenDefaultStream(1, 0, float64(cs.rate), cs.buffer, buf)
+	}
+	var dev *portaudio.DeviceInfo
+	var err error
+	if cs.device == "" {
+		dev, err = portaudio.DefaultInputDevice()
+	} else {
+		dev, err = findInputDevice(cs.device)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := portaudio.HighLatencyParameters(dev, nil)
+	p.Input.Channels = cs.channels
+	p.SampleRate = float64(cs.rate)
+	p.FramesPerBuffer = cs.buffer
+	return portaudio.OpenStream(p, buf)
+}
+
+// Pick channel 'channel' out of interleaved 'frames' into 'mono',
+// returning the filled part of 'mono'.
+func pickChannel(mono, frames []int32, channels, channel int) []int32 {
+	if channels == 1 {
+		return frames
+	}
+	n := len(frames) / channels
+	for i := 0; i < n; i++ {
+		mono[i] = frames[i*channels+channel]
+	}
+	return mono[:n]
+}
+
+// Turn interleaved 'frames' into the one channel to decode, in 'mono',
+// returning the filled part of 'mono'.
+func (cs captureSettings) mono(mono, frames []int32) []int32 {
+	if cs.diversity != nil {
+		return cs.diversity.combine(mono, frames)
+	}
+	return pickChannel(mono, frames, cs.channels, cs.channel)
+}
+
+// A fixed-size ring of samples between portaudio's callback thread and
+// the pipeline.  Writes never block; if the ring is full, incoming
+// samples are dropped and counted.
+type ringBuffer struct {
+	mu       sync.Mutex
+	nonEmpty *sync.Cond
+	buf      []int32
+	start    int // index of oldest sample
+	n        int // number of samples held
+	dropped  int
+	closed   bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	r := &ringBuffer{buf: make([]int32, size)}
+	r.nonEmpty = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *ringBuffer) write(samples []int32) {
+	r.mu.Lock()
+	for _, s := range samples {
+		if r.n == len(r.buf) {
+			r.dropped++
+			continue
+		}
+		r.buf[(r.start+r.n)%len(r.buf)] = s
+		r.n++
+	}
+	r.mu.Unlock()
+	r.nonEmpty.Signal()
+}
+
+// Fill 'p' from the ring, waiting until enough samples arrive.
+// Returns fewer than len(p) samples only once the ring is closed.
+func (r *ringBuffer) read(p []int32) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.n < len(p) && !r.closed {
+		r.nonEmpty.Wait()
+	}
+	n := len(p)
+	if r.n < n {
+		n = r.n
+	}
+	for i := 0; i < n; i++ {
+		p[i] = r.buf[r.start]
+		r.start = (r.start + 1) % len(r.buf)
+	}
+	r.n -= n
+	return n
+}
+
+// Number of samples waiting to be read.
+func (r *ringBuffer) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+func (r *ringBuffer) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.nonEmpty.Broadcast()
+}
+
+// Read samples from the microphone with blocking reads, pushing them
+// to 'chunks' until something arrives on 'stop'.  If 'rec' is non-nil,
+// the samples are also saved to it.  Closes 'chunks'.
+func captureBlocking(cs captureSettings, chunks chan []int32, stop chan os.Signal, rec *wavWriter) error {
+	defer close(chunks)
+	frames := cs.buffer - cs.buffer%cs.chunk
+	buf := make([]int32, frames*cs.channels)
+	mono := make([]int32, frames)
+	stream, err := openInput(cs, buf)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := stream.Start(); err != nil {
+		return err
+	}
+	for {
+		if err := stream.Read(); err != nil {
+			return err
+		}
+		samples := cs.mono(mono, buf)
+		if rec != nil {
+			if err := rec.write(samples); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < len(samples); i += cs.chunk {
+			chunks <- append([]int32(nil), samples[i:i+cs.chunk]...)
+		}
+
+		select {
+		case <-stop:
+			return stream.Stop()
+		default:
+		}
+	}
+}
+
+// Read samples from the microphone through portaudio's callback API,
+// pushing them to 'chunks' until something arrives on 'stop'.  If
+// 'rec' is non-nil, the samples are also saved to it.  Closes 'chunks'.
+func captureCallback(cs captureSettings, chunks chan []int32, stop chan os.Signal, rec *wavWriter) error {
+	defer close(chunks)
+	ring := newRingBuffer(cs.rate) // one second of slack
+	mono := make([]int32, cs.buffer)
+	stream, err := openInput(cs, func(in []int32) {
+		if len(in) > len(mono)*cs.channels {
+			// portaudio may hand us odd-sized buffers.
+			mono = make([]int32, len(in)/cs.channels)
+		}
+		ring.write(cs.mono(mono, in))
+	})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := stream.Start(); err != nil {
+		return err
+	}
+	go func() {
+		<-stop
+		ring.close()
+	}()
+	for {
+		chunk := make([]int32, cs.chunk)
+		if ring.read(chunk) < cs.chunk {
+			break
+		}
+		load.observe(ring.pending(), len(ring.buf))
+		if rec != nil {
+			if err := rec.write(chunk); err != nil {
+				stream.Stop()
+				return err
+			}
+		}
+		chunks <- chunk
+	}
+	err = stream.Stop()
+	if ring.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "capture: dropped %s samples\n", human.int(int64(ring.dropped)))
+	}
+	return err
+}
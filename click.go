@@ -0,0 +1,53 @@
+// Key-click and edge-ringing suppression.
+//
+// A hard-keyed transmitter switches its carrier on and off abruptly,
+// and the amplitude overshoots and rings for a few milliseconds at
+// each element edge.  Around the threshold that ringing flips the
+// quantizer back and forth: a dit heard as off, on, off, on, on, on...
+// turns into a one-quant mark and a one-quant space ahead of the real
+// one, which the tokenizer can only read as an extra element.
+//
+// The debouncer merges runs short relative to the unit, but has to
+// learn the unit first.  Ringing is a property of the transmitter, not
+// the sending speed, so the click guard uses a fixed time instead: any
+// run shorter than the guard is ringing at an edge, and is merged,
+// along with the run after it, into the run before.  That moves the
+// edge to where the ringing settles, which is where it belongs.
+
+package main
+
+// Merge away runs from 'lengths' shorter than 'guard' quants.  A run
+// of 'idle' quants or more (unless 'idle' is 0) is passed on at once,
+// as the sender has stopped, and nothing is merged into it.
+func getClickPipe(lengths chan int32, guard, idle int32) chan int32 {
+	out := make(chan int32)
+	go func() {
+		var held int32 // last run, held back in case ringing follows it
+		have, merge := false, false
+		for d := range lengths {
+			switch {
+			case merge:
+				held += d
+				merge = false
+			case have && d < guard:
+				held += d
+				merge = true
+				continue
+			default:
+				if have {
+					out <- held
+				}
+				held, have = d, true
+			}
+			if idle > 0 && held >= idle {
+				out <- held
+				have = false
+			}
+		}
+		if have {
+			out <- held
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,238 @@
+// Clustering tokenizer.
+//
+// The clamp tokenizer assumes textbook timing: a dah three dits long,
+// letter gaps three units and word gaps seven.  Real fists aren't so
+// tidy.  One operator's dahs run four units, another's letter gaps
+// barely two, and a bug sends machine-perfect dits with hand-timed
+// dahs.  Against fixed boundaries those turn into a steady trickle of
+// wrong letters.
+//
+// This tokenizer learns the timing instead.  It keeps the centre of
+// each class it sorts into, two for marks (dit, dah) and three for
+// spaces (between elements, letters and words), and files each
+// duration under the nearest centre, then moves that centre a little
+// towards it: online k-means.  The centres start out at the textbook
+// ratios of an estimated unit, where the boundaries between them fall
+// at the clamp tokenizer's 2 and 5 units, with the letter and word gaps
+// stretched if the sending has Farnsworth spacing, and drift from there
+// to wherever the operator actually puts them.
+//
+// Each class also keeps the spread of its durations about the centre,
+// for the confidence of each classification: a sloppy fist's dits
+// spread wide, and a dit of 1.6 units is no great surprise.
+//
+// A straight key is sloppier still.  Its dahs may be held for five
+// units or more, its gaps wander, and the operator drifts as the
+// contact wears on.  The hand key tolerances follow the operator twice
+// as fast, stretch how far a mark or a space may run past its class
+// before it's taken for an error or a pause, and judge durations by
+// their ratios to the centres rather than their differences: a letter
+// gap 50% long is as likely as a dit 50% long.
+
+package main
+
+import "math"
+
+const (
+	// Least ratio between neighbouring centres, so that a run of
+	// nothing but dits can't drag the dah centre down onto them.
+	clusterSpread = 1.5
+
+	// Least spread of a class, as for classSpread, however tightly
+	// its durations have bunched: machine sending still has the
+	// jitter of the amplitude steps.
+	clusterMinSpread = 0.05
+)
+
+// How far a clustering tokenizer lets durations stray.
+type clusterTolerance struct {
+	rate float64 // how far a centre moves towards each duration filed under it
+
+	// How far past the dah centre a mark may run, in dah-dit
+	// differences, before it's an error; and past the word gap
+	// centre a space, in word-letter gap differences, before it's
+	// a pause.
+	markLimit, pauseLimit float64
+
+	spread float64 // each class's spread until measured, as for classSpread
+
+	// Whether to split neighbouring classes at the geometric mean of
+	// their centres, rather than halfway.  Judging durations by their
+	// ratios suits timing whose errors grow with the duration.
+	geometric bool
+}
+
+var (
+	// For keyers and reasonably steady hands.
+	clusterTolerances = clusterTolerance{rate: 0.1, markLimit: 1, pauseLimit: 0.25, spread: classSpread}
+
+	// For straight keys.
+	handKeyTolerances = clusterTolerance{rate: 0.2, markLimit: 2, pauseLimit: 1, spread: 0.3, geometric: true}
+)
+
+type clusterTokenizer struct {
+	tol    clusterTolerance
+	bounds *unitBounds
+	window int           // durations to estimate the starting unit from
+	seeded int32         // calibrated unit to start from, if any
+	est    *unitEstimate // told of the dit centre, if set
+	marks  [2]float64    // dit and dah centres, in amplitudes; 0 until started
+	spaces [3]float64    // element, letter and word gap centres
+	spread [5]float64    // each class's variance of log duration, marks first
+	held   []int32       // durations awaiting the start
+	kinds  []bool        // whether each of 'held' is a mark
+}
+
+// Make a clustering tokenizer with tolerances 'tol' which starts from
+// a unit estimated from the first 'window' durations.
+func newClusterTokenizer(tol clusterTolerance, bounds *unitBounds, window int) *clusterTokenizer {
+	return &clusterTokenizer{tol: tol, bounds: bounds, window: window}
+}
+
+func (c *clusterTokenizer) tokenize(duration int32, mark bool) []symbol {
+	if c.marks[0] == 0 {
+		c.held = append(c.held, duration)
+		c.kinds = append(c.kinds, mark)
+		if c.seeded == 0 && len(c.held) < c.window {
+			return nil
+		}
+		return c.start()
+	}
+	s := c.classify(duration, mark)
+	if s.restarts() {
+		c.marks, c.seeded = [2]float64{}, 0
+	}
+	return []symbol{s}
+}
+
+// Set the centres from the held durations' unit, or the calibrated
+// one, and classify the held durations.
+func (c *clusterTokenizer) start() []symbol {
+	unit := c.seeded
+	if unit == 0 {
+		unit = c.bounds.limit(estimateUnit(c.held, c.kinds))
+	}
+	u := float64(unit)
+	var gaps []float64
+	for i, d := range c.held {
+		if x := float64(d) / u; !c.kinds[i] && x > 2 {
+			gaps = append(gaps, x)
+		}
+	}
+	// Farnsworth spacing stretches the letter and word gaps; left to
+	// learn that, the centres would start out two classes adrift.
+	f := u * farnsworthStretch(gaps)
+	c.marks = [2]float64{u, 3 * u}
+	c.spaces = [3]float64{u, 3 * f, 7 * f}
+	for i := range c.spread {
+		c.spread[i] = c.tol.spread * c.tol.spread
+	}
+	syms := make([]symbol, len(c.held))
+	for i, d := range c.held {
+		syms[i] = c.classify(d, c.kinds[i])
+	}
+	c.held, c.kinds = nil, nil
+	return syms
+}
+
+// File 'duration' under its nearest centre, and move the centre.
+func (c *clusterTokenizer) classify(duration int32, mark bool) symbol {
+	x := float64(duration)
+	var tok token
+	var conf float64
+	if mark {
+		short, long := c.marks[0], c.marks[1]
+		centres := []float64{short, long, long + 2*c.tol.markLimit*(long-short)}
+		switch {
+		case x > long+c.tol.markLimit*(long-short):
+			// Far longer than any dah: a stuck key or a carrier.
+			tok = cwError
+		case x > c.split(short, long):
+			tok = dah
+			c.learn(&c.marks[1], &c.spread[1], x)
+		default:
+			tok = dit
+			c.learn(&c.marks[0], &c.spread[0], x)
+		}
+		conf = classConfidence(x, centres, c.spreads(0, 2), tokenClass(tok))
+	} else {
+		elem, letter, word := c.spaces[0], c.spaces[1], c.spaces[2]
+		centres := []float64{elem, letter, word, word + 2*c.tol.pauseLimit*(word-letter)}
+		switch {
+		case x > word+c.tol.pauseLimit*(word-letter):
+			// Longer than any word gap: the sender has paused,
+			// and the length says nothing about their timing.
+			tok = pause
+		case x > c.split(letter, word):
+			tok = endWord
+			c.learn(&c.spaces[2], &c.spread[4], x)
+		case x > c.split(elem, letter):
+			tok = endLetter
+			c.learn(&c.spaces[1], &c.spread[3], x)
+		default:
+			tok = noOp
+			c.learn(&c.spaces[0], &c.spread[2], x)
+		}
+		conf = classConfidence(x, centres, c.spreads(2, 5), tokenClass(tok))
+	}
+	c.settle()
+	unit := int32(math.Round(c.marks[0]))
+	if c.est != nil {
+		c.est.set(unit)
+	}
+	return symbol{tok: tok, duration: duration, unit: unit, confidence: conf}
+}
+
+// Return the boundary between classes centred on 'a' and 'b'.
+func (c *clusterTokenizer) split(a, b float64) float64 {
+	if c.tol.geometric {
+		return math.Sqrt(a * b)
+	}
+	return (a + b) / 2
+}
+
+// Move 'centre', and its class's log variance 'spread', towards a
+// duration 'x' filed under it.  A zero-length run, as when the stream
+// opens keyed, counts as one sample, or its log would poison the
+// spread for good.
+func (c *clusterTokenizer) learn(centre, spread *float64, x float64) {
+	d := math.Log(math.Max(x, 1) / *centre)
+	*spread += c.tol.rate * (d*d - *spread)
+	*centre += c.tol.rate * (x - *centre)
+}
+
+// The standard deviations of classes 'from' up to 'to' of 'spread',
+// and the default for the outlier class beyond them.
+func (c *clusterTokenizer) spreads(from, to int) []float64 {
+	var s []float64
+	for _, v := range c.spread[from:to] {
+		s = append(s, math.Max(math.Sqrt(v), clusterMinSpread))
+	}
+	return append(s, c.tol.spread)
+}
+
+// Keep the dit centre within the speed bounds, and every centre far
+// enough above the one below it.
+func (c *clusterTokenizer) settle() {
+	c.marks[0] = math.Max(float64(c.bounds.min), math.Min(float64(c.bounds.max), c.marks[0]))
+	c.marks[1] = math.Max(c.marks[1], clusterSpread*c.marks[0])
+	c.spaces[0] = math.Max(float64(c.bounds.min), math.Min(float64(c.bounds.max), c.spaces[0]))
+	for i := 1; i < len(c.spaces); i++ {
+		c.spaces[i] = math.Max(c.spaces[i], clusterSpread*c.spaces[i-1])
+	}
+}
+
+func (c *clusterTokenizer) flush() []symbol {
+	if len(c.held) == 0 {
+		return nil
+	}
+	return c.start()
+}
+
+func (c *clusterTokenizer) adapt(e *unitEstimate) {
+	c.est = e
+}
+
+func (c *clusterTokenizer) seed(unit int32) {
+	c.seeded = unit
+}
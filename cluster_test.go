@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// A stream that opens keyed starts with a zero-length space.
+func TestClusterLearnsZeroDuration(t *testing.T) {
+	c := newClusterTokenizer(clusterTolerances, newUnitBounds(5, 60, 0.005), tokenWindow)
+	c.seed(10)
+	var syms []symbol
+	durations := []int32{0, 10, 10, 30, 10, 10, 30, 10, 30, 30, 70, 10}
+	for i, d := range durations {
+		syms = append(syms, c.tokenize(d, i%2 == 1)...)
+	}
+	syms = append(syms, c.flush()...)
+	if len(syms) == 0 {
+		t.Fatal("nothing tokenized")
+	}
+	for _, s := range syms {
+		if math.IsNaN(s.confidence) || math.IsInf(s.confidence, 0) {
+			t.Errorf("%q of %d has confidence %v", render(s.tok), s.duration, s.confidence)
+		}
+	}
+	for i, v := range c.spread {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			t.Errorf("spread %d is %v", i, v)
+		}
+	}
+}
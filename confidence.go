@@ -0,0 +1,117 @@
+// Classification confidence.
+//
+// A tokenizer files every duration under some class, but a mark of 1.9
+// units is a dit by a whisker, and one of 1.0 units a dit beyond
+// doubt.  Treating each class as a distribution of durations around
+// its centre, rather than a slot between two boundaries, the chance
+// that a duration belongs to the class it was filed under says how
+// sure the tokenizer can be.  Durations are compared as ratios, so
+// each class is a log-normal distribution: a dah 20% long is as far
+// off as a dit 20% long.
+//
+// The confidence travels with each symbol.  Printed output can flag a
+// letter any of whose elements or gaps the tokenizer was unsure of, so
+// a reader knows which letters to doubt.
+
+package main
+
+import "math"
+
+// Spread of a duration class, as the standard deviation of the log of
+// its durations, when not measured: about ±20%.
+const classSpread = 0.2
+
+// Return the chance that a duration of 'x' belongs to class 'chosen'
+// of the classes centred on 'centres', their log durations spread by
+// 'spreads', given equal odds of each beforehand.
+func classConfidence(x float64, centres, spreads []float64, chosen int) float64 {
+	lx := math.Log(math.Max(x, 1))
+	var total, mine float64
+	for i, c := range centres {
+		d := (lx - math.Log(c)) / spreads[i]
+		p := math.Exp(-d*d/2) / spreads[i]
+		total += p
+		if i == chosen {
+			mine = p
+		}
+	}
+	if total == 0 {
+		// Far beyond every class: it can only be the outermost,
+		// which it was surely filed under.
+		return 1
+	}
+	return mine / total
+}
+
+// The centres of the classes the clamp boundaries 'b' divide, in
+// units: for marks the dit, the dah and an error beyond it; for spaces
+// the element, letter and word gaps and a pause beyond them.  Each
+// boundary lies halfway between the centres either side of it, so the
+// defaults give 1, 3, 7 and 9.
+func clampCentres(b [3]float32) (marks, spaces []float64) {
+	c := []float64{1}
+	for _, x := range b {
+		c = append(c, 2*float64(x)-c[len(c)-1])
+	}
+	marks = []float64{c[0], c[1], 2*float64(b[1]) - c[1]}
+	return marks, c
+}
+
+// The index of 'tok' among the classes of clampCentres.
+func tokenClass(tok token) int {
+	switch tok {
+	case dah, endLetter:
+		return 1
+	case cwError, endWord:
+		return 2
+	case pause:
+		return 3
+	}
+	return 0
+}
+
+// 'n' copies of the default spread.
+func defaultSpreads(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = classSpread
+	}
+	return s
+}
+
+// Flag printed letters that were classified with less than 'threshold'
+// confidence.
+type uncertainFlagger struct {
+	threshold float64
+	least     float64 // the least confidence in the letter so far
+	letter    bool    // whether a letter is in progress
+}
+
+func newUncertainFlagger(threshold float64) *uncertainFlagger {
+	return &uncertainFlagger{threshold: threshold, least: 1}
+}
+
+// Take the next symbol, and return what to print ahead of it: a "?"
+// if it ends an uncertain letter.
+func (f *uncertainFlagger) mark(s symbol) string {
+	if f == nil {
+		return ""
+	}
+	switch s.tok {
+	case dit, dah, cwError:
+		f.letter = true
+		f.least = math.Min(f.least, s.confidence)
+		return ""
+	case noOp:
+		f.least = math.Min(f.least, s.confidence)
+		return ""
+	}
+	// The gap that ends the letter counts too: a doubtful letter
+	// gap may have been two letters run together, or one split.
+	flag := f.letter && math.Min(f.least, s.confidence) < f.threshold
+	f.letter, f.least = false, 1
+	if flag {
+		return "?"
+	}
+	return ""
+}
@@ -0,0 +1,579 @@
+// Runtime configuration, and presets for sharing it.
+//
+// Every command-line flag lives in 'config'.  The whole configuration
+// can be exported as a JSON preset file with -export-preset, and
+// presets published by other users imported with -preset.  Flags
+// given explicitly on the command line override the preset.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Bump this whenever a preset field is renamed or changes meaning, and
+// teach loadPreset how to upgrade the older layout.
+const presetVersion = 1
+
+type config struct {
+	Caption          string        `json:"caption"`
+	CaptionBlocklist string        `json:"caption_blocklist"`
+	Callback         bool          `json:"callback"`
+	Locale           string        `json:"locale"`
+	UTC              bool          `json:"utc"`
+	Record           string        `json:"record"`
+	Serve            string        `json:"serve"`
+	LEDSignal        int           `json:"led_signal"`
+	LEDDecoding      int           `json:"led_decoding"`
+	LEDError         int           `json:"led_error"`
+	LowPower         bool          `json:"low_power"`
+	Device           string        `json:"device"`
+	Rate             int           `json:"rate"`
+	Channels         int           `json:"channels"`
+	Channel          int           `json:"channel"`
+	Synth            string        `json:"synth"`
+	SynthWPM         float64       `json:"synth_wpm"`
+	SynthFreq        float64       `json:"synth_freq"`
+	SynthNoise       float64       `json:"synth_noise"`
+	Events           string        `json:"events"`
+	Watch            string        `json:"watch"`
+	WatchInterval    time.Duration `json:"watch_interval"`
+	Detector         string        `json:"detector"`
+	Freq             float64       `json:"freq"`
+	Bandwidth        float64       `json:"bandwidth"`
+	Prefilter        bool          `json:"prefilter"`
+	PrefilterFreq    float64       `json:"prefilter_freq"`
+	PrefilterWidth   float64       `json:"prefilter_width"`
+	Tokenizer        string        `json:"tokenizer"`
+	AutoTune         bool          `json:"auto_tune"`
+	TuneMin          float64       `json:"tune_min"`
+	TuneMax          float64       `json:"tune_max"`
+	Strip            bool          `json:"strip"`
+	Diag             string        `json:"diag"`
+	AGC              bool          `json:"agc"`
+	AGCAttack        time.Duration `json:"agc_attack"`
+	AGCDecay         time.Duration `json:"agc_decay"`
+	AlertThreshold   float64       `json:"alert_threshold"`
+	AlertWindow      time.Duration `json:"alert_window"`
+	AlertWebhook     string        `json:"alert_webhook"`
+	Quantizer        string        `json:"quantizer"`
+	Experiment       string        `json:"experiment"`
+	Debounce         float64       `json:"debounce"`
+	ResampleRate     int           `json:"resample_rate"`
+	Resampler        string        `json:"resampler"`
+	DCBlock          bool          `json:"dc_block"`
+	MatchedFilter    bool          `json:"matched_filter"`
+	Game             string        `json:"game"`
+	GameWords        string        `json:"game_words"`
+	GameScores       string        `json:"game_scores"`
+	Mock             string        `json:"mock"`
+	Skimmer          bool          `json:"skimmer"`
+	SNRInterval      time.Duration `json:"snr_interval"`
+	Blink            string        `json:"blink"`
+	MinWPM           float64       `json:"min_wpm"`
+	MaxWPM           float64       `json:"max_wpm"`
+	Squelch          float64       `json:"squelch"`
+	Words            bool          `json:"words"`
+	EnvelopeTau      time.Duration `json:"envelope_tau"`
+	RBN              string        `json:"rbn"`
+	RBNRecording     string        `json:"rbn_wav"`
+	RBNStart         string        `json:"rbn_start"`
+	RBNDial          float64       `json:"rbn_dial"`
+	FloorMargin      float64       `json:"floor_margin"`
+	FloorTau         time.Duration `json:"floor_tau"`
+	Denoise          bool          `json:"denoise"`
+	Smooth           string        `json:"smooth"`
+	QSB              bool          `json:"qsb"`
+	QSBWindow        time.Duration `json:"qsb_window"`
+	ClickGuard       time.Duration `json:"click_guard"`
+	QuantizeGroup    int           `json:"quantize_group"`
+	TokenGroup       int           `json:"token_group"`
+	Drift            bool          `json:"drift"`
+	DriftLimit       float64       `json:"drift_limit"`
+	Waterfall        string        `json:"waterfall"`
+	Decimate         int           `json:"decimate"`
+	QuantMedian      int           `json:"quant_median"`
+	QuantizeOn       float64       `json:"quantize_on"`
+	QuantizeOff      float64       `json:"quantize_off"`
+	Calibrate        time.Duration `json:"calibrate"`
+	Bank             bool          `json:"bank"`
+	AdaptiveWindows  bool          `json:"adaptive_windows"`
+	Gate             float64       `json:"gate"`
+	GateAttack       time.Duration `json:"gate_attack"`
+	GateHold         time.Duration `json:"gate_hold"`
+	GateRelease      time.Duration `json:"gate_release"`
+	Diversity        bool          `json:"diversity"`
+	Notch            string        `json:"notch"`
+	ClampBounds      string        `json:"clamp_bounds"`
+	Uncertain        float64       `json:"uncertain"`
+	Elements         bool          `json:"elements"`
+	Correct          bool          `json:"correct"`
+	Dictionary       string        `json:"dictionary"`
+	WPMInterval      time.Duration `json:"wpm_interval"`
+	Extended         bool          `json:"extended"`
+	Charset          string        `json:"charset"`
+	RTL              bool          `json:"rtl"`
+	QCodes           string        `json:"q_codes"`
+	Plain            string        `json:"plain"`
+	Contest          string        `json:"contest"`
+	Decoder          string        `json:"decoder"`
+	Segment          bool          `json:"segment"`
+	Spell            bool          `json:"spell"`
+	Key              string        `json:"key"`
+	CutNumbers       bool          `json:"cut_numbers"`
+	IdleFlush        time.Duration `json:"idle_flush"`
+	Replay           string        `json:"replay"`
+	ReplayLength     time.Duration `json:"replay_length"`
+	Dedup            string        `json:"dedup"`
+	DedupTTL         time.Duration `json:"dedup_ttl"`
+}
+
+// On-disk form of a preset.
+type preset struct {
+	Version int    `json:"version"`
+	Config  config `json:"config"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Callback:       true,
+		LEDSignal:      -1,
+		LEDDecoding:    -1,
+		LEDError:       -1,
+		Channels:       1,
+		SynthWPM:       20,
+		SynthFreq:      700,
+		SynthNoise:     0.02,
+		WatchInterval:  5 * time.Second,
+		Detector:       "rms",
+		Freq:           defaultToneFreq,
+		Bandwidth:      defaultBandwidth,
+		PrefilterWidth: 200,
+		Tokenizer:      "cluster",
+		TuneMin:        300,
+		TuneMax:        1200,
+		AGCAttack:      10 * time.Millisecond,
+		AGCDecay:       2 * time.Second,
+		AlertWindow:    5 * time.Minute,
+		Quantizer:      "otsu",
+		Debounce:       0.3,
+		Resampler:      "balanced",
+		DCBlock:        true,
+		MinWPM:         5,
+		MaxWPM:         60,
+		EnvelopeTau:    5 * time.Millisecond,
+		FloorMargin:    10,
+		FloorTau:       5 * time.Second,
+		QSBWindow:      time.Second,
+		ClickGuard:     4 * time.Millisecond,
+		QuantizeGroup:  quantizeWindow,
+		TokenGroup:     tokenWindow,
+		DriftLimit:     100,
+		Decimate:       1,
+		QuantizeOn:     0.5,
+		QuantizeOff:    0.5,
+		GateAttack:     time.Millisecond,
+		GateHold:       200 * time.Millisecond,
+		GateRelease:    20 * time.Millisecond,
+		ClampBounds:    "2,5,8",
+		Charset:        "latin",
+		Decoder:        "hard",
+		IdleFlush:      5 * time.Second,
+		ReplayLength:   5 * time.Minute,
+		DedupTTL:       10 * time.Minute,
+	}
+}
+
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Caption, "caption", c.Caption, "serve big-font live captions over HTTP on this address (e.g. :8080)")
+	fs.StringVar(&c.CaptionBlocklist, "caption-blocklist", c.CaptionBlocklist, "file of words (one per line) to mask in captions")
+	fs.BoolVar(&c.Callback, "callback", c.Callback, "capture through portaudio's callback API (false: blocking reads)")
+	fs.StringVar(&c.Locale, "locale", c.Locale, "locale for numbers and times in human-readable output, e.g. de or en_US (default from environment)")
+	fs.BoolVar(&c.UTC, "utc", c.UTC, "show timestamps in UTC rather than local time")
+	fs.StringVar(&c.Record, "record", c.Record, "also save the captured audio to this WAV file")
+	fs.StringVar(&c.Serve, "serve", c.Serve, "instead of listening to the microphone, decode WAV files POSTed to /decode on this address")
+	fs.IntVar(&c.LEDSignal, "led-signal", c.LEDSignal, "GPIO pin of the \"signal detected\" LED (-1: none)")
+	fs.IntVar(&c.LEDDecoding, "led-decoding", c.LEDDecoding, "GPIO pin of the \"decoding\" LED (-1: none)")
+	fs.IntVar(&c.LEDError, "led-error", c.LEDError, "GPIO pin of the \"error\" LED (-1: none)")
+	fs.BoolVar(&c.LowPower, "lowpower", c.LowPower, "headless battery mode: low sample rate, large buffers, no web UI")
+	fs.StringVar(&c.Device, "device", c.Device, "capture from the input device whose name contains this (default: system default)")
+	fs.IntVar(&c.Rate, "rate", c.Rate, "capture sample rate, e.g. 48000 for I2S microphones (0: mode's default)")
+	fs.IntVar(&c.Channels, "channels", c.Channels, "number of channels to open the input device with")
+	fs.IntVar(&c.Channel, "channel", c.Channel, "which channel to decode, counting from 0")
+	fs.StringVar(&c.Synth, "synth", c.Synth, "instead of the microphone, decode this text keyed by the built-in signal generator")
+	fs.Float64Var(&c.SynthWPM, "synth-wpm", c.SynthWPM, "signal generator speed in words per minute")
+	fs.Float64Var(&c.SynthFreq, "synth-freq", c.SynthFreq, "signal generator tone pitch in Hz")
+	fs.Float64Var(&c.SynthNoise, "synth-noise", c.SynthNoise, "signal generator noise level, relative to full scale")
+	fs.StringVar(&c.Events, "events", c.Events, "append JSON events, one per line, to this file (\"-\": standard error)")
+	fs.StringVar(&c.Watch, "watch", c.Watch, "instead of the microphone, decode WAV files as they appear in this directory")
+	fs.DurationVar(&c.WatchInterval, "watch-interval", c.WatchInterval, "how often to look for new files in the watched directory")
+	fs.StringVar(&c.Detector, "detector", c.Detector, "tone detector: rms (any loud sound), envelope (rectified and smoothed), hilbert (analytic signal), goertzel (narrowband), wavelet (narrowband, ignoring impulses; experimental) or pll (phase-locked to the tone)")
+	fs.Float64Var(&c.Freq, "freq", c.Freq, "tone frequency the goertzel detector listens for, in Hz; match your sidetone pitch")
+	fs.Float64Var(&c.Bandwidth, "bandwidth", c.Bandwidth, "width of the goertzel, wavelet and pll detectors' passband, in Hz; match your CW filter")
+	fs.BoolVar(&c.Prefilter, "prefilter", c.Prefilter, "bandpass filter the audio ahead of the tone detector")
+	fs.Float64Var(&c.PrefilterFreq, "prefilter-freq", c.PrefilterFreq, "centre frequency of the prefilter in Hz (0: same as -freq)")
+	fs.Float64Var(&c.PrefilterWidth, "prefilter-width", c.PrefilterWidth, "bandwidth of the prefilter in Hz")
+	fs.StringVar(&c.Tokenizer, "tokenizer", c.Tokenizer, "timing scheme used to classify marks and spaces: cluster (learns the operator's own timing), hand (the same, with the wider tolerances a straight key needs), viterbi (reads each letter as a whole, for noisy or irregular signals) or clamp (fixed boundaries, see clamp-bounds)")
+	fs.BoolVar(&c.AutoTune, "auto-tune", c.AutoTune, "find the strongest tone and keep the goertzel detector on it")
+	fs.Float64Var(&c.TuneMin, "tune-min", c.TuneMin, "lowest tone frequency auto-tune and the skimmer will consider, in Hz")
+	fs.Float64Var(&c.TuneMax, "tune-max", c.TuneMax, "highest tone frequency auto-tune and the skimmer will consider, in Hz")
+	fs.BoolVar(&c.Strip, "strip", c.Strip, "draw a scrolling strip of mark and space timing on standard error")
+	fs.StringVar(&c.Diag, "diag", c.Diag, "serve timing histograms over HTTP on this address (e.g. :8081)")
+	fs.BoolVar(&c.AGC, "agc", c.AGC, "apply automatic gain control to the amplitudes, to ride out fading")
+	fs.DurationVar(&c.AGCAttack, "agc-attack", c.AGCAttack, "how quickly the AGC turns down for a stronger signal")
+	fs.DurationVar(&c.AGCDecay, "agc-decay", c.AGCDecay, "how slowly the AGC turns back up as a signal fades")
+	fs.Float64Var(&c.AlertThreshold, "alert-threshold", c.AlertThreshold, "alert when more than this fraction of marks are errors (0: never)")
+	fs.DurationVar(&c.AlertWindow, "alert-window", c.AlertWindow, "rolling window over which the error rate is measured")
+	fs.StringVar(&c.AlertWebhook, "alert-webhook", c.AlertWebhook, "also POST alerts as JSON to this URL")
+	fs.StringVar(&c.Quantizer, "quantizer", c.Quantizer, "on/off quantizer: otsu (best split of a histogram of the last quantize-group amplitudes), window (their midpoint), adaptive (tracked threshold with hysteresis) or floor (fixed margin over the noise floor)")
+	fs.StringVar(&c.Experiment, "experiment", c.Experiment, "run this detector alongside the one in use and report how their amplitudes diverge")
+	fs.Float64Var(&c.Debounce, "debounce", c.Debounce, "merge away on/off runs shorter than this fraction of a unit (0: off)")
+	fs.IntVar(&c.ResampleRate, "resample-rate", c.ResampleRate, "resample audio to this rate before decoding (0: don't)")
+	fs.StringVar(&c.Resampler, "resampler", c.Resampler, "resampler quality: fast, balanced or best")
+	fs.BoolVar(&c.DCBlock, "dc-block", c.DCBlock, "remove any DC offset from the audio before detection")
+	fs.BoolVar(&c.MatchedFilter, "matched-filter", c.MatchedFilter, "average amplitudes over one dit, once the dit length is known, to dig weak signals out of noise")
+	fs.StringVar(&c.Game, "game", c.Game, "instead of decoding, serve a multiplayer copying game on this address; challenges are keyed with the synth settings")
+	fs.StringVar(&c.GameWords, "game-words", c.GameWords, "file of game challenges, one per line")
+	fs.StringVar(&c.GameScores, "game-scores", c.GameScores, "file to keep the game leaderboard in")
+	fs.StringVar(&c.Mock, "mock", c.Mock, "instead of listening to the microphone, play the scripted band in this JSON file")
+	fs.BoolVar(&c.Skimmer, "skimmer", c.Skimmer, "decode every signal between tune-min and tune-max at once, printing messages tagged with their frequency")
+	fs.DurationVar(&c.SNRInterval, "snr-interval", c.SNRInterval, "print the signal-to-noise ratio this often (0: never)")
+	fs.StringVar(&c.Blink, "blink", c.Blink, "replay the decoded keying on a lamp: \"terminal\", or a GPIO pin number")
+	fs.Float64Var(&c.MinWPM, "min-wpm", c.MinWPM, "slowest speed the unit estimate may settle on")
+	fs.Float64Var(&c.MaxWPM, "max-wpm", c.MaxWPM, "fastest speed the unit estimate may settle on")
+	fs.Float64Var(&c.Squelch, "squelch", c.Squelch, "drop everything decoded while the SNR is under this many dB (0: off)")
+	fs.BoolVar(&c.Words, "words", c.Words, "print whole words once their ending gap is heard, rather than each token as it comes")
+	fs.DurationVar(&c.EnvelopeTau, "envelope-tau", c.EnvelopeTau, "time constant of the envelope detector's smoothing")
+	fs.StringVar(&c.RBN, "rbn", c.RBN, "instead of decoding live, compare the skimmer's copy of -rbn-wav with the RBN spots at this URL or file")
+	fs.StringVar(&c.RBNRecording, "rbn-wav", c.RBNRecording, "recording to compare with the RBN")
+	fs.StringVar(&c.RBNStart, "rbn-start", c.RBNStart, "when the recording started, e.g. 2024-01-15T14:00:00Z")
+	fs.Float64Var(&c.RBNDial, "rbn-dial", c.RBNDial, "dial frequency the recording was made on, in kHz")
+	fs.Float64Var(&c.FloorMargin, "floor-margin", c.FloorMargin, "dB above the noise floor at which the floor quantizer keys on")
+	fs.DurationVar(&c.FloorTau, "floor-tau", c.FloorTau, "time constant of the floor quantizer's noise floor")
+	fs.BoolVar(&c.Denoise, "denoise", c.Denoise, "turn down steady background hiss by spectral subtraction before detection")
+	fs.StringVar(&c.Smooth, "smooth", c.Smooth, "smoothing filters for the amplitudes, in order: e.g. median:5,ma:3,iir:5ms,lp:40")
+	fs.BoolVar(&c.QSB, "qsb", c.QSB, "hold the key-down level steady through slow fades")
+	fs.DurationVar(&c.QSBWindow, "qsb-window", c.QSBWindow, "how much recent signal fade compensation measures the key-down level over")
+	fs.DurationVar(&c.ClickGuard, "click-guard", c.ClickGuard, "merge away on/off runs shorter than this as key clicks and ringing at element edges (0: off)")
+	fs.IntVar(&c.QuantizeGroup, "quantize-group", c.QuantizeGroup, "amplitudes the window and otsu quantizers set their threshold from; more for slow sending or short chunks")
+	fs.IntVar(&c.TokenGroup, "token-group", c.TokenGroup, "on/off durations the clamp tokenizer averages the unit over, and the cluster and viterbi tokenizers estimate their first from")
+	fs.BoolVar(&c.Drift, "drift", c.Drift, "keep the goertzel detector centred on its tone as it drifts")
+	fs.Float64Var(&c.DriftLimit, "drift-limit", c.DriftLimit, "furthest drift tracking will follow a tone from where it was tuned, in Hz")
+	fs.StringVar(&c.Waterfall, "waterfall", c.Waterfall, "serve a waterfall of the input audio over HTTP on this address (e.g. :8082)")
+	fs.IntVar(&c.Decimate, "decimate", c.Decimate, "after the prefilter, keep only every Nth sample to save CPU (1: off)")
+	fs.IntVar(&c.QuantMedian, "quant-median", c.QuantMedian, "median filter the run lengths as a filter this many (odd) on/off values wide would, absorbing runs of up to half as many into their neighbours (0: off)")
+	fs.Float64Var(&c.QuantizeOn, "quantize-on", c.QuantizeOn, "fraction of the way up its window's range at which the window quantizer keys on")
+	fs.Float64Var(&c.QuantizeOff, "quantize-off", c.QuantizeOff, "fraction of the way up at which it keys off again; below quantize-on for hysteresis (e.g. 0.6 and 0.4)")
+	fs.DurationVar(&c.Calibrate, "calibrate", c.Calibrate, "listen this long at startup to measure the noise, signal level and speed before decoding (0: off)")
+	fs.BoolVar(&c.Bank, "bank", c.Bank, "find signals by their keying in a goertzel filter bank, not by carrier strength: auto-tune hops to the busiest bin, the skimmer decodes every active one")
+	fs.BoolVar(&c.AdaptiveWindows, "adaptive-windows", c.AdaptiveWindows, "size the quantizer and tokenizer windows to the sending speed, rather than quantize-group and token-group")
+	fs.Float64Var(&c.Gate, "gate", c.Gate, "mute the audio ahead of the detector while its level is under this many dBFS, e.g. -50 (0: off)")
+	fs.DurationVar(&c.GateAttack, "gate-attack", c.GateAttack, "how long the noise gate takes to open")
+	fs.DurationVar(&c.GateHold, "gate-hold", c.GateHold, "how long the noise gate stays open after the level falls under gate")
+	fs.DurationVar(&c.GateRelease, "gate-release", c.GateRelease, "how long the noise gate then takes to close")
+	fs.BoolVar(&c.Diversity, "diversity", c.Diversity, "with the input's channels from separate receivers or antennas, decode whichever hears the tone better from moment to moment, rather than the one -channel picks")
+	fs.StringVar(&c.Notch, "notch", c.Notch, "notch out steady carriers ahead of detection, in Hz: e.g. 1020,1500:20,hum:60 (width 10 Hz unless given; hum takes out the harmonics near the tone)")
+	fs.StringVar(&c.ClampBounds, "clamp-bounds", c.ClampBounds, "durations, in units, dividing dit from dah and letter gap, letter gap from word gap, and word gap from pause; raise the first for a fist with long dahs")
+	fs.Float64Var(&c.Uncertain, "uncertain", c.Uncertain, "print ? after each letter whose timing the tokenizer was less sure of than this, from 0 to 1 (0: off)")
+	fs.BoolVar(&c.Elements, "elements", c.Elements, "print the dits, dahs and gaps decoded, rather than text")
+	fs.BoolVar(&c.Correct, "correct", c.Correct, "correct doubtful letters against a dictionary of ham abbreviations and common words")
+	fs.StringVar(&c.Dictionary, "dictionary", c.Dictionary, "file of words (one per line) to add to the -correct, -segment and -spell dictionary")
+	fs.DurationVar(&c.WPMInterval, "wpm-interval", c.WPMInterval, "print the speed of the station being copied this often (0: never)")
+	fs.BoolVar(&c.Extended, "extended", c.Extended, "decode the accented letters of the extended international table (É, Ñ, Ü, CH and so on)")
+	fs.StringVar(&c.Charset, "charset", c.Charset, "alphabet to decode letters in: latin, ru (Cyrillic), el (Greek), he (Hebrew), ar (Arabic), ja (Wabun; DO and SN switch to and from it with any charset) or american (American Morse, the landline code, in place of the international one)")
+	fs.BoolVar(&c.RTL, "rtl", c.RTL, "mark each printed word as right-to-left text, for the he and ar charsets")
+	fs.StringVar(&c.QCodes, "q-codes", c.QCodes, "spell out each Q code as it's decoded: inline, or on stderr")
+	fs.StringVar(&c.Plain, "plain", c.Plain, "also write the copy with abbreviations, Q codes and prosigns spelled out to this file (-: stderr)")
+	fs.StringVar(&c.Contest, "contest", c.Contest, "log contest exchanges (call, report and exchange) as events: serial, zone, state or power")
+	fs.StringVar(&c.Decoder, "decoder", c.Decoder, "how marks and spaces are classified: hard (by the tokenizer, one unit at a time) or bayes (weighs every speed at once and decides when they agree; the tokenizer is unused)")
+	fs.BoolVar(&c.Segment, "segment", c.Segment, "where the tokenizer wasn't sure of a word gap, split or join words by which reads as likelier words")
+	fs.BoolVar(&c.Spell, "spell", c.Spell, "after each word one letter away from a single dictionary word, print that word in braces, e.g. PSR{PSE}")
+	fs.StringVar(&c.Key, "key", c.Key, "what the sender keys with, to estimate the speed from the timing it gets right: paddle (a keyer times the dits and dahs), straight (the dahs are the least steady) or bug (machine dits, and dahs of any length)")
+	fs.BoolVar(&c.CutNumbers, "cut-numbers", c.CutNumbers, "read contest reports and numeric exchanges sent as cut numbers, e.g. 5NN ATN for 599 109")
+	fs.DurationVar(&c.IdleFlush, "idle-flush", c.IdleFlush, "after this long without a mark, decode whatever the sender left unfinished rather than wait for them to carry on (0: wait)")
+	fs.StringVar(&c.Replay, "replay", c.Replay, "keep the last replay-length of audio, and serve decodes of any span of it, with any other flags, over HTTP on this address (e.g. :8083)")
+	fs.DurationVar(&c.ReplayLength, "replay-length", c.ReplayLength, "how much audio to keep for replay")
+	fs.StringVar(&c.Dedup, "dedup", c.Dedup, "log each callsign spot and contest exchange only once per dedup-ttl, remembering what's been logged in this file across restarts")
+	fs.DurationVar(&c.DedupTTL, "dedup-ttl", c.DedupTTL, "how long a logged spot or exchange counts as a duplicate")
+}
+
+// Check the configuration for values the decoder can't work with.
+func (c *config) validate() error {
+	if c.CaptionBlocklist != "" && c.Caption == "" {
+		return errors.New("caption-blocklist given without caption")
+	}
+	if c.LowPower && (c.Caption != "" || c.Diag != "" || c.Waterfall != "" || c.Replay != "") {
+		return errors.New("no web UI in lowpower mode")
+	}
+	if c.Rate < 0 || c.Rate > 0 && c.Rate < 4000 {
+		return fmt.Errorf("bad sample rate %d", c.Rate)
+	}
+	if c.Channels < 1 || c.Channel < 0 || c.Channel >= c.Channels {
+		return fmt.Errorf("channel %d out of range for %d channels", c.Channel, c.Channels)
+	}
+	if c.Diversity && c.Channels < 2 {
+		return errors.New("diversity needs at least two channels")
+	}
+	if c.Synth != "" {
+		if err := checkSynthText(c.Synth); err != nil {
+			return err
+		}
+		if c.SynthWPM < 1 || c.SynthWPM > 100 {
+			return fmt.Errorf("synth-wpm %g out of range", c.SynthWPM)
+		}
+		if c.SynthFreq <= 0 || c.SynthNoise < 0 {
+			return errors.New("synth-freq and synth-noise must be positive")
+		}
+	}
+	if c.RBN != "" {
+		if c.RBNRecording == "" || c.RBNStart == "" || c.RBNDial <= 0 {
+			return errors.New("rbn needs rbn-wav, rbn-start and rbn-dial")
+		}
+		if c.TuneMin <= 0 || c.TuneMax <= c.TuneMin {
+			return errors.New("bad tune-min/tune-max range")
+		}
+	}
+	if c.QSB && c.QSBWindow <= 0 {
+		return errors.New("qsb-window must be positive")
+	}
+	if c.Uncertain < 0 || c.Uncertain > 1 {
+		return errors.New("uncertain must be between 0 and 1")
+	}
+	if c.Dictionary != "" && !c.Correct && !c.Segment && !c.Spell {
+		return errors.New("dictionary given without correct, segment or spell")
+	}
+	if c.Correct && c.Elements {
+		return errors.New("use either correct or elements, not both")
+	}
+	if (c.Segment || c.Spell) && c.Elements {
+		return errors.New("segment and spell don't apply to elements")
+	}
+	if _, ok := charsets[c.Charset]; !ok {
+		return fmt.Errorf("unknown charset %q", c.Charset)
+	}
+	if c.Charset != "latin" && (c.Extended || c.Correct || c.Segment || c.Spell) {
+		return errors.New("extended, correct, segment and spell need the latin charset")
+	}
+	if c.Charset == "american" && c.Tokenizer == "viterbi" && c.Decoder == "hard" {
+		return errors.New("the viterbi tokenizer can't read american morse")
+	}
+	if c.RTL && c.Charset != "he" && c.Charset != "ar" {
+		return errors.New("rtl needs the he or ar charset")
+	}
+	if c.QCodes != "" && !qCodeModes[c.QCodes] {
+		return fmt.Errorf("unknown q-codes mode %q", c.QCodes)
+	}
+	if c.Contest != "" && contestExchanges[c.Contest] == nil {
+		return fmt.Errorf("unknown contest exchange %q", c.Contest)
+	}
+	if c.CutNumbers && c.Contest == "" {
+		return errors.New("cut-numbers given without contest")
+	}
+	if _, ok := keyTimings[c.Key]; c.Key != "" && !ok {
+		return fmt.Errorf("unknown key %q", c.Key)
+	}
+	if !decoders[c.Decoder] {
+		return fmt.Errorf("unknown decoder %q", c.Decoder)
+	}
+	if _, err := parseClampBounds(c.ClampBounds); err != nil {
+		return err
+	}
+	if _, err := parseNotches(c.Notch); err != nil {
+		return err
+	}
+	if _, err := parseSmoothing(c.Smooth); err != nil {
+		return err
+	}
+	if c.FloorMargin <= 0 || c.FloorTau <= 0 {
+		return errors.New("floor-margin and floor-tau must be positive")
+	}
+	if c.EnvelopeTau <= 0 {
+		return errors.New("envelope-tau must be positive")
+	}
+	if c.MinWPM <= 0 || c.MaxWPM < c.MinWPM {
+		return errors.New("bad min-wpm/max-wpm range")
+	}
+	if c.Blink != "" && c.Blink != "terminal" {
+		if n, err := strconv.Atoi(c.Blink); err != nil || n < 0 {
+			return fmt.Errorf("blink: bad lamp %q", c.Blink)
+		}
+	}
+	if c.Synth != "" && c.Mock != "" {
+		return errors.New("use either synth or mock, not both")
+	}
+	if c.Watch != "" && c.WatchInterval <= 0 {
+		return errors.New("watch-interval must be positive")
+	}
+	switch c.Detector {
+	case "rms", "envelope", "hilbert", "goertzel", "wavelet", "pll":
+	default:
+		return fmt.Errorf("unknown detector %q", c.Detector)
+	}
+	switch c.Experiment {
+	case "", "rms", "envelope", "hilbert", "goertzel", "wavelet", "pll":
+	default:
+		return fmt.Errorf("unknown experiment detector %q", c.Experiment)
+	}
+	if c.Freq <= 0 || c.Bandwidth <= 0 {
+		return errors.New("freq and bandwidth must be positive")
+	}
+	if c.Decimate < 1 || c.Decimate > 16 {
+		return errors.New("decimate must be between 1 and 16")
+	}
+	if c.Decimate > 1 && !c.Prefilter {
+		return errors.New("decimate needs the prefilter")
+	}
+	if c.Prefilter && (c.PrefilterFreq < 0 || c.PrefilterWidth <= 0) {
+		return errors.New("bad prefilter-freq or prefilter-width")
+	}
+	if c.AutoTune && c.Detector != "goertzel" {
+		return errors.New("auto-tune needs the goertzel detector")
+	}
+	if c.Bank && !c.AutoTune && !c.Skimmer {
+		return errors.New("bank needs auto-tune or the skimmer")
+	}
+	if c.Drift && c.Detector != "goertzel" {
+		return errors.New("drift needs the goertzel detector")
+	}
+	if c.Drift && c.DriftLimit <= 0 {
+		return errors.New("drift-limit must be positive")
+	}
+	if (c.AutoTune || c.Skimmer) && (c.TuneMin <= 0 || c.TuneMax <= c.TuneMin) {
+		return errors.New("bad tune-min/tune-max range")
+	}
+	if c.AGCAttack < 0 || c.AGCDecay < 0 {
+		return errors.New("agc-attack and agc-decay can't be negative")
+	}
+	if c.AlertThreshold < 0 || c.AlertThreshold > 1 {
+		return errors.New("alert-threshold must be between 0 and 1")
+	}
+	if c.AlertThreshold > 0 && c.AlertWindow <= 0 {
+		return errors.New("alert-window must be positive")
+	}
+	if c.ResampleRate < 0 || c.ResampleRate > 0 && c.ResampleRate < 1000 {
+		return fmt.Errorf("bad resample rate %d", c.ResampleRate)
+	}
+	if resamplerTaps[c.Resampler] == 0 {
+		return fmt.Errorf("unknown resampler %q", c.Resampler)
+	}
+	if c.QuantizeGroup < 1 || c.TokenGroup < 1 {
+		return errors.New("quantize-group and token-group must be positive")
+	}
+	if c.QuantizeOff <= 0 || c.QuantizeOff > c.QuantizeOn || c.QuantizeOn >= 1 {
+		return errors.New("need 0 < quantize-off <= quantize-on < 1")
+	}
+	if c.Gate > 0 {
+		return errors.New("gate must be in dBFS, so not above 0")
+	}
+	if c.GateAttack < 0 || c.GateHold < 0 || c.GateRelease < 0 {
+		return errors.New("gate-attack, gate-hold and gate-release must not be negative")
+	}
+	if c.Calibrate < 0 {
+		return errors.New("calibrate must not be negative")
+	}
+	if c.QuantMedian < 0 || (c.QuantMedian > 1 && c.QuantMedian%2 == 0) {
+		return errors.New("quant-median must be odd")
+	}
+	if c.ClickGuard < 0 {
+		return errors.New("click-guard must not be negative")
+	}
+	if c.IdleFlush < 0 {
+		return errors.New("idle-flush must not be negative")
+	}
+	if c.Debounce < 0 || c.Debounce >= 1 {
+		return errors.New("debounce must be at least 0 and less than 1")
+	}
+	switch c.Quantizer {
+	case "window", "adaptive", "floor", "otsu":
+	default:
+		return fmt.Errorf("unknown quantizer %q", c.Quantizer)
+	}
+	if tokenizers[c.Tokenizer] == nil {
+		return fmt.Errorf("unknown tokenizer %q", c.Tokenizer)
+	}
+	if c.Locale != "" && !knownLocale(c.Locale) {
+		return fmt.Errorf("unknown locale %q", c.Locale)
+	}
+	if c.Replay != "" && c.ReplayLength <= 0 {
+		return errors.New("replay-length must be positive")
+	}
+	if c.Dedup != "" && c.DedupTTL <= 0 {
+		return errors.New("dedup-ttl must be positive")
+	}
+	return nil
+}
+
+func loadPreset(filename string, c *config) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p := preset{Config: *c}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return fmt.Errorf("preset %s: %v", filename, err)
+	}
+	switch {
+	case p.Version == 0:
+		return fmt.Errorf("preset %s: missing version", filename)
+	case p.Version > presetVersion:
+		return fmt.Errorf("preset %s: version %d is newer than this decoder supports (%d)",
+			filename, p.Version, presetVersion)
+	}
+	if err := p.Config.validate(); err != nil {
+		return fmt.Errorf("preset %s: %v", filename, err)
+	}
+	*c = p.Config
+	return nil
+}
+
+func savePreset(filename string, c *config) error {
+	data, err := json.MarshalIndent(preset{presetVersion, *c}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// Parse the command line into a configuration.  Any -preset is applied
+// first, then the flags explicitly given on the command line on top of
+// it.  With -export-preset, the resulting configuration is written out
+// and the program exits.
+func parseConfig() (*config, error) {
+	c := defaultConfig()
+	c.registerFlags(flag.CommandLine)
+	presetFile := flag.String("preset", "", "load configuration from this preset file")
+	exportFile := flag.String("export-preset", "", "write the configuration to this preset file and exit")
+	flag.Parse()
+
+	if *presetFile != "" {
+		given := make(map[string]string)
+		flag.Visit(func(f *flag.Flag) {
+			given[f.Name] = f.Value.String()
+		})
+		if err := loadPreset(*presetFile, c); err != nil {
+			return nil, err
+		}
+		for name, value := range given {
+			flag.Set(name, value)
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if *exportFile != "" {
+		if err := savePreset(*exportFile, c); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+	return c, nil
+}
@@ -0,0 +1,141 @@
+// Contest exchanges.
+//
+// In a contest every contact is the same few words: the callsign of
+// the station being worked, a signal report that is always 599 (sent
+// as 5NN to save time), and the contest's exchange, such as a serial
+// number, a CQ zone or a state.  "K1ABC 5NN 123" is a whole contact.
+//
+// In contest mode the decoder picks these out of the copy and logs
+// each as an "exchange" event, for a contest logger to take straight
+// from the event log.  With -dedup, a contact already logged within
+// -dedup-ttl, such as an exchange repeated for a fill, isn't logged
+// again.
+//
+// Contest operators cut numbers the same way everywhere they can: T
+// for 0, A for 1, N for 9 and so on, so that serial 109 goes as "ATN".
+// With -cut-numbers the parser reads them back as figures, but only
+// where a number is expected: in the report, and in an exchange that's
+// a number.  A state or a callsign is left alone.
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Exchanges the contest mode understands, by name, with the check on
+// the field after the report.
+var contestExchanges = map[string]func(string) bool{
+	// A serial number, counting up from 1.
+	"serial": func(s string) bool {
+		n, err := strconv.Atoi(s)
+		return err == nil && n > 0 && len(s) <= 5
+	},
+	// A CQ zone, 1 to 40.
+	"zone": func(s string) bool {
+		n, err := strconv.Atoi(s)
+		return err == nil && n >= 1 && n <= 40
+	},
+	// A US state or Canadian province abbreviation.
+	"state": regexp.MustCompile(`^[A-Z]{2,3}$`).MatchString,
+	// Transmitter power, in watts or as K for a kilowatt.
+	"power": regexp.MustCompile(`^([0-9]{1,4}|K|KW)$`).MatchString,
+}
+
+// Exchanges that are numbers, and so may be sent cut.
+var contestNumeric = map[string]bool{"serial": true, "zone": true, "power": true}
+
+// A signal report, with the cut N for 9.
+var reportPattern = regexp.MustCompile(`^[1-5][1-9N][1-9N]$`)
+
+// The figures cut numbers stand for, by letter.
+var cutNumbers = map[rune]rune{
+	'T': '0', 'O': '0', 'A': '1', 'U': '2', 'V': '3',
+	'E': '5', 'B': '7', 'D': '8', 'N': '9',
+}
+
+// Return 'word' with its cut numbers as figures, or as it is unless
+// it's all figures and cut numbers.
+func uncut(word string) string {
+	var b strings.Builder
+	for _, r := range word {
+		if d, ok := cutNumbers[r]; ok {
+			r = d
+		} else if r < '0' || r > '9' {
+			return word
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Pick contest exchanges out of decoded text.
+type contestParser struct {
+	format string
+	check  func(string) bool
+	cut    bool            // whether to read cut numbers as figures
+	call   string          // the last callsign, if no exchange has followed it
+	report string          // the report after it, if any
+	word   strings.Builder // the word in progress
+	dedup  *dedupStore     // contacts already logged; nil to log every one
+}
+
+// Make a parser for the exchange 'format', one of contestExchanges,
+// which reads cut numbers as figures if 'cut', and logs only the
+// contacts 'dedup' hasn't seen.
+func newContestParser(format string, cut bool, dedup *dedupStore) *contestParser {
+	return &contestParser{format: format, check: contestExchanges[format], cut: cut, dedup: dedup}
+}
+
+// Take the next piece of decoded text.
+func (p *contestParser) add(text string) {
+	if p == nil {
+		return
+	}
+	for _, r := range text {
+		if r != ' ' && r != '\n' {
+			p.word.WriteRune(r)
+			continue
+		}
+		p.flush()
+	}
+}
+
+// Parse the word in progress, and start the next.
+func (p *contestParser) flush() {
+	if p == nil {
+		return
+	}
+	word := p.word.String()
+	p.word.Reset()
+	exchange, report := word, word
+	if p.cut {
+		report = uncut(word)
+		if contestNumeric[p.format] {
+			exchange = report
+		}
+	}
+	switch {
+	case word == "":
+	case p.report != "" && p.check(exchange):
+		if p.dedup.add("exchange " + p.call + " " + exchange) {
+			events.emit("exchange", map[string]interface{}{
+				"call":     p.call,
+				"report":   p.report,
+				"exchange": exchange,
+				"format":   p.format,
+			})
+		}
+		p.call, p.report = "", ""
+	case isCallsign(word):
+		p.call, p.report = word, ""
+	case p.call != "" && reportPattern.MatchString(report):
+		p.report = strings.ReplaceAll(report, "N", "9")
+	default:
+		// Anything else between the call and the exchange means
+		// this wasn't one.
+		p.report = ""
+	}
+}
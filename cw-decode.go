@@ -19,9 +19,13 @@ import (
 	"code.google.com/p/portaudio-go/portaudio"
 	"fmt"
 	"math"
+	"net/http"
 	"os"
 	"os/signal"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type token int32
@@ -40,68 +44,172 @@ const (
 
 // Use Root Mean Square (RMS) method to return 'average' value of an
 // array of audio samples.
+//
+// Sums are kept in float64: squares of 32-bit samples overflow any
+// integer type long before the end of a chunk.
 func rms(audiovals []int32) int32 {
-	var sum int32 = 0
-	var squaresum int32 = 0
+	if len(audiovals) == 0 {
+		return 0
+	}
+	var sum float64 = 0
+	var squaresum float64 = 0
 	for i := 0; i < len(audiovals); i++ {
-		v := audiovals[i]
+		v := float64(audiovals[i])
 		sum = sum + v
 		squaresum = squaresum + (v * v)
 	}
-	var mean int32 = sum / int32(len(audiovals))
-	meanOfSquares := squaresum / int32(len(audiovals))
-	return int32(math.Sqrt(float64(meanOfSquares - (mean * mean))))
+	mean := sum / float64(len(audiovals))
+	meanOfSquares := squaresum / float64(len(audiovals))
+	// Rounding can leave the variance a hair below zero.
+	return int32(math.Sqrt(math.Max(meanOfSquares-(mean*mean), 0)))
+}
+
+// A detector reduces each chunk of audio to a single amplitude, which
+// is high when there's a tone and low when there isn't.
+type detector interface {
+	amplitude(chunk []int32) int32
 }
 
-// Read audiosample chunks from 'chunks' channel, and push simple RMS
-// amplitudes into the 'amplitudes' channel.
-func amplituder(chunks chan []int32, amplitudes chan int32) {
+type rmsDetector struct{}
+
+func (rmsDetector) amplitude(chunk []int32) int32 { return rms(chunk) }
+
+// Make the detector selected by 'cfg', for audio sampled at 'rate',
+// which sets 'tuned' to whatever frequency it listens on.  If an
+// experiment is configured, the detector runs one alongside.
+func newDetector(cfg *config, rate int, tuned *tunedFreq) detector {
+	if cfg.Experiment != "" {
+		cur := *cfg
+		cur.Experiment = ""
+		alt := cur
+		alt.Detector = cfg.Experiment
+		return newExperiment(newDetector(&cur, rate, tuned), newDetector(&alt, rate, new(tunedFreq)), cfg.Detector+"/"+cfg.Experiment)
+	}
+	switch cfg.Detector {
+	case "envelope":
+		return newEnvelopeFollower(cfg.EnvelopeTau, rate)
+	case "hilbert":
+		return newHilbertDetector(rate)
+	case "wavelet":
+		tuned.set(cfg.Freq)
+		return newWaveletDetector(cfg.Freq, cfg.Bandwidth, rate)
+	case "pll":
+		tuned.set(cfg.Freq)
+		return newPLLDetector(cfg.Freq, cfg.Bandwidth, rate)
+	case "goertzel":
+		g := newGoertzel(cfg.Freq, cfg.Bandwidth, rate)
+		tuned.set(cfg.Freq)
+		var det detector = g
+		if cfg.AutoTune && cfg.Bank {
+			det = newBankTuner(g, newGoertzelBank(cfg.TuneMin, cfg.TuneMax, cfg.Bandwidth, rate), tuned)
+		} else if cfg.AutoTune {
+			det = newAutoTuner(g, cfg.TuneMin, cfg.TuneMax, tuned)
+		}
+		if cfg.Drift {
+			det = newDriftTracker(det, g, cfg.DriftLimit, tuned)
+		}
+		return det
+	}
+	return rmsDetector{}
+}
+
+// Read audiosample chunks from 'chunks' channel, and push the
+// amplitudes 'det' finds in them into the 'amplitudes' channel.
+func amplituder(chunks chan []int32, amplitudes chan int32, det detector) {
 	for chunk := range chunks {
-		amplitudes <- rms(chunk)
+		amplitudes <- det.amplitude(chunk)
+	}
+	if e, ok := det.(*experiment); ok {
+		e.report()
 	}
 	close(amplitudes)
 }
 
-// Read amplitudes from 'amplitudes' channel, and push quantized
-// on/off values to 'quants' channel.
-func quantizer(amplitudes chan int32, quants chan bool) {
-	var group [100]int32
-	var seen int32 = 0
-	var max int32 = 0
-	var min int32 = 0
-	for amp := range amplitudes {
-		// Suck 100 amplitudes at a time from input channel,
-		// figure out 'middle' amplitude for the group, and
-		// use that value to quantize each amplitude.
-		group[seen] = amp
-		seen += 1
-		if amp > max {
-			max = amp
-		}
-		if amp < min {
-			min = amp
-		}
-		if seen == 100 {
-			middle := (max - min) / 2
-			for i := 0; i < 100; i++ {
-				quants <- (group[i] >= middle)
+// Number of recent amplitudes the window quantizer judges each one
+// against, unless configured otherwise.
+const quantizeWindow = 100
+
+// Return a quantizer which reads amplitudes from 'amplitudes' channel,
+// and pushes quantized on/off values to 'quants' channel, judging each
+// amplitude against the last 'size' of them.
+//
+// It keys on at 'on' of the way up the window's range, and off again
+// below 'off' of the way up.  With 'off' under 'on', an amplitude
+// hovering around the threshold doesn't chatter on and off.  Given a
+// calibration 'cal', it never keys on amplitudes down in the noise.
+//
+// Given a unit estimate 'est', the window is instead sized to suit the
+// sending speed.
+func windowQuantizer(size int, on, off float64, cal *calibration, est *unitEstimate) func(chan int32, chan bool) {
+	var gate int32
+	if cal != nil {
+		gate = int32(cal.gate())
+	}
+	capacity := size
+	if est != nil {
+		capacity = est.quantizeCapacity(size)
+	}
+	return func(amplitudes chan int32, quants chan bool) {
+		window := make([]int32, capacity)
+		var seen int = 0
+		keyed := false
+		for amp := range amplitudes {
+			// Figure out the range of the last 'size'
+			// amplitudes, this one included, and use that to
+			// quantize it.  Each amplitude is quantized as
+			// soon as it arrives, rather than waiting for a
+			// batch.
+			if est != nil {
+				size = est.quantizeWindow(size)
+				if size > capacity {
+					size = capacity
+				}
 			}
-			max = 0
-			min = 0
-			seen = 0
+			window[seen%capacity] = amp
+			seen += 1
+			n := seen
+			if n > size {
+				n = size
+			}
+			var max int32 = 0
+			var min int32 = 0
+			for i := 1; i <= n; i++ {
+				a := window[(seen-i)%capacity]
+				if a > max {
+					max = a
+				}
+				if a < min {
+					min = a
+				}
+			}
+			threshold := on
+			if keyed {
+				threshold = off
+			}
+			// A window with no range at all is silence, or a
+			// constant input: there's nothing in it to key on.
+			keyed = max > min && amp >= int32(threshold*float64(max-min)) && amp >= gate
+			quants <- keyed
 		}
+		close(quants)
 	}
-	close(quants)
 }
 
 // Main stage 1 pipeline: reads audiochunks from input channel;
 // returns a boolean channel to which it pushes quantized on/off
 // values.
-func getQuantizePipe(audiochunks chan []int32) chan bool {
+//
+// Each of 'stages' is a further pipe stage through which the
+// amplitudes pass on their way to 'quantize', typically a
+// windowQuantizer.
+func getQuantizePipe(audiochunks chan []int32, det detector, quantize func(chan int32, chan bool), stages ...func(chan int32) chan int32) chan bool {
 	amplitudes := make(chan int32)
 	quants := make(chan bool)
-	go amplituder(audiochunks, amplitudes)
-	go quantizer(amplitudes, quants)
+	go amplituder(audiochunks, amplitudes, det)
+	for _, stage := range stages {
+		amplitudes = stage(amplitudes)
+	}
+	go quantize(amplitudes, quants)
 	return quants
 }
 
@@ -110,21 +218,33 @@ func getQuantizePipe(audiochunks chan []int32) chan bool {
 // That is, if the input stream is 0001100111100, we want to output
 // the list [3, 2, 2, 4, 2], which can be seen as the "rhythm" of the
 // coded message.
+//
+// A space doesn't end until the next mark starts, and when the sender
+// has stopped that may be never, leaving the last letter undecoded.
+// So a space that reaches 'idle' quants (unless 'idle' is 0) is output
+// then, as that long, and the rest of it dropped.
 
-func getRlePipe(quants chan bool) chan int32 {
+func getRlePipe(quants chan bool, idle int32) chan int32 {
 	lengths := make(chan int32)
 	go func() {
 		currentState := false
 		var tally int32 = 0
+		sent := false // whether the current space is already out
 
-		// TODO(sussman): need to "debounce" this stream
 		for quant := range quants {
 			if quant == currentState {
 				tally += 1
+				if !quant && tally == idle {
+					lengths <- tally
+					sent = true
+				}
 			} else {
-				lengths <- tally
+				if !sent {
+					lengths <- tally
+				}
 				currentState = quant
 				tally = 1
+				sent = false
 			}
 		}
 		close(lengths)
@@ -132,6 +252,74 @@ func getRlePipe(quants chan bool) chan int32 {
 	return lengths
 }
 
+// Median filter the run lengths from 'lengths' as a median filter 'n'
+// quants wide (n odd) would filter the on/off values they came from.
+// A run of at most n/2 quants, a flipped quant or two on a marginal
+// signal, can't win the vote anywhere in the filter, so it vanishes
+// into the runs either side of it; longer runs keep their edges.  On
+// runs that is the click guard's merge, with a guard of n/2+1.  A run
+// of 'idle' or more (unless 'idle' is 0) is passed on at once.
+func getMedianPipe(lengths chan int32, n int, idle int32) chan int32 {
+	return getClickPipe(lengths, int32(n/2+1), idle)
+}
+
+// Number of recent runs the debouncer estimates the unit from.
+const debounceWindow = 20
+
+// Debounce the run lengths from 'lengths': a run shorter than
+// 'fraction' of a unit is a flipped quant or two, not a real mark or
+// space, so it's merged with the runs either side of it.  Otherwise a
+// single glitch splits a dah into two dits, or bridges a letter gap.
+//
+// The unit is estimated from recent runs, the same way as stage 3
+// does; until there are enough of them nothing is merged.  A run of
+// 'idle' or more (unless 'idle' is 0) is passed on at once, as the
+// click guard does.
+func getDebouncePipe(lengths chan int32, fraction float64, idle int32) chan int32 {
+	debounced := make(chan int32)
+	go func() {
+		var recent []int32
+		var held int32 // last run, held back in case the next is a glitch
+		have, merge := false, false
+		emit := func(d int32) {
+			debounced <- d
+			recent = append(recent, d)
+			if len(recent) > debounceWindow {
+				recent = recent[1:]
+			}
+		}
+		for d := range lengths {
+			if merge {
+				// The run after a glitch continues the held one.
+				held += d
+				merge = false
+			} else {
+				if have && len(recent) >= debounceWindow/2 {
+					unit := calculateUnitDuration(append([]int32(nil), recent...))
+					if float64(d) < fraction*float64(unit) {
+						held += d
+						merge = true
+						continue
+					}
+				}
+				if have {
+					emit(held)
+				}
+				held, have = d, true
+			}
+			if idle > 0 && held >= idle {
+				emit(held)
+				have = false
+			}
+		}
+		if have {
+			emit(held)
+		}
+		close(debounced)
+	}()
+	return debounced
+}
+
 // ------- Stage 3: Figure out length of morse 'unit' & output logic tokens
 //
 
@@ -155,65 +343,333 @@ func calculateUnitDuration(group []int32) int32 {
 	return group[int32((len(group) / 4))]
 }
 
+// Return 'duration' in units of 'unit'.  The fraction matters: the
+// classes clamp() sorts into are split at 2, 5 and 8 units by default,
+// and a duration of 2.5 units is a dah, not a dit.
+func normalize(duration int32, unit float64) float32 {
+	return float32(float64(duration) / unit)
+}
+
+// Boundaries, in units, between the classes clamp() sorts durations
+// into: about 1, about 3, about 7, and longer still.  A fist with long
+// dahs, or short letter gaps, wants them moved; see parseClampBounds.
+var clampBounds = [3]float32{2, 5, 8}
+
+// Parse a comma-separated list of the three clamp boundaries, e.g.
+// "2.5,5,8" for an operator whose dahs run long.
+func parseClampBounds(spec string) ([3]float32, error) {
+	var b [3]float32
+	fields := strings.Split(spec, ",")
+	if len(fields) != len(b) {
+		return b, fmt.Errorf("clamp-bounds %q: need three boundaries", spec)
+	}
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+		if err != nil {
+			return b, fmt.Errorf("clamp-bounds %q: %v", spec, err)
+		}
+		b[i] = float32(v)
+	}
+	if b[0] <= 1 || b[1] <= b[0] || b[2] <= b[1] {
+		return b, fmt.Errorf("clamp-bounds %q: need 1 < dit/dah < letter/word < word/pause", spec)
+	}
+	return b, nil
+}
+
 // Take a normalized duration value, 'clamp' it to the magic numbers
 // 1, 3, 7 (which are the faundational time durations in Morse code),
 // and return a sensible semantic token.
 func clamp(x float32, silence bool) token {
 	if silence {
 		switch {
-		case x > 8:
+		case x > clampBounds[2]:
 			return pause
-		case x > 5:
+		case x > clampBounds[1]:
 			return endWord
-		case x > 2:
+		case x > clampBounds[0]:
 			return endLetter
 		default:
 			return noOp
 		}
-	} else {
-		switch {
-		case x > 8:
-			return cwError
-		case x > 5:
-			return cwError
-		case x > 2:
-			return dah
-		default:
-			return dit
+	}
+	switch {
+	case x > clampBounds[1]:
+		return cwError
+	case x > clampBounds[0]:
+		return dah
+	default:
+		return dit
+	}
+}
+
+// A logical token, along with the timing it was derived from.  Both
+// durations are counted in chunks, as in stage 2.
+type symbol struct {
+	tok      token
+	duration int32   // how long the mark or silence lasted
+	unit     int32   // length of 1 unit when it was classified
+	snr      float64 // dB, as measured when it left the pipeline
+
+	// 0 to 1: how sure the tokenizer was of 'tok' (see confidence.go).
+	confidence float64
+}
+
+// A tokenizer classifies mark and space durations as logical tokens.
+// Alternative timing schemes plug in here, without touching the
+// stages either side.
+type tokenizer interface {
+	// Take the next duration, and whether it was a mark (tone)
+	// or a space; return whatever symbols are now ready, if any.
+	tokenize(duration int32, mark bool) []symbol
+
+	// Return any symbols still held back at the end of the stream.
+	flush() []symbol
+}
+
+// Silence, in units, after which the tokenizers forget the sender's
+// timing and start again from a fresh window: whoever sends next is
+// more likely another station, or the same one at another speed, than
+// the same fist carrying on.  A pause any shorter still ends the line.
+const restartUnits = 14
+
+// Return whether 's' is a silence long enough to start again after.
+func (s symbol) restarts() bool {
+	return s.tok == pause && float64(s.duration) >= restartUnits*float64(s.unit)
+}
+
+// Available tokenizers, by name.  Each is made for a pipeline whose
+// durations are counted in amplitudes 'step' seconds apart.
+var tokenizers = map[string]func(cfg *config, step float64) tokenizer{
+	"clamp": func(cfg *config, step float64) tokenizer {
+		return newClampTokenizer(newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step), cfg.TokenGroup)
+	},
+	"cluster": func(cfg *config, step float64) tokenizer {
+		return newClusterTokenizer(clusterTolerances, newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step), cfg.TokenGroup)
+	},
+	"hand": func(cfg *config, step float64) tokenizer {
+		return newClusterTokenizer(handKeyTolerances, newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step), cfg.TokenGroup)
+	},
+	"viterbi": func(cfg *config, step float64) tokenizer {
+		return newViterbiTokenizer(newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step), cfg.TokenGroup)
+	},
+}
+
+// Hard limits on the unit estimate, so that a burst of impulse noise
+// can't drag it to an absurd speed.
+type unitBounds struct {
+	min, max int32 // in amplitudes
+	step     float64
+	outside  bool // whether the last estimate was out of bounds
+}
+
+// Return the speed, in words per minute, of sending with a unit of
+// 'unit' amplitudes each 'step' seconds long.
+func unitWPM(unit int32, step float64) float64 {
+	return 1.2 / (float64(unit) * step) // PARIS timing
+}
+
+// Bounds for sending between 'minWPM' and 'maxWPM'.
+func newUnitBounds(minWPM, maxWPM, step float64) *unitBounds {
+	b := &unitBounds{step: step}
+	b.min = int32(math.Max(1, math.Ceil(1.2/maxWPM/step))) // PARIS timing
+	b.max = int32(math.Max(float64(b.min), 1.2/minWPM/step))
+	return b
+}
+
+// Return 'unit' held within bounds, emitting an event whenever the
+// estimate strays outside them.
+func (b *unitBounds) limit(unit int32) int32 {
+	bounded := unit
+	if bounded < b.min {
+		bounded = b.min
+	}
+	if bounded > b.max {
+		bounded = b.max
+	}
+	if bounded != unit && !b.outside {
+		events.emit("unit_out_of_bounds", map[string]interface{}{
+			"wpm":     unitWPM(unit, b.step),
+			"bounded": unitWPM(bounded, b.step),
+		})
+	}
+	b.outside = bounded != unit
+	return bounded
+}
+
+// The classic scheme: estimate the unit, then clamp each normalized
+// duration to 1, 3 or 7 units.
+//
+// The first estimate is taken from a window of durations, which are
+// held back until there are enough of them.  From then on each dit,
+// dah and element gap, as it is tokenized, says what the unit was
+// (a dah 330 amplitudes long means a unit of 110), and the estimate
+// moves a step towards that: an exponentially weighted average whose
+// span is the window.  So tokens flow as the sending does, and an
+// operator who speeds up or slows down mid-QSO is followed for as
+// long as the session lasts.  Letter and word gaps don't count: they
+// are the first thing a sloppy fist or Farnsworth sending stretches.
+// The -key timing model may leave out the dahs or element gaps too.
+//
+// An average like that can't follow a sudden change of speed, such as
+// a new station: at half the speed every dit looks like a dah, and
+// drags the estimate the wrong way.  So the window's estimate is kept
+// too, and if the two ever differ by more than clampRelock the
+// average starts again from the window's.
+type clampTokenizer struct {
+	bounds *unitBounds
+	window int           // durations the unit is estimated over
+	seeded int32         // calibrated unit to start from, if any
+	est    *unitEstimate // resizes 'window', if set
+	unit   float64       // in amplitudes; 0 until the first estimate
+	dah    float64       // a bug's dah, in amplitudes (see timing.go)
+	recent []int32       // the last 'window' durations
+	held   []int32       // durations awaiting the first estimate
+	marks  []bool        // whether each of 'held' is a mark
+
+	spacing spacingEstimate // for Farnsworth spacing (see farnsworth.go)
+}
+
+// As a contextual window, look back over the last 20 on/off duration
+// events when calculating the unitDuration, unless configured otherwise.
+const tokenWindow = 20
+
+// Largest ratio between the clamp tokenizer's tracked unit and its
+// window's estimate before the tracked unit is abandoned.
+const clampRelock = 1.5
+
+// Make a clamp tokenizer which estimates the unit over the last
+// 'window' durations.
+func newClampTokenizer(bounds *unitBounds, window int) *clampTokenizer {
+	return &clampTokenizer{bounds: bounds, window: window}
+}
+
+func (c *clampTokenizer) tokenize(duration int32, mark bool) []symbol {
+	c.recent = append(c.recent, duration)
+	if len(c.recent) > c.window {
+		c.recent = c.recent[len(c.recent)-c.window:]
+	}
+	if c.unit == 0 {
+		c.held = append(c.held, duration)
+		c.marks = append(c.marks, mark)
+		if c.seeded == 0 && len(c.held) < c.window/2 {
+			// Too few to estimate from yet.
+			return nil
+		}
+		return c.start()
+	}
+	if len(c.recent) == c.window {
+		windowed := float64(c.bounds.limit(calculateUnitDuration(append([]int32(nil), c.recent...))))
+		if ratio := c.unit / windowed; ratio > clampRelock || ratio < 1/clampRelock {
+			c.unit = windowed
+			c.dah /= ratio
 		}
 	}
-	return cwError
+	s := c.classify(duration, mark)
+	if s.restarts() {
+		c.unit, c.seeded, c.recent = 0, 0, nil
+		c.spacing = spacingEstimate{}
+	}
+	return []symbol{s}
+}
+
+// Make the first estimate, from the calibrated unit if there is one or
+// the held durations if not, and tokenize the held durations.
+func (c *clampTokenizer) start() []symbol {
+	if c.seeded > 0 {
+		// The first few durations may well be noise; the
+		// calibrated unit is a better guess.
+		c.unit = float64(c.seeded)
+	} else {
+		c.unit = float64(c.bounds.limit(estimateUnit(c.held, c.marks)))
+	}
+	c.dah = 3 * c.unit
+	if keying.ownDahs {
+		c.dah = bugDah(c.held, c.marks, c.unit)
+	}
+	syms := make([]symbol, len(c.held))
+	for i := range c.held {
+		syms[i] = c.classify(c.held[i], c.marks[i])
+	}
+	c.held, c.marks = nil, nil
+	return syms
+}
+
+// Normalize & clamp 'duration' by the current estimate, and move the
+// estimate towards the unit it implies.
+func (c *clampTokenizer) classify(duration int32, mark bool) symbol {
+	unit := int32(math.Round(c.unit))
+	marks, spaces := clampCentres(clampBounds)
+	norm := normalize(duration, c.unit)
+	centres := marks
+	if mark && keying.ownDahs {
+		// Stretch the scale so that the dah comes out at three
+		// units, as a dah at the default bounds does.
+		norm = float32(1 + 2*(float64(duration)-c.unit)/(c.dah-c.unit))
+	}
+	if !mark {
+		norm = float32(c.spacing.space(float64(norm), float64(clampBounds[0])))
+		centres = spaces
+	}
+	tok := clamp(norm, !mark)
+	conf := classConfidence(float64(norm), centres, defaultSpreads(len(centres)), tokenClass(tok))
+
+	// Only the durations the timing model trusts (see timing.go).
+	switch {
+	case tok == dit, tok == dah && keying.dahs, tok == noOp && keying.gaps:
+		implied := float64(duration) / centres[tokenClass(tok)]
+		c.unit += 2 / float64(c.window+1) * (implied - c.unit)
+		c.unit = math.Max(float64(c.bounds.min), math.Min(float64(c.bounds.max), c.unit))
+	}
+	if tok == dah && keying.ownDahs {
+		c.dah += 2 / float64(c.window+1) * (float64(duration) - c.dah)
+	}
+	c.dah = math.Max(c.dah, clusterSpread*c.unit)
+	if c.est != nil {
+		c.est.set(int32(math.Round(c.unit)))
+		c.window = c.est.tokenWindow(c.window, c.bounds.step)
+	}
+	return symbol{tok: tok, duration: duration, unit: unit, confidence: conf}
 }
 
-func getTokenPipe(durations chan int32) chan token {
-	tokens := make(chan token)
-	seen := 0
+func (c *clampTokenizer) adapt(e *unitEstimate) {
+	c.est = e
+}
+
+func (c *clampTokenizer) seed(unit int32) {
+	c.seeded = unit
+}
+
+func (c *clampTokenizer) flush() []symbol {
+	if len(c.held) == 0 {
+		return nil
+	}
+	return c.start()
+}
+
+// Read alternating space/mark durations from stage 2 (which always
+// starts with a space), and push the tokens 'tz' makes of them.  After
+// a space of 'idle' or longer (unless 'idle' is 0), the sender has
+// stopped, so push whatever 'tz' is still holding back too, rather
+// than wait for them to start again.
+func getTokenPipe(durations chan int32, tz tokenizer, idle int32) chan symbol {
+	tokens := make(chan symbol)
 	go func() {
-		// As a contextual window, look at sets of 20 on/off
-		// duration events when calculating the unitDuration.
-		//
-		// TODO(sussman): make this windowsize a constant we
-		// can fiddle.
-		group := make([]int32, 20)
+		mark := false
 		for duration := range durations {
-			group[seen] = duration
-			seen += 1
-			if seen == 20 {
-				seen = 0
-
-				// figure out the length of a 'dit' (1 unit)
-				unitDuration := calculateUnitDuration(group[:])
-
-				// normalize & clamp each duration by this
-				silence := false
-				for i := range group {
-					norm := float32(group[i] / unitDuration)
-					tokens <- clamp(norm, silence)
-					silence = !silence
+			for _, s := range tz.tokenize(duration, mark) {
+				tokens <- s
+			}
+			if !mark && idle > 0 && duration >= idle {
+				for _, s := range tz.flush() {
+					tokens <- s
 				}
 			}
+			mark = !mark
+		}
+		for _, s := range tz.flush() {
+			tokens <- s
 		}
-		close(durations)
+		close(tokens)
 	}()
 	return tokens
 }
@@ -226,64 +682,489 @@ func chk(err error) {
 	}
 }
 
+// Main pipeline: reads audiochunks, sampled and chunked as 'cs' says,
+// from input channel; returns a channel of logical tokens.  Where the
+// detector is tuned is kept in 'tuned'.
+func getDecodePipe(cfg *config, cs captureSettings, chunks chan []int32, tuned *tunedFreq) chan symbol {
+	if cfg.ResampleRate != 0 && cfg.ResampleRate != cs.rate {
+		n := cs.chunk * cfg.ResampleRate / cs.rate
+		if n < 1 {
+			n = 1
+		}
+		chunks = getResamplePipe(chunks, newResampler(cs.rate, cfg.ResampleRate, cfg.Resampler), n)
+		cs.rate, cs.chunk = cfg.ResampleRate, n
+	}
+	rate := cs.rate
+	chunks = getSilencePipe(chunks, rate)
+	if cfg.DCBlock {
+		chunks = getDCBlockPipe(chunks, rate)
+	}
+	if cfg.Denoise {
+		chunks = getDenoisePipe(chunks, newSpectralSubtractor(rate))
+	}
+	if cfg.Notch != "" {
+		specs, _ := parseNotches(cfg.Notch) // checked by cfg.validate
+		chunks = getNotchPipe(chunks, notchFilters(specs, cfg.Freq, cfg.Bandwidth, rate))
+	}
+	if cfg.Prefilter {
+		freq := cfg.PrefilterFreq
+		if freq == 0 {
+			freq = cfg.Freq
+		}
+		chunks = getPrefilterPipe(chunks, newBandpass(freq, cfg.PrefilterWidth, rate))
+		if cfg.Decimate > 1 {
+			n := decimation(cfg.Decimate, rate, cs.chunk, freq+cfg.PrefilterWidth/2)
+			if n != cfg.Decimate {
+				fmt.Fprintf(os.Stderr, "decimating by %d, not %d, to keep the prefilter band under the Nyquist frequency\n", n, cfg.Decimate)
+			}
+			if n > 1 {
+				chunks = getDecimatePipe(chunks, n)
+				cs.rate, cs.chunk = cs.rate/n, cs.chunk/n
+				rate = cs.rate
+			}
+		}
+	}
+	if cfg.Gate < 0 {
+		chunks = getNoiseGatePipe(chunks, newNoiseGate(cfg.Gate, cfg.GateAttack, cfg.GateHold, cfg.GateRelease, rate))
+	}
+	step := float64(cs.chunk) / float64(cs.rate) // seconds per amplitude
+	snr := &snrMeter{}
+	stages := []func(chan int32) chan int32{snr.stage(step)}
+	var mf *matchedFilter
+	if cfg.MatchedFilter {
+		mf = &matchedFilter{}
+		stages = append(stages, mf.stage)
+	}
+	smooth, _ := parseSmoothing(cfg.Smooth) // checked by cfg.validate
+	for _, f := range smooth {
+		stages = append(stages, f.stage(step))
+	}
+	if cfg.QSB {
+		stages = append(stages, qsbStage(cfg.QSBWindow, step))
+	}
+	if cfg.AGC {
+		stages = append(stages, agcStage(cfg.AGCAttack, cfg.AGCDecay, step))
+	}
+	tz := tokenizers[cfg.Tokenizer](cfg, step)
+	if cfg.Decoder == "bayes" {
+		tz = newBayesDecoder(newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step), cfg.TokenGroup)
+	}
+	var est *unitEstimate
+	if a, ok := tz.(windowAdapter); ok && cfg.AdaptiveWindows {
+		est = newUnitEstimate(newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step))
+		a.adapt(est)
+	}
+	newQuantizer := func(cal *calibration) func(chan int32, chan bool) {
+		switch cfg.Quantizer {
+		case "adaptive":
+			return adaptiveQuantizer(step, cal)
+		case "floor":
+			return floorQuantizer(step, cfg.FloorMargin, cfg.FloorTau, cal)
+		case "otsu":
+			return otsuQuantizer(cfg.QuantizeGroup, cal, est)
+		}
+		return windowQuantizer(cfg.QuantizeGroup, cfg.QuantizeOn, cfg.QuantizeOff, cal, est)
+	}
+	quantize := newQuantizer(nil)
+	if cfg.Calibrate > 0 {
+		quantize = calibrating(cfg.Calibrate, step, newUnitBounds(cfg.MinWPM, cfg.MaxWPM, step), newQuantizer, tz)
+	}
+	quants := getQuantizePipe(chunks, newDetector(cfg, rate, tuned), quantize, stages...)
+	idle := int32(math.Ceil(cfg.IdleFlush.Seconds() / step))
+	lengths := getRlePipe(quants, idle)
+	if cfg.QuantMedian > 1 {
+		lengths = getMedianPipe(lengths, cfg.QuantMedian, idle)
+	}
+	if cfg.ClickGuard > 0 {
+		lengths = getClickPipe(lengths, int32(math.Ceil(cfg.ClickGuard.Seconds()/step)), idle)
+	}
+	if cfg.Debounce > 0 {
+		lengths = getDebouncePipe(lengths, cfg.Debounce, idle)
+	}
+	symbols := getTokenPipe(lengths, tz, idle)
+	if mf != nil {
+		symbols = getUnitFeedbackPipe(symbols, mf)
+	}
+	symbols = getSNRPipe(symbols, snr)
+	if cfg.Squelch > 0 {
+		symbols = getSquelchPipe(symbols, cfg.Squelch, step)
+	}
+	return symbols
+}
+
+// Run a complete recording through the pipeline, returning the printed
+// form of its tokens and the messages found in it.  Chunks are sized
+// to last as long as live capture's do, whatever the recording's
+// sample rate.
+func decodeSamples(cfg *config, samples []int32, rate int) (string, []*message) {
+	n := chunkSize * rate / sampleRate
+	if n < 1 {
+		n = 1
+	}
+	chunks := make(chan []int32)
+	go func() {
+		for len(samples) >= n {
+			chunks <- samples[:n]
+			samples = samples[n:]
+		}
+		close(chunks)
+	}()
+	text := ""
+	var msgs []*message
+	var tuned tunedFreq
+	ma := newMessageAssembler(float64(n)/float64(rate), &tuned)
+	var flags *uncertainFlagger
+	if cfg.Uncertain > 0 {
+		flags = newUncertainFlagger(cfg.Uncertain)
+	}
+	dec := newTextDecoder(cfg.Uncertain)
+	if correctWords != nil {
+		dec.correct(correctWords, correctThreshold(cfg))
+	}
+	if segmentWords != nil {
+		dec.segment(segmentWords)
+	}
+	if cfg.RTL {
+		dec.isolateRTL()
+	}
+	var qa *qAnnotator
+	if cfg.QCodes != "" {
+		qa = newQAnnotator(cfg.QCodes)
+	}
+	var spell *spellChecker
+	if spellWords != nil {
+		spell = newSpellChecker(spellWords)
+	}
+	for val := range getDecodePipe(cfg, captureSettings{rate: rate, chunk: n}, chunks, &tuned) {
+		if cfg.Elements {
+			text += flags.mark(val) + render(val.tok)
+		} else {
+			text += qa.add(spell.add(dec.add(val)))
+		}
+		if m := ma.add(val); m != nil {
+			msgs = append(msgs, m)
+		}
+	}
+	text += qa.add(spell.add(dec.flush())+spell.flush()) + qa.flush()
+	if m := ma.flush(); m != nil {
+		msgs = append(msgs, m)
+	}
+	return text, msgs
+}
+
+// Return the printable form of a logical token, for printing dits and
+// dahs rather than text.
+func render(t token) string {
+	switch t {
+	case dit:
+		return "."
+	case dah:
+		return "_"
+	case endLetter:
+		return " "
+	case endWord:
+		return " : "
+	case pause:
+		return " pause "
+	case noOp:
+		return ""
+	}
+	return " ERROR "
+}
+
 func main() {
+	cfg, err := parseConfig()
+	chk(err)
+	human = newHumanFormat(cfg.Locale, cfg.UTC)
+	clampBounds, _ = parseClampBounds(cfg.ClampBounds) // checked by cfg.validate
+	morseLetters = decodeTable(cfg.Charset, cfg.Extended)
+	wabunFirst = cfg.Charset == "ja"
+	americanMorse = cfg.Charset == "american"
+	if cfg.Key != "" {
+		keying = keyTimings[cfg.Key]
+	}
+	if americanMorse {
+		if cfg.ClampBounds == defaultConfig().ClampBounds {
+			clampBounds = americanClampBounds
+		}
+		keying.dahs = false
+	}
+	if cfg.Correct || cfg.Segment || cfg.Spell {
+		dict, err := loadDictionary(cfg.Dictionary)
+		chk(err)
+		if cfg.Correct {
+			correctWords = dict
+		}
+		if cfg.Segment {
+			segmentWords = dict
+		}
+		if cfg.Spell {
+			spellWords = dict
+		}
+	}
+	if cfg.Events != "" {
+		events, err = openEventLog(cfg.Events)
+		chk(err)
+	}
+
+	var caption *captioner
+	if cfg.Caption != "" {
+		var blocked map[string]bool
+		if cfg.CaptionBlocklist != "" {
+			blocked, err = loadWordList(cfg.CaptionBlocklist)
+			chk(err)
+		}
+		caption = newCaptioner(blocked)
+		go func() {
+			chk(http.ListenAndServe(cfg.Caption, caption.handler()))
+		}()
+	}
+
+	leds, err := newStatusLEDs(cfg.LEDSignal, cfg.LEDDecoding, cfg.LEDError)
+	chk(err)
+
+	if cfg.Serve != "" {
+		chk(http.ListenAndServe(cfg.Serve, decodeHandler(cfg)))
+		return
+	}
+
+	if cfg.RBN != "" {
+		chk(compareRBN(cfg))
+		return
+	}
+
+	if cfg.Game != "" {
+		var words []string
+		if cfg.GameWords != "" {
+			list, err := loadWordList(cfg.GameWords)
+			chk(err)
+			for w := range list {
+				if checkSynthText(w) == nil {
+					words = append(words, w)
+				}
+			}
+		}
+		game, err := newGameServer(synthSettings{"", cfg.SynthWPM, cfg.SynthFreq, cfg.SynthNoise}, words, cfg.GameScores)
+		chk(err)
+		go game.run()
+		chk(http.ListenAndServe(cfg.Game, game.handler()))
+		return
+	}
+
 	// Die on Control-C
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, os.Kill)
 
+	if cfg.Watch != "" {
+		chk(watchFolder(cfg, sig))
+		return
+	}
+
 	// main input pipe:
 	chunks := make(chan []int32)
 
-	// construct main output pipe... whee!
-	output := getTokenPipe(getRlePipe(getQuantizePipe(chunks)))
-
-	// read samples from microphone, via portaudio library
-	portaudio.Initialize()
-	defer portaudio.Terminate()
-	samplechunk := make([]int32, 64)
-	stream, err := portaudio.OpenDefaultStream(1, 0, 44100, len(samplechunk), samplechunk)
-	chk(err)
-	defer stream.Close()
-	nSamples := 0
-
+	cs := normalCapture
+	if cfg.LowPower {
+		cs = lowPowerCapture
+	}
+	if cfg.Rate != 0 {
+		// Keep chunks (and so everything downstream) and buffers
+		// the same length in time.
+		cs.chunk = cs.chunk * cfg.Rate / cs.rate
+		cs.buffer = cs.buffer * cfg.Rate / cs.rate
+		cs.rate = cfg.Rate
+	}
+	cs.device = cfg.Device
+	cs.channels = cfg.Channels
+	cs.channel = cfg.Channel
+	if cfg.Diversity {
+		cs.diversity = newDiversityCombiner(cs.channels, cfg.Freq, cfg.Bandwidth, cs.rate)
+	}
+	var rec *wavWriter
+	if cfg.Record != "" {
+		rec, err = createWav(cfg.Record, cs.rate)
+		chk(err)
+		defer func() {
+			chk(rec.Close())
+		}()
+	}
+	// read samples from microphone, via portaudio library, or from
+	// the signal generator or mock band
+	capture := captureCallback
+	switch {
+	case cfg.Synth != "":
+		capture = synthCapture(synthSettings{cfg.Synth, cfg.SynthWPM, cfg.SynthFreq, cfg.SynthNoise})
+	case cfg.Mock != "":
+		script, err := loadMockScript(cfg.Mock)
+		chk(err)
+		capture = mockCapture(script)
+	case !cfg.Callback:
+		capture = captureBlocking
+	}
+	if cfg.Synth == "" && cfg.Mock == "" {
+		portaudio.Initialize()
+		defer portaudio.Terminate()
+	}
 	go func() {
-		chk(stream.Start())
-		for {
-			chk(stream.Read())
-
-			// chk(binary.Write(f, binary.BigEndian, in))
-			chunks <- samplechunk
-
-			nSamples += len(samplechunk)
-			select {
-			case <-sig:
-				return
-			default:
-			}
-		}
-		chk(stream.Stop())
+		chk(capture(cs, chunks, sig, rec))
 	}()
 
+	audio := chunks
+	if cfg.Replay != "" {
+		rb := newReplayBuffer(cs.rate, cfg.ReplayLength)
+		audio = getReplayPipe(audio, rb)
+		go func() {
+			chk(http.ListenAndServe(cfg.Replay, rb.handler(cfg)))
+		}()
+	}
+	if cfg.Waterfall != "" {
+		sg := newSpectrogram(cs.rate)
+		audio = getSpectrogramPipe(audio, sg)
+		go func() {
+			chk(http.ListenAndServe(cfg.Waterfall, sg.handler()))
+		}()
+	}
+
+	if cfg.Skimmer {
+		newSkimmer(cfg, cs).run(audio)
+		return
+	}
+
+	// construct main output pipe... whee!
+	output := getDecodePipe(cfg, cs, audio, &tuning)
+
 	// Print logical tokens from the pipeline's output
+	ma := newMessageAssembler(float64(cs.chunk)/float64(cs.rate), &tuning)
+	var strip *timingStrip
+	if cfg.Strip {
+		strip = newTimingStrip(os.Stderr, 78)
+	}
+	var budget *errorBudget
+	if cfg.AlertThreshold > 0 {
+		budget = newErrorBudget(cfg.AlertThreshold, cfg.AlertWindow, cfg.AlertWebhook)
+	}
+	var hist *histograms
+	if cfg.Diag != "" {
+		hist = new(histograms)
+		go func() {
+			chk(http.ListenAndServe(cfg.Diag, hist.handler()))
+		}()
+	}
+	var blink *blinker
+	switch cfg.Blink {
+	case "":
+	case "terminal":
+		blink = newBlinker(terminalLamp{os.Stderr}, float64(cs.chunk)/float64(cs.rate))
+	default:
+		n, _ := strconv.Atoi(cfg.Blink)
+		pin, err := openGPIO(n)
+		chk(err)
+		blink = newBlinker(pin, float64(cs.chunk)/float64(cs.rate))
+	}
+	lastSNR := time.Now()
+	lastWPM, keyed := time.Now(), false
+	var words *wordBuffer
+	if cfg.Words {
+		words = newWordBuffer(func(w string) { fmt.Print(w) })
+		defer words.flush()
+	}
+	var flags *uncertainFlagger
+	if cfg.Uncertain > 0 {
+		flags = newUncertainFlagger(cfg.Uncertain)
+	}
+	dec := newTextDecoder(cfg.Uncertain)
+	if correctWords != nil {
+		dec.correct(correctWords, correctThreshold(cfg))
+	}
+	if segmentWords != nil {
+		dec.segment(segmentWords)
+	}
+	if cfg.RTL {
+		dec.isolateRTL()
+	}
+	var qa *qAnnotator
+	if cfg.QCodes != "" {
+		qa = newQAnnotator(cfg.QCodes)
+	}
+	var spell *spellChecker
+	if spellWords != nil {
+		spell = newSpellChecker(spellWords)
+	}
+	print := func(s symbol, text string) {
+		if words != nil {
+			words.add(s, text)
+		} else {
+			fmt.Printf("%s", text)
+		}
+	}
+	var plain *plainCopy
+	if cfg.Plain != "" {
+		plain, err = openPlainCopy(cfg.Plain)
+		chk(err)
+	}
+	var dedup *dedupStore
+	if cfg.Dedup != "" {
+		dedup, err = openDedupStore(cfg.Dedup, cfg.DedupTTL)
+		chk(err)
+	}
+	spotter := &callsignSpotter{dedup: dedup}
+	var contest *contestParser
+	if cfg.Contest != "" {
+		contest = newContestParser(cfg.Contest, cfg.CutNumbers, dedup)
+	}
 	for val := range output {
-		out := ""
-		switch val {
-		case dit:
-			out = "."
-		case dah:
-			out = "_"
-		case endLetter:
-			out = " "
-		case endWord:
-			out = " : "
-		case pause:
-			out = " pause "
-		case noOp:
-			out = ""
-		default:
-			out = " ERROR "
+		if cfg.Elements {
+			print(val, flags.mark(val)+render(val.tok))
+		} else {
+			text := dec.add(val)
+			plain.add(text)
+			spotter.add(val, text)
+			contest.add(text)
+			print(val, qa.add(spell.add(text)))
+		}
+		if cfg.SNRInterval > 0 && time.Since(lastSNR) >= cfg.SNRInterval {
+			lastSNR = time.Now()
+			fmt.Fprintf(os.Stderr, "%s: SNR %s dB\n", human.time(lastSNR), human.float(val.snr, 1))
+			events.emit("snr", map[string]interface{}{"snr": val.snr})
+		}
+		// Only report the speed while a station is sending, rather
+		// than repeat the last one through a silence.
+		keyed = keyed || val.tok == dit || val.tok == dah
+		if cfg.WPMInterval > 0 && keyed && val.unit > 0 && time.Since(lastWPM) >= cfg.WPMInterval {
+			lastWPM, keyed = time.Now(), false
+			wpm := unitWPM(val.unit, float64(cs.chunk)/float64(cs.rate))
+			fmt.Fprintf(os.Stderr, "%s: %s WPM\n", human.time(lastWPM), human.float(wpm, 0))
+			events.emit("wpm", map[string]interface{}{"wpm": wpm})
 		}
-		fmt.Printf("%s", out)
+		if caption != nil {
+			caption.add(val)
+		}
+		leds.token(val.tok)
+		blink.add(val)
+		if strip != nil {
+			strip.add(val)
+		}
+		if hist != nil {
+			hist.add(val)
+		}
+		if budget != nil {
+			budget.add(val)
+		}
+		if m := ma.add(val); m != nil {
+			m.emit()
+		}
+	}
+	rest := dec.flush()
+	plain.add(rest)
+	plain.flush()
+	spotter.add(symbol{tok: pause}, rest)
+	spotter.flush()
+	contest.add(rest)
+	contest.flush()
+	if rest = qa.add(spell.add(rest)+spell.flush()) + qa.flush(); rest != "" {
+		print(symbol{tok: pause}, rest)
+	}
+	if m := ma.flush(); m != nil {
+		m.emit()
 	}
-	close(output)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Feed 'in' through the length pipe 'pipe' and return what comes out.
+func runLengths(pipe func(chan int32) chan int32, in []int32) []int32 {
+	lengths := make(chan int32)
+	go func() {
+		for _, d := range in {
+			lengths <- d
+		}
+		close(lengths)
+	}()
+	var out []int32
+	for d := range pipe(lengths) {
+		out = append(out, d)
+	}
+	return out
+}
+
+func TestMedianPipe(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		idle int32
+		in   []int32
+		want []int32
+	}{
+		{"nothing short", 5, 0, []int32{10, 3, 3, 9, 3}, []int32{10, 3, 3, 9, 3}},
+		{"flipped quant in a space", 5, 0, []int32{20, 3, 1, 4, 9}, []int32{20, 8, 9}},
+		{"two flipped quants in a mark", 5, 0, []int32{20, 9, 2, 8, 6}, []int32{20, 19, 6}},
+		{"one past half the filter survives", 5, 0, []int32{20, 9, 3, 8}, []int32{20, 9, 3, 8}},
+		{"wider filter", 9, 0, []int32{20, 9, 4, 8}, []int32{20, 21}},
+		{"flip at the end", 5, 0, []int32{20, 9, 1}, []int32{20, 10}},
+		{"nothing merged into an idle space", 5, 50, []int32{9, 50, 1, 9}, []int32{9, 50, 1, 9}},
+	}
+	for _, tt := range tests {
+		got := runLengths(func(l chan int32) chan int32 { return getMedianPipe(l, tt.n, tt.idle) }, tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v through a %d median = %v, want %v", tt.name, tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		duration int32
+		unit     float64
+		want     float32
+	}{
+		{10, 10, 1},
+		{25, 10, 2.5},
+		{55, 10, 5.5},
+		{85, 10, 8.5},
+		{7, 2.8, 2.5},
+		{3, 4, 0.75},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.duration, tt.unit); math.Abs(float64(got-tt.want)) > 1e-6 {
+			t.Errorf("normalize(%d, %v) = %v, want %v", tt.duration, tt.unit, got, tt.want)
+		}
+	}
+}
+
+// Durations either side of the clamp tokenizer's default bounds, 2, 5
+// and 8 units, with a unit of 10.  Divided in integers, 2.5, 5.5 and
+// 8.5 units would fall back across them.
+func TestClampBoundaries(t *testing.T) {
+	tests := []struct {
+		duration int32
+		mark     bool
+		want     token
+	}{
+		{15, true, dit},
+		{19, true, dit},
+		{21, true, dah},
+		{25, true, dah},
+		{45, true, dah},
+		{49, true, dah},
+		{51, true, cwError},
+		{55, true, cwError},
+
+		{15, false, noOp},
+		{19, false, noOp},
+		{21, false, endLetter},
+		{25, false, endLetter},
+		{45, false, endLetter},
+		{49, false, endLetter},
+		{51, false, endWord},
+		{55, false, endWord},
+		{75, false, endWord},
+		{79, false, endWord},
+		{81, false, pause},
+		{85, false, pause},
+	}
+	for _, tt := range tests {
+		c := newClampTokenizer(newUnitBounds(5, 60, 0.005), tokenWindow)
+		c.seed(10)
+		got := ""
+		for _, s := range c.tokenize(tt.duration, tt.mark) {
+			got += render(s.tok)
+		}
+		if got != render(tt.want) {
+			t.Errorf("%d (mark %v) at a unit of 10 tokenized as %q, want %q", tt.duration, tt.mark, got, render(tt.want))
+		}
+	}
+}
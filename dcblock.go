@@ -0,0 +1,37 @@
+// DC blocking.
+//
+// Many sound cards add a constant offset to every sample.  RMS takes
+// the offset out again by subtracting the square of the mean, but with
+// a big enough offset that subtraction of two large numbers leaves
+// mostly rounding error, and overflows besides.  A one-pole high-pass
+// filter ahead of everything else removes the offset at source,
+// leaving anything above a few tens of Hz untouched.
+
+package main
+
+import "math"
+
+// Corner frequency of the DC blocker, in Hz.
+const dcBlockFreq = 20
+
+// Read audio chunks sampled at 'rate' from 'chunks', and push them
+// with any DC offset removed onto the returned channel.
+func getDCBlockPipe(chunks chan []int32, rate int) chan []int32 {
+	blocked := make(chan []int32)
+	r := math.Exp(-2 * math.Pi * dcBlockFreq / float64(rate))
+	go func() {
+		var x1, y1 float64 // previous input and output
+		for chunk := range chunks {
+			out := make([]int32, len(chunk))
+			for i, v := range chunk {
+				x := float64(v)
+				y := x - x1 + r*y1
+				x1, y1 = x, y
+				out[i] = int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, y)))
+			}
+			blocked <- out
+		}
+		close(blocked)
+	}()
+	return blocked
+}
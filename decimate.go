@@ -0,0 +1,46 @@
+// Decimation after the prefilter.
+//
+// Once the prefilter has cut the audio down to a couple of hundred Hz
+// around the tone, almost all of the 44.1kHz sample rate carries
+// nothing.  Keeping only every Nth sample from there on divides the
+// cost of every later stage by N, which is the difference between
+// keeping up and not on a Pi Zero.  No further anti-alias filter is
+// needed: the prefilter already is one, so long as its band stays
+// under the new Nyquist frequency.
+
+package main
+
+// Return the largest decimation factor no greater than 'want' which
+// keeps 'top' Hz, the upper edge of the prefilter's band, comfortably
+// under the Nyquist frequency of audio sampled at 'rate', and divides
+// 'chunk' samples evenly.
+func decimation(want, rate, chunk int, top float64) int {
+	for n := want; n > 1; n-- {
+		if chunk%n == 0 && top < 0.8*float64(rate)/float64(2*n) {
+			return n
+		}
+	}
+	return 1
+}
+
+// Read audio chunks from 'chunks', keep every 'n'th sample, and push
+// the chunks, 1/n of the size, onto the returned channel.
+func getDecimatePipe(chunks chan []int32, n int) chan []int32 {
+	decimated := make(chan []int32)
+	go func() {
+		phase := 0 // samples to skip before the next one kept
+		for chunk := range chunks {
+			out := make([]int32, 0, len(chunk)/n+1)
+			for _, v := range chunk {
+				if phase == 0 {
+					out = append(out, v)
+					phase = n
+				}
+				phase--
+			}
+			decimated <- out
+		}
+		close(decimated)
+	}()
+	return decimated
+}
@@ -0,0 +1,84 @@
+// Persistent duplicate suppression for things posted to the outside
+// world, such as spots and logged contacts.
+//
+// Reposting the same spot, or appending the same contact to a log
+// twice, is a nuisance to everyone downstream, and a restart of the
+// service mustn't forget what it has already sent.  So the store
+// records each identifier with the time it was first seen, in a small
+// JSON file rewritten on every change, and forgets identifiers once
+// they're older than its time to live.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+type dedupStore struct {
+	mu   sync.Mutex
+	path string
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+// Open the store kept in 'path', creating it if need be, which
+// remembers identifiers for 'ttl'.
+func openDedupStore(path string, ttl time.Duration) (*dedupStore, error) {
+	d := &dedupStore{path: path, ttl: ttl, seen: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &d.seen); err != nil {
+		return nil, err
+	}
+	d.expire(time.Now())
+	return d, nil
+}
+
+// Record 'id', and report whether it's new.  A nil store has seen
+// nothing.
+func (d *dedupStore) add(id string) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.expire(now)
+	if _, ok := d.seen[id]; ok {
+		return false
+	}
+	d.seen[id] = now
+	d.save()
+	return true
+}
+
+func (d *dedupStore) expire(now time.Time) {
+	for id, t := range d.seen {
+		if now.Sub(t) > d.ttl {
+			delete(d.seen, id)
+		}
+	}
+}
+
+// Write the store out.  It's replaced by renaming, so a crash midway
+// leaves the old one intact.  Failures are ignored: at worst, something
+// gets posted twice.
+func (d *dedupStore) save() {
+	data, err := json.Marshal(d.seen)
+	if err != nil {
+		return
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return
+	}
+	os.Rename(tmp, d.path)
+}
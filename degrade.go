@@ -0,0 +1,89 @@
+// Graceful degradation under CPU pressure.
+//
+// On a small embedded board the optional DSP stages can cost more CPU
+// than there is.  Rather than fall ever further behind real time and
+// eventually drop audio, the decoder steps down a ladder of cheaper
+// settings while capture is backing up, and climbs back once it has
+// been keeping up comfortably for a while.  Each step is reported as
+// an event.
+//
+// The pressure gauge is the backlog of captured samples waiting in the
+// capture ring buffer, so this only operates in callback capture mode.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The ladder, cheapest saving first.  Stages consult load.level() to
+// see how far down it they should be.
+var degradeSteps = []string{
+	"auto-tune scanning and drift tracking paused",
+	"detector hop widened to 2 chunks",
+	"detector hop widened to 4 chunks",
+	"prefilter bypassed",
+	"weakest skimmer channel retired each second",
+}
+
+const (
+	degradeAbove  = 0.5              // backlog, as a fraction of the ring, that triggers a step down
+	restoreBelow  = 0.1              // backlog under which we're keeping up comfortably...
+	restoreAfter  = 10 * time.Second // ...for this long before stepping back up
+	degradeSettle = 2 * time.Second  // time to let a step take effect before taking another
+)
+
+type degradation struct {
+	lvl     int32 // steps taken down the ladder; accessed atomically
+	changed time.Time
+	calm    time.Time // when the backlog last went under restoreBelow
+}
+
+// Process-wide degradation state.
+var load degradation
+
+func (d *degradation) level() int {
+	return int(atomic.LoadInt32(&d.lvl))
+}
+
+// Report the capture backlog: 'pending' samples waiting out of a ring
+// of 'capacity'.  Called by the capture goroutine only.
+func (d *degradation) observe(pending, capacity int) {
+	now := time.Now()
+	fill := float64(pending) / float64(capacity)
+	lvl := d.level()
+	if fill >= restoreBelow {
+		d.calm = time.Time{}
+	} else if d.calm.IsZero() {
+		d.calm = now
+	}
+	if now.Sub(d.changed) < degradeSettle {
+		return
+	}
+	switch {
+	case fill > degradeAbove && lvl < len(degradeSteps):
+		d.set(lvl+1, now)
+		d.report("degraded", degradeSteps[lvl], fill)
+	case lvl > 0 && !d.calm.IsZero() && now.Sub(d.calm) > restoreAfter:
+		d.set(lvl-1, now)
+		d.calm = now
+		d.report("restored", degradeSteps[lvl-1], fill)
+	}
+}
+
+func (d *degradation) set(lvl int, now time.Time) {
+	atomic.StoreInt32(&d.lvl, int32(lvl))
+	d.changed = now
+}
+
+func (d *degradation) report(kind, step string, fill float64) {
+	fmt.Fprintf(os.Stderr, "%s: %s: %s\n", human.time(time.Now()), kind, step)
+	events.emit(kind, map[string]interface{}{
+		"step":    step,
+		"level":   d.level(),
+		"backlog": fill,
+	})
+}
@@ -0,0 +1,131 @@
+// Spectral subtraction, for recordings buried in steady hiss.
+//
+// The audio is cut into overlapping frames, and each frame's spectrum
+// compared with an estimate of the noise's: bins well above the noise
+// keep their level, bins near it are turned down, and the frames are
+// put back together.  A tone standing a few dB out of the hiss comes
+// through much cleaner, at the cost of a little "musical" noise.
+//
+// The noise spectrum is estimated by minimum statistics: the smoothed
+// power in each bin falls to its quietest level between the marks of a
+// CW signal, and the estimate follows those minima, creeping upward
+// slowly so that it can follow hiss that gets louder.
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+const (
+	denoiseFrame = 0.025 // seconds per frame, roughly
+	denoiseOver  = 2.0   // how much of the noise estimate to subtract
+	denoiseBias  = 4.0   // mean noise power over its smoothed minimum
+	denoiseFloor = 0.1   // least gain applied to any bin
+	denoiseRise  = 1.5   // dB per second the noise estimate may creep up
+)
+
+type spectralSubtractor struct {
+	size, hop int
+	window    []float64 // square root of a periodic Hann window
+	frame     []float64 // the most recent 'size' input samples
+	overlap   []float64 // output waiting for the next frames to be added in
+	power     []float64 // smoothed power per bin
+	noise     []float64 // noise power estimate per bin
+	rise      float64   // per frame
+	buf       []complex128
+}
+
+func newSpectralSubtractor(rate int) *spectralSubtractor {
+	size := nextPow2(int(denoiseFrame * float64(rate)))
+	s := &spectralSubtractor{
+		size:    size,
+		hop:     size / 2,
+		window:  make([]float64, size),
+		frame:   make([]float64, size),
+		overlap: make([]float64, size),
+		power:   make([]float64, size/2+1),
+		noise:   make([]float64, size/2+1),
+		buf:     make([]complex128, size),
+	}
+	// With 50% overlap, windowing both before and after the
+	// transform with the square root of a Hann window reassembles
+	// the frames exactly.
+	for i := range s.window {
+		s.window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size)))
+	}
+	hopSeconds := float64(s.hop) / float64(rate)
+	s.rise = math.Pow(10, denoiseRise*hopSeconds/10)
+	for k := range s.noise {
+		s.noise[k] = -1 // no estimate yet
+	}
+	return s
+}
+
+// Take the next s.hop input samples, and return the next s.hop output
+// samples, one frame behind.
+func (s *spectralSubtractor) process(in []float64) []float64 {
+	copy(s.frame, s.frame[s.hop:])
+	copy(s.frame[s.size-s.hop:], in)
+	for i, v := range s.frame {
+		s.buf[i] = complex(v*s.window[i], 0)
+	}
+	fft(s.buf, false)
+	for k := 0; k <= s.size/2; k++ {
+		p := real(s.buf[k])*real(s.buf[k]) + imag(s.buf[k])*imag(s.buf[k])
+		s.power[k] = 0.8*s.power[k] + 0.2*p
+		switch {
+		case s.noise[k] < 0 || s.power[k] < s.noise[k]:
+			s.noise[k] = s.power[k]
+		default:
+			s.noise[k] *= s.rise
+		}
+		gain := denoiseFloor
+		if p > 0 {
+			gain = math.Sqrt(math.Max(1-denoiseOver*denoiseBias*s.noise[k]/p, denoiseFloor*denoiseFloor))
+		}
+		s.buf[k] *= complex(gain, 0)
+		if k > 0 && k < s.size/2 {
+			s.buf[s.size-k] = cmplx.Conj(s.buf[k])
+		}
+	}
+	fft(s.buf, true)
+	for i := range s.overlap {
+		s.overlap[i] += real(s.buf[i]) * s.window[i]
+	}
+	out := make([]float64, s.hop)
+	copy(out, s.overlap)
+	copy(s.overlap, s.overlap[s.hop:])
+	for i := s.size - s.hop; i < s.size; i++ {
+		s.overlap[i] = 0
+	}
+	return out
+}
+
+// Read audio chunks from 'chunks', denoise them with 's', and push
+// chunks of the same size onto the returned channel.
+func getDenoisePipe(chunks chan []int32, s *spectralSubtractor) chan []int32 {
+	denoised := make(chan []int32)
+	go func() {
+		var pending []float64
+		var out []int32
+		for chunk := range chunks {
+			for _, v := range chunk {
+				pending = append(pending, float64(v))
+			}
+			for len(pending) >= s.hop {
+				for _, v := range s.process(pending[:s.hop]) {
+					out = append(out, int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, v))))
+				}
+				pending = pending[s.hop:]
+			}
+			for len(out) >= len(chunk) {
+				denoised <- out[:len(chunk):len(chunk)]
+				out = out[len(chunk):]
+			}
+		}
+		close(denoised)
+	}()
+	return denoised
+}
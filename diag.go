@@ -0,0 +1,137 @@
+// Timing diagnostics over HTTP: live histograms of mark and space
+// durations, normalized to the current unit, with the classifier's
+// decision boundaries drawn over them.  If the humps don't sit neatly
+// between the lines, that's why the decode is going wrong.
+//
+// The page at / draws the histograms; /histogram serves the numbers
+// as JSON.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	histBinsPerUnit = 4
+	histUnits       = 12  // longer durations all land in the last bin
+	histHistory     = 400 // durations of each kind remembered
+)
+
+type histograms struct {
+	mu     sync.Mutex
+	marks  []float64 // recent normalized durations
+	spaces []float64
+}
+
+type histogramReport struct {
+	BinWidth   float64   `json:"bin_width"` // in units
+	Marks      []int     `json:"marks"`
+	Spaces     []int     `json:"spaces"`
+	Boundaries []float32 `json:"boundaries"` // in units
+}
+
+func (h *histograms) add(s symbol) {
+	if s.unit <= 0 {
+		return
+	}
+	x := float64(s.duration) / float64(s.unit)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch s.tok {
+	case dit, dah, cwError:
+		h.marks = appendRecent(h.marks, x)
+	default:
+		h.spaces = appendRecent(h.spaces, x)
+	}
+}
+
+func appendRecent(xs []float64, x float64) []float64 {
+	if len(xs) == histHistory {
+		xs = append(xs[:0], xs[1:]...)
+	}
+	return append(xs, x)
+}
+
+func binDurations(xs []float64) []int {
+	bins := make([]int, histBinsPerUnit*histUnits)
+	for _, x := range xs {
+		b := int(x * histBinsPerUnit)
+		if b >= len(bins) {
+			b = len(bins) - 1
+		}
+		bins[b]++
+	}
+	return bins
+}
+
+func (h *histograms) report() histogramReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return histogramReport{
+		BinWidth:   1.0 / histBinsPerUnit,
+		Marks:      binDurations(h.marks),
+		Spaces:     binDurations(h.spaces),
+		Boundaries: clampBounds[:],
+	}
+}
+
+func (h *histograms) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, histogramPage)
+	})
+	mux.HandleFunc("/histogram", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.report())
+	})
+	return mux
+}
+
+const histogramPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Morse decoder timing</title>
+<style>
+body { font-family: sans-serif; }
+canvas { border: 1px solid #ccc; display: block; margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<h3>Marks</h3><canvas id="marks" width="960" height="200"></canvas>
+<h3>Spaces</h3><canvas id="spaces" width="960" height="200"></canvas>
+<script>
+function draw(id, bins, binWidth, bounds) {
+  var c = document.getElementById(id), g = c.getContext("2d");
+  var w = c.width / bins.length, max = Math.max.apply(null, bins) || 1;
+  g.clearRect(0, 0, c.width, c.height);
+  g.fillStyle = "#36c";
+  bins.forEach(function(n, i) {
+    var h = n / max * (c.height - 20);
+    g.fillRect(i * w + 1, c.height - 20 - h, w - 2, h);
+  });
+  g.fillStyle = "#000";
+  for (var u = 0; u < bins.length * binWidth; u++) {
+    g.fillText(u, u / binWidth * w, c.height - 5);
+  }
+  g.strokeStyle = "#c00";
+  bounds.forEach(function(b) {
+    var x = b / binWidth * w;
+    g.beginPath(); g.moveTo(x, 0); g.lineTo(x, c.height - 20); g.stroke();
+  });
+}
+function update() {
+  fetch("/histogram").then(function(r) { return r.json(); }).then(function(h) {
+    draw("marks", h.marks, h.bin_width, h.boundaries);
+    draw("spaces", h.spaces, h.bin_width, h.boundaries);
+  });
+}
+update();
+setInterval(update, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,198 @@
+// Dictionary-assisted correction.
+//
+// A skilled copyist who hears "CQ DE W1AW PSE K" with a crackle over
+// the E of "PSE" doesn't write down a garbled letter: there's only one
+// word it can have been.  The corrector does the same.  It holds each
+// word until its end, and if any of its letters was doubtful (the
+// tokenizer wasn't sure of its timing, or it didn't decode at all),
+// looks for a dictionary word of the same length that agrees with all
+// the sure letters.  The doubtful letters are scored by how many dits
+// and dahs would have to change to turn what was heard into the
+// dictionary's letter; the word needing fewest changes wins, provided
+// no other word needs as few and it doesn't need too many.
+//
+// The built-in dictionary is the usual ham abbreviations, Q codes and
+// the commonest English words, and more can be added from a file.
+
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// Confidence below which a letter is doubtful, unless -uncertain
+	// sets another.
+	correctDoubt = 0.5
+
+	// Most dits and dahs that may change, per doubtful letter, for a
+	// correction to be trusted.
+	correctMaxCost = 2
+)
+
+// Dictionary words to correct against, set by main if -correct is
+// given.
+var correctWords *dictionary
+
+// Return the confidence below which a letter is doubtful.
+func correctThreshold(cfg *config) float64 {
+	if cfg.Uncertain > 0 {
+		return cfg.Uncertain
+	}
+	return correctDoubt
+}
+
+// A decoded letter awaiting the end of its word.
+type decodedLetter struct {
+	text    string // the character, prosign or undecodable pattern
+	pattern string // its dits and dahs, as in textDecoder.letter
+	flag    string // printed after it: "?" if flagged uncertain
+	doubt   bool   // whether correction may change it
+
+	// 0 to 1: how sure the tokenizer was of its elements and gaps.
+	confidence float64
+
+	// The chance that the gap after it was a word gap, if the
+	// tokenizer was in doubt; 0 if it was sure of a letter gap.
+	wordGap float64
+}
+
+type dictionary struct {
+	known   map[string]bool
+	byCount map[int][][]rune // the known words by their number of letters
+}
+
+// Make a dictionary of the built-in words and those of 'extra'.
+// Words are uppercased; any with a character not in the Morse table
+// are left out.
+func newDictionary(extra map[string]bool) *dictionary {
+	d := &dictionary{known: make(map[string]bool), byCount: make(map[int][][]rune)}
+	add := func(w string) {
+		w = strings.ToUpper(w)
+		if d.known[w] {
+			return
+		}
+		for _, r := range w {
+			if _, ok := morseTable[r]; !ok {
+				return
+			}
+		}
+		d.known[w] = true
+		n := len([]rune(w))
+		d.byCount[n] = append(d.byCount[n], []rune(w))
+	}
+	for _, w := range strings.Fields(builtinWords) {
+		add(w)
+	}
+	for w := range extra {
+		add(w)
+	}
+	return d
+}
+
+// Read the built-in dictionary and, if 'filename' isn't empty, the
+// words listed in it.
+func loadDictionary(filename string) (*dictionary, error) {
+	if filename == "" {
+		return newDictionary(nil), nil
+	}
+	extra, err := loadWordList(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newDictionary(extra), nil
+}
+
+// Correct the doubtful letters of the word 'letters' in place, if a
+// dictionary word accounts for them.
+func (d *dictionary) correct(letters []decodedLetter) {
+	doubts := 0
+	var heard strings.Builder
+	for _, l := range letters {
+		heard.WriteString(l.text)
+		if l.doubt {
+			doubts++
+		}
+	}
+	// A single letter has no other letters to give it context.
+	if doubts == 0 || len(letters) < 2 || d.known[heard.String()] {
+		return
+	}
+	var best []rune
+	bestCost, tied := correctMaxCost*doubts+1, false
+words:
+	for _, w := range d.byCount[len(letters)] {
+		cost := 0
+		for i, r := range w {
+			if !letters[i].doubt {
+				if string(r) != letters[i].text {
+					continue words
+				}
+				continue
+			}
+			cost += editDistance(letters[i].pattern, morseTable[r])
+		}
+		switch {
+		case cost < bestCost:
+			best, bestCost, tied = w, cost, false
+		case cost == bestCost:
+			tied = true
+		}
+	}
+	if best == nil || tied {
+		return
+	}
+	for i, r := range best {
+		if letters[i].doubt {
+			letters[i].text, letters[i].flag = string(r), ""
+		}
+	}
+}
+
+// Return the least number of dits and dahs to insert, delete or swap
+// to turn pattern 'a' into 'b'.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur[j] = prev[j-1]
+			if a[i-1] != b[j-1] {
+				cur[j]++
+			}
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Ham abbreviations, Q codes and common English words.
+const builtinWords = `
+	73 88 599 5NN
+	ABT ADR AGN ANT BCNU BK BN BURO CFM CK CL CPY CQ CUAGN CUL CW DE DR
+	DX ES FB FER FM GA GE GL GM GN GUD HI HPE HR HW INFO LID MNI MSG
+	NR NW OB OM OP PSE PWR RCVD RIG RPT RST SIG SKED SRI STN TEST TKS
+	TNX TU UR VY WID WKD WPM WX XCVR XMTR XYL YL YRS
+	QRG QRK QRL QRM QRN QRO QRP QRQ QRS QRT QRU QRV QRX QRZ QSB QSL
+	QSO QSP QSY QTH QTR
+	ABOUT AFTER AGAIN ALL ALSO AM AN AND ANY ARE AS AT BACK BAND BE
+	BEEN BEST BUT BY CALL CAN COME COPY DAY DID DO DOWN EACH FINE FOR
+	FROM GET GO GOOD GOING HAD HAS HAVE HE HEAR HER HERE HIM HIS HOME
+	HOPE HOW IF IN INTO IS IT ITS JUST KEY KNOW LIKE LITTLE LONG LOOK
+	MADE MAKE MANY ME MORE MUCH MY NAME NEW NEXT NICE NO NOT NOW OF
+	OFF OLD ON ONE ONLY OR OTHER OUR OUT OVER PLEASE POWER RADIO RAIN
+	REPORT RIGHT SAID SEE SHE SIGNAL SO SOME SOON STATION SUN TAKE
+	THANK THANKS THAT THE THEIR THEM THEN THERE THESE THEY THIS TIME
+	TO TODAY TOO TWO UP US VERY WANT WAS WAY WE WEATHER WELL WERE
+	WHAT WHEN WHERE WHICH WHO WILL WITH WORK WOULD YEAR YES YOU YOUR
+`
@@ -0,0 +1,127 @@
+// Diversity reception.
+//
+// Two receivers, or one receiver on two antennas a few wavelengths
+// apart, fade independently: selective fading that wipes out a signal
+// on one seldom does so on the other at the same moment.  Captured as
+// the two channels of a stereo input, they can be combined to ride
+// through the fades.
+//
+// Blending the audio itself is risky, since the two copies of the tone
+// arrive in no particular phase and can cancel.  So the combiner picks:
+// it measures the tone's level over each channel's own noise floor and
+// passes on whichever channel hears it better, crossfading over a few
+// milliseconds when it changes its mind so the switch doesn't click.
+// Each channel is scaled so that its noise floor matches the others',
+// so a switch doesn't jump the level either.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// How long the combiner takes to swap channels.
+	diversityCrossfade = 10 * time.Millisecond
+
+	// Time constant over which each channel's tone level is judged.
+	diversitySmoothing = 100 * time.Millisecond
+
+	// How much better another channel must hear the tone before the
+	// combiner switches to it: 3 dB.
+	diversityMargin = math.Sqrt2
+)
+
+type diversityCombiner struct {
+	rate      int
+	detectors []*goertzel // one per channel
+	level     []float64   // each channel's tone level, smoothed
+	floor     []float64   // and its noise floor
+	weight    []float64   // each channel's share of the output
+	pick      int         // the channel being faded to
+	fade      float64     // weight moved per sample while crossfading
+	split     [][]int32   // each channel's samples from the last frames
+}
+
+// Make a combiner for 'channels' channels sampled at 'rate', judging
+// them by a tone of 'freq' Hz in a bin 'bandwidth' Hz wide.
+func newDiversityCombiner(channels int, freq, bandwidth float64, rate int) *diversityCombiner {
+	d := &diversityCombiner{
+		rate:   rate,
+		level:  make([]float64, channels),
+		floor:  make([]float64, channels),
+		weight: make([]float64, channels),
+		fade:   1 / (diversityCrossfade.Seconds() * float64(rate)),
+		split:  make([][]int32, channels),
+	}
+	for i := 0; i < channels; i++ {
+		d.detectors = append(d.detectors, newGoertzel(freq, bandwidth, rate))
+	}
+	d.weight[0] = 1
+	return d
+}
+
+// Combine the interleaved 'frames' into 'mono', returning the filled
+// part of 'mono'.  Runs on portaudio's callback thread, so it mustn't
+// block.
+func (d *diversityCombiner) combine(mono, frames []int32) []int32 {
+	channels := len(d.detectors)
+	n := len(frames) / channels
+	step := float64(n) / float64(d.rate)
+	smooth := smoothing(diversitySmoothing, step)
+	fast := smoothing(levelFast, step)
+	slow := smoothing(levelSlow, step)
+
+	// Follow the detector if auto-tune or drift tracking moves it.
+	freq := tuning.get()
+	var ref float64 // the mean noise floor, which every channel is scaled to
+	for c, g := range d.detectors {
+		if cap(d.split[c]) < n {
+			d.split[c] = make([]int32, n)
+		}
+		d.split[c] = pickChannel(d.split[c][:n], frames, channels, c)
+		if freq > 0 && freq != g.freq {
+			g.tune(freq)
+		}
+		a := float64(g.amplitude(d.split[c]))
+		d.level[c] += smooth * (a - d.level[c])
+		// The floor follows the dips between elements, so it takes
+		// the amplitudes as they come.
+		if d.floor[c] == 0 || a < d.floor[c] {
+			d.floor[c] += fast * (a - d.floor[c])
+		} else {
+			d.floor[c] += slow * (a - d.floor[c])
+		}
+		ref += d.floor[c] / float64(channels)
+	}
+	snr := func(c int) float64 {
+		return d.level[c] / math.Max(d.floor[c], 1)
+	}
+	for c := range d.detectors {
+		if snr(c) > diversityMargin*snr(d.pick) {
+			d.pick = c
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		var v float64
+		for c := range d.detectors {
+			if c == d.pick {
+				d.weight[c] = math.Min(d.weight[c]+d.fade, 1)
+			} else {
+				d.weight[c] = math.Max(d.weight[c]-d.fade, 0)
+			}
+			if d.weight[c] == 0 {
+				continue
+			}
+			gain := 1.0
+			if d.floor[c] >= 1 && ref >= 1 {
+				gain = ref / d.floor[c]
+			}
+			v += d.weight[c] * gain * float64(d.split[c][i])
+		}
+		mono[i] = int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, v)))
+	}
+	return mono[:n]
+}
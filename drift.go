@@ -0,0 +1,81 @@
+// Tone drift tracking.
+//
+// An older rig, or a VFO still warming up, can drift tens of Hz over a
+// long transmission: out of the Goertzel detector's bin, so that the
+// signal slowly fades away.  Auto-tune would catch it eventually, but
+// it jumps to whatever is strongest in its range, which may well be
+// somebody else.  The drift tracker instead keeps the detector centred
+// on the tone it already has.  While the key is down it measures the
+// tone half a bin either side of the centre, and nudges the centre
+// toward the stronger side.  It never strays further than a set limit
+// from wherever the detector was put, by hand or by auto-tune.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	driftGain = 0.02            // fraction of the measured offset corrected per chunk
+	driftHold = 3 * time.Second // time constant of the key-down and noise levels
+	driftSNR  = 4               // key-down level over noise needed before tracking: 12 dB
+)
+
+type driftTracker struct {
+	det         detector // 'g', or an auto-tuner driving it
+	g           *goertzel
+	tuned       *tunedFreq
+	limit       float64 // Hz either side of 'origin'
+	origin      float64 // where the detector was put
+	freq        float64 // where we last left it
+	reported    float64 // where it was at the last event
+	peak, floor float64 // key-down and noise levels
+}
+
+// Wrap 'det', which measures amplitudes with 'g', so that 'g' follows
+// its tone up to 'limit' Hz either way, keeping 'tuned' up to date.
+func newDriftTracker(det detector, g *goertzel, limit float64, tuned *tunedFreq) *driftTracker {
+	return &driftTracker{det: det, g: g, tuned: tuned, limit: limit, origin: g.freq, freq: g.freq, reported: g.freq}
+}
+
+func (d *driftTracker) amplitude(chunk []int32) int32 {
+	amp := d.det.amplitude(chunk)
+	if d.g.freq != d.freq {
+		// Auto-tune moved the detector; start afresh from there.
+		d.origin, d.freq, d.reported = d.g.freq, d.g.freq, d.g.freq
+	}
+
+	a := float64(amp)
+	decay := math.Exp(-float64(len(chunk)) / float64(d.g.rate) / driftHold.Seconds())
+	d.peak = math.Max(a, d.peak*decay)
+	if a < d.floor || d.floor == 0 {
+		d.floor = a
+	} else {
+		d.floor += (a - d.floor) * (1 - decay)
+	}
+	if load.level() >= 1 || a < d.peak/2 || d.peak < driftSNR*d.floor {
+		return amp
+	}
+
+	offset := float64(d.g.rate) / float64(len(d.g.window)) / 2
+	hi := d.g.magnitude(goertzelCoeff(d.freq+offset, d.g.rate))
+	lo := d.g.magnitude(goertzelCoeff(d.freq-offset, d.g.rate))
+	if hi+lo == 0 {
+		return amp
+	}
+	f := d.freq + driftGain*offset*(hi-lo)/(hi+lo)
+	f = math.Max(d.origin-d.limit, math.Min(d.origin+d.limit, f))
+	d.g.tune(f)
+	d.freq = f
+	d.tuned.set(f)
+	if math.Abs(f-d.reported) >= offset/2 {
+		d.reported = f
+		events.emit("drifted", map[string]interface{}{
+			"freq":  f,
+			"drift": f - d.origin,
+		})
+	}
+	return amp
+}
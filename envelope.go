@@ -0,0 +1,36 @@
+// Envelope-follower detector.
+//
+// The classic analogue CW detector: rectify the audio and smooth it
+// with a low-pass filter.  Unlike RMS, which starts afresh with each
+// chunk, the follower's output moves smoothly from chunk to chunk, so
+// key-down and key-up edges reach the quantizer as clean ramps rather
+// than steps of whatever portion of a chunk the edge fell in; that
+// matters most at high speeds, where a dit is only a few chunks long.
+// The time constant trades that smoothness against how quickly the
+// edges show.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+type envelopeFollower struct {
+	coeff float64 // smoothing per sample
+	env   float64
+}
+
+// Make a follower with time constant 'tau', for audio sampled at
+// 'rate'.
+func newEnvelopeFollower(tau time.Duration, rate int) *envelopeFollower {
+	return &envelopeFollower{coeff: smoothing(tau, 1/float64(rate))}
+}
+
+func (e *envelopeFollower) amplitude(chunk []int32) int32 {
+	for _, v := range chunk {
+		e.env += e.coeff * (math.Abs(float64(v)) - e.env)
+	}
+	// The mean of a rectified sine is 2/pi of its peak.
+	return int32(math.Min(e.env*math.Pi/2, math.MaxInt32))
+}
@@ -0,0 +1,56 @@
+// Structured events, one JSON object per line, so that other programs
+// can follow what the decoder is doing.
+//
+// Every event has a "time" and an "event" type; the rest of its fields
+// depend on the type.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type eventLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Where events go; nil (the default) throws them away.  Set up by main.
+var events *eventLog
+
+// Open the event log at 'filename', appending to it; "-" means
+// standard error.
+func openEventLog(filename string) (*eventLog, error) {
+	if filename == "-" {
+		return &eventLog{w: os.Stderr}, nil
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{w: f}, nil
+}
+
+func (l *eventLog) emit(kind string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	e := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"event": kind,
+	}
+	for k, v := range fields {
+		e[k] = v
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	l.w.Write(append(data, '\n'))
+	l.mu.Unlock()
+}
@@ -0,0 +1,98 @@
+// A/B experiments for DSP changes.
+//
+// Before switching a stage to a new implementation, say RMS to
+// Goertzel, it helps to know how differently the two behave on real
+// signals.  An experiment runs a second detector alongside the one in
+// use, on exactly the same chunks, and reports how far their
+// amplitudes diverge.  The decode itself only ever uses the first.
+//
+// The two detectors needn't work in the same units, so the second's
+// amplitudes are scaled to the first's mean before they're compared.
+// Reports go to standard error and the event log, every so often and
+// when the stream ends.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Amplitudes compared per report.
+const experimentReportEvery = 10000
+
+type experiment struct {
+	a, b   detector
+	name   string // e.g. "rms/goertzel"
+	n      int
+	sa, sb float64 // sums of amplitudes...
+	saa    float64 // ...of their squares...
+	sbb    float64
+	sab    float64 // ...and of their products
+	pairs  [][2]float64
+}
+
+func newExperiment(a, b detector, name string) *experiment {
+	return &experiment{a: a, b: b, name: name}
+}
+
+func (e *experiment) amplitude(chunk []int32) int32 {
+	amp := e.a.amplitude(chunk)
+	x, y := float64(amp), float64(e.b.amplitude(chunk))
+	e.n++
+	e.sa += x
+	e.sb += y
+	e.saa += x * x
+	e.sbb += y * y
+	e.sab += x * y
+	e.pairs = append(e.pairs, [2]float64{x, y})
+	if e.n >= experimentReportEvery {
+		e.report()
+	}
+	return amp
+}
+
+// Report the divergence over the amplitudes seen since the last
+// report, and start afresh.
+func (e *experiment) report() {
+	if e.n == 0 {
+		return
+	}
+	n := float64(e.n)
+	corr := 0.0
+	if va, vb := n*e.saa-e.sa*e.sa, n*e.sbb-e.sb*e.sb; va > 0 && vb > 0 {
+		corr = (n*e.sab - e.sa*e.sb) / math.Sqrt(va*vb)
+	}
+	scale := 0.0
+	if e.sb > 0 {
+		scale = e.sa / e.sb
+	}
+	// Divergence of each scaled pair, relative to the first
+	// detector's mean amplitude.
+	var sq, worst float64
+	mean := e.sa / n
+	for _, p := range e.pairs {
+		d := math.Abs(p[0] - scale*p[1])
+		sq += d * d
+		worst = math.Max(worst, d)
+	}
+	rel := 0.0
+	if mean > 0 {
+		rel = math.Sqrt(sq/n) / mean
+		worst /= mean
+	}
+	fmt.Fprintf(os.Stderr, "%s: experiment %s: %s amplitudes, correlation %s, scale %s, rms divergence %s%%, worst %s%%\n",
+		human.time(time.Now()), e.name, human.int(int64(e.n)), human.float(corr, 3),
+		human.float(scale, 3), human.float(rel*100, 1), human.float(worst*100, 1))
+	events.emit("experiment", map[string]interface{}{
+		"name":           e.name,
+		"amplitudes":     e.n,
+		"correlation":    corr,
+		"scale":          scale,
+		"rms_divergence": rel,
+		"worst":          worst,
+	})
+	*e = experiment{a: e.a, b: e.b, name: e.name, pairs: e.pairs[:0]}
+}
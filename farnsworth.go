@@ -0,0 +1,69 @@
+// Farnsworth spacing.
+//
+// Learners are often sent characters at a speed they'll eventually
+// copy, say 18 WPM, with the gaps between characters and words
+// stretched to bring the overall speed down to 5 or 10 WPM.  The
+// elements then give a unit of 18 WPM, against which a stretched
+// letter gap looks like a word gap, and a stretched word gap like a
+// pause.
+//
+// So the gaps longer than those between elements are measured
+// separately.  Letter gaps far outnumber word gaps, so the shorter
+// among them are letter gaps, and ought to be 3 units long.  How much
+// longer than that they really are is the stretch, and letter and word
+// gaps are judged in units stretched by it.  Textbook spacing has a
+// stretch of 1, and is judged exactly as before.
+
+package main
+
+import "sort"
+
+const (
+	// Letter and word gaps the stretch is estimated from.
+	farnsworthWindow = 16
+
+	// Fewest gaps to estimate the stretch from.
+	farnsworthMin = 4
+)
+
+// Return how many times longer than textbook spacing the letter gaps
+// among 'gaps', in units, are.  Never less than 1: letter gaps that run
+// short are the clamp boundaries' business.
+func farnsworthStretch(gaps []float64) float64 {
+	if len(gaps) < farnsworthMin {
+		return 1
+	}
+	sorted := append([]float64(nil), gaps...)
+	sort.Float64s(sorted)
+	// Like calculateUnitDuration's 25th percentile, this keeps well
+	// clear of the word gaps.
+	letter := sorted[len(sorted)/4]
+	if letter < 3 {
+		return 1
+	}
+	return letter / 3
+}
+
+// Track the stretch of the latest letter and word gaps.
+type spacingEstimate struct {
+	gaps []float64 // the last farnsworthWindow gaps, in units
+}
+
+// Take a space of 'x' units, and return it in stretched units if it's
+// long enough to be a letter or word gap ('x' beyond 'element').  A
+// space the stretch brings down to 'element' or less is still longer
+// than an element gap, so it's returned as just over 'element'.
+func (s *spacingEstimate) space(x, element float64) float64 {
+	if x <= element {
+		return x
+	}
+	s.gaps = append(s.gaps, x)
+	if len(s.gaps) > farnsworthWindow {
+		s.gaps = s.gaps[len(s.gaps)-farnsworthWindow:]
+	}
+	stretched := x / farnsworthStretch(s.gaps)
+	if stretched <= element {
+		stretched = element * 1.01
+	}
+	return stretched
+}
@@ -0,0 +1,56 @@
+// Fast Fourier transform.
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Transform 'x' in place with an iterative radix-2 FFT; len(x) must be
+// a power of two.  The inverse transform is scaled by 1/len(x), so
+// that fft(x, true) undoes fft(x, false).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := x[start+k]
+				b := x[start+k+size/2] * wk
+				x[start+k] = a + b
+				x[start+k+size/2] = a - b
+				wk *= w
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// Smallest power of two no less than 'n'.
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
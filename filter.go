@@ -0,0 +1,112 @@
+// Filter design.
+//
+// Several stages want the same few filters: the prefilter a bandpass,
+// the smoothers a low-pass, a notch to take out a carrier.  Rather than
+// each working out its own coefficients, they get them here.
+//
+// There are two kinds.  Windowed-sinc FIR kernels have exactly linear
+// phase, so element edges keep their shape, but need hundreds of taps
+// for a narrow band and are best applied by FFT (see overlapAdd).
+// Biquads are second-order IIR sections from the RBJ audio EQ cookbook:
+// five multiplies a sample, at the price of some phase distortion near
+// the band edges.
+
+package main
+
+import "math"
+
+// Return an 'm'-tap windowed-sinc low-pass kernel cutting off at
+// 'cutoff', as a fraction of the sample rate (so below 0.5).  'm'
+// should be odd, for a kernel symmetric about its middle tap.
+func lowpassKernel(m int, cutoff float64) []float64 {
+	h := make([]float64, m)
+	c := float64(m-1) / 2
+	for i := range h {
+		t := float64(i) - c
+		v := 2 * cutoff
+		if t != 0 {
+			v = math.Sin(2*math.Pi*cutoff*t) / (math.Pi * t)
+		}
+		h[i] = v * hamming(i, m)
+	}
+	return h
+}
+
+// Return an 'm'-tap windowed-sinc bandpass kernel passing 'width' Hz
+// centred on 'freq' Hz, for audio sampled at 'rate'.
+func bandpassKernel(m int, freq, width, rate float64) []float64 {
+	h := lowpassKernel(m, width/2/rate)
+	c := float64(m-1) / 2
+	for i := range h {
+		h[i] *= 2 * math.Cos(2*math.Pi*freq*(float64(i)-c)/rate) // shift up to 'freq'
+	}
+	return h
+}
+
+// The 'i'th of 'm' Hamming window weights.
+func hamming(i, m int) float64 {
+	if m < 2 {
+		return 1
+	}
+	return 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(m-1))
+}
+
+// A second-order IIR section, in transposed direct form II.
+type biquad struct {
+	b0, b1, b2 float64 // numerator, normalized by a0
+	a1, a2     float64 // denominator, normalized by a0
+	z1, z2     float64 // state
+}
+
+// Make a biquad from the cookbook's coefficients.
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *biquad {
+	return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+// The angular frequency and bandwidth term the cookbook designs from.
+func biquadTerms(freq, q, rate float64) (cos, alpha float64) {
+	w := 2 * math.Pi * math.Min(freq, 0.49*rate) / rate
+	return math.Cos(w), math.Sin(w) / (2 * q)
+}
+
+// Low-pass cutting off at 'freq' Hz, for samples 'rate' a second.  A
+// 'q' of 1/√2 makes it a two-pole Butterworth filter.
+func newLowpassBiquad(freq, q, rate float64) *biquad {
+	cos, alpha := biquadTerms(freq, q, rate)
+	return newBiquad((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// High-pass cutting off at 'freq' Hz.
+func newHighpassBiquad(freq, q, rate float64) *biquad {
+	cos, alpha := biquadTerms(freq, q, rate)
+	return newBiquad((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// Bandpass centred on 'freq' Hz, with unity gain there; its bandwidth
+// is 'freq'/'q'.
+func newBandpassBiquad(freq, q, rate float64) *biquad {
+	cos, alpha := biquadTerms(freq, q, rate)
+	return newBiquad(alpha, 0, -alpha, 1+alpha, -2*cos, 1-alpha)
+}
+
+// Notch at 'freq' Hz, 'freq'/'q' wide.
+func newNotchBiquad(freq, q, rate float64) *biquad {
+	cos, alpha := biquadTerms(freq, q, rate)
+	return newBiquad(1, -2*cos, 1, 1+alpha, -2*cos, 1-alpha)
+}
+
+// Filter one sample.
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// Set the state as if the input had been 'x' forever, so that a
+// filter started on a steady level doesn't ring.
+func (f *biquad) settle(x float64) {
+	y := x * (f.b0 + f.b1 + f.b2) / (1 + f.a1 + f.a2)
+	f.z2 = f.b2*x - f.a2*y
+	f.z1 = f.b1*x - f.a1*y + f.z2
+}
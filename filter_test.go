@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// The gain of kernel 'h' at 'freq', as a fraction of the sample rate.
+func kernelGain(h []float64, freq float64) float64 {
+	var sum complex128
+	for i, v := range h {
+		sum += complex(v, 0) * cmplx.Exp(complex(0, -2*math.Pi*freq*float64(i)))
+	}
+	return cmplx.Abs(sum)
+}
+
+// The gain of 'f' at 'freq' Hz, for samples 'rate' a second: the peak
+// of its output for a unit sine, once the start has died away.
+func biquadGain(f *biquad, freq, rate float64) float64 {
+	n := int(rate)
+	var peak float64
+	for i := 0; i < n; i++ {
+		y := f.process(math.Sin(2 * math.Pi * freq * float64(i) / rate))
+		if i >= n/2 {
+			peak = math.Max(peak, math.Abs(y))
+		}
+	}
+	return peak
+}
+
+func TestHamming(t *testing.T) {
+	if w := hamming(0, 101); math.Abs(w-0.08) > 1e-9 {
+		t.Errorf("end weight %v, want 0.08", w)
+	}
+	if w := hamming(50, 101); math.Abs(w-1) > 1e-9 {
+		t.Errorf("middle weight %v, want 1", w)
+	}
+	if w := hamming(0, 1); w != 1 {
+		t.Errorf("single tap weight %v, want 1", w)
+	}
+}
+
+func TestLowpassKernel(t *testing.T) {
+	h := lowpassKernel(101, 0.05)
+	tests := []struct {
+		name     string
+		freq     float64
+		min, max float64
+	}{
+		{"DC", 0, 0.99, 1.01},
+		{"passband", 0.02, 0.98, 1.02},
+		{"cutoff", 0.05, 0.45, 0.55},
+		{"stopband", 0.1, 0, 0.01},
+		{"far stopband", 0.4, 0, 0.01},
+	}
+	for _, tt := range tests {
+		if g := kernelGain(h, tt.freq); g < tt.min || g > tt.max {
+			t.Errorf("%s gain %v, want %v to %v", tt.name, g, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBandpassKernel(t *testing.T) {
+	const rate = 8000
+	h := bandpassKernel(201, 700, 200, rate)
+	tests := []struct {
+		name     string
+		freq     float64
+		min, max float64
+	}{
+		{"DC", 0, 0, 0.01},
+		{"centre", 700, 0.98, 1.02},
+		{"passband", 680, 0.98, 1.02},
+		{"stopband below", 300, 0, 0.01},
+		{"stopband above", 1200, 0, 0.01},
+	}
+	for _, tt := range tests {
+		if g := kernelGain(h, tt.freq/rate); g < tt.min || g > tt.max {
+			t.Errorf("%s gain %v, want %v to %v", tt.name, g, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBiquadResponses(t *testing.T) {
+	const rate = 8000
+	butterworth := 1 / math.Sqrt2
+	tests := []struct {
+		name      string
+		f         func() *biquad
+		freq      float64
+		want, tol float64
+	}{
+		{"lowpass passband", func() *biquad { return newLowpassBiquad(1000, butterworth, rate) }, 100, 1, 0.01},
+		{"lowpass corner", func() *biquad { return newLowpassBiquad(1000, butterworth, rate) }, 1000, butterworth, 0.01},
+		{"lowpass stopband", func() *biquad { return newLowpassBiquad(1000, butterworth, rate) }, 3500, 0, 0.05},
+		{"highpass passband", func() *biquad { return newHighpassBiquad(100, butterworth, rate) }, 2000, 1, 0.01},
+		{"highpass corner", func() *biquad { return newHighpassBiquad(100, butterworth, rate) }, 100, butterworth, 0.01},
+		{"highpass stopband", func() *biquad { return newHighpassBiquad(1000, butterworth, rate) }, 50, 0, 0.01},
+		{"bandpass centre", func() *biquad { return newBandpassBiquad(700, 5, rate) }, 700, 1, 0.01},
+		{"bandpass away", func() *biquad { return newBandpassBiquad(700, 5, rate) }, 2500, 0, 0.1},
+		{"notch centre", func() *biquad { return newNotchBiquad(700, 5, rate) }, 700, 0, 0.01},
+		{"notch away", func() *biquad { return newNotchBiquad(700, 5, rate) }, 2500, 1, 0.05},
+	}
+	for _, tt := range tests {
+		if g := biquadGain(tt.f(), tt.freq, rate); math.Abs(g-tt.want) > tt.tol {
+			t.Errorf("%s: gain %v at %v Hz, want %v", tt.name, g, tt.freq, tt.want)
+		}
+	}
+}
+
+func TestNewBiquadNormalizes(t *testing.T) {
+	f := newBiquad(2, 4, 6, 2, 8, 10)
+	if f.b0 != 1 || f.b1 != 2 || f.b2 != 3 || f.a1 != 4 || f.a2 != 5 {
+		t.Errorf("coefficients %+v, want divided by a0", *f)
+	}
+}
+
+func TestBiquadSettle(t *testing.T) {
+	const rate, level = 8000, 0.7
+	tests := []struct {
+		name string
+		f    *biquad
+		dc   float64 // gain at DC
+	}{
+		{"lowpass", newLowpassBiquad(1000, 1/math.Sqrt2, rate), 1},
+		{"highpass", newHighpassBiquad(100, 1/math.Sqrt2, rate), 0},
+		{"bandpass", newBandpassBiquad(700, 5, rate), 0},
+		{"notch", newNotchBiquad(700, 5, rate), 1},
+	}
+	for _, tt := range tests {
+		tt.f.settle(level)
+		for i := 0; i < 100; i++ {
+			if y := tt.f.process(level); math.Abs(y-tt.dc*level) > 1e-9 {
+				t.Errorf("%s: sample %d after settling is %v, want %v", tt.name, i, y, tt.dc*level)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Locale-aware formatting of numbers, frequencies and timestamps in
+// human-readable output.
+//
+// Logs from this program get passed around between operators in many
+// countries, so digit grouping, the decimal mark and the date layout
+// follow the configured locale (by default the one in the environment),
+// and timestamps can be pinned to UTC.
+
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type locale struct {
+	decimal string // decimal mark
+	group   string // thousands separator
+	date    string // time.Format layout for timestamps
+}
+
+var locales = map[string]locale{
+	"":      {".", "", "2006-01-02 15:04:05"}, // C / POSIX
+	"en":    {".", ",", "2006-01-02 15:04:05"},
+	"en_US": {".", ",", "01/02/2006 15:04:05"},
+	"en_GB": {".", ",", "02/01/2006 15:04:05"},
+	"de":    {",", ".", "02.01.2006 15:04:05"},
+	"fr":    {",", " ", "02/01/2006 15:04:05"},
+	"es":    {",", ".", "02/01/2006 15:04:05"},
+	"it":    {",", ".", "02/01/2006 15:04:05"},
+	"nl":    {",", ".", "02-01-2006 15:04:05"},
+	"pt":    {",", ".", "02/01/2006 15:04:05"},
+	"ru":    {",", " ", "02.01.2006 15:04:05"},
+	"pl":    {",", " ", "02.01.2006 15:04:05"},
+	"sv":    {",", " ", "2006-01-02 15:04:05"},
+	"ja":    {".", ",", "2006/01/02 15:04:05"},
+	"zh":    {".", ",", "2006/01/02 15:04:05"},
+}
+
+type humanFormat struct {
+	locale
+	utc bool
+}
+
+// Formatting used by all human-readable output; replaced by main once
+// the configuration is known.
+var human = newHumanFormat("", false)
+
+// Build a formatter for the named locale, e.g. "de" or "en_US.UTF-8".
+// An empty name means the locale from the environment.  Unknown
+// locales fall back to their language, then to plain C formatting.
+func newHumanFormat(name string, utc bool) *humanFormat {
+	if name == "" {
+		for _, v := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+			if name = os.Getenv(v); name != "" {
+				break
+			}
+		}
+	}
+	if i := strings.IndexAny(name, ".@"); i >= 0 {
+		name = name[:i]
+	}
+	l, ok := locales[name]
+	if !ok {
+		lang := name
+		if i := strings.Index(lang, "_"); i >= 0 {
+			lang = lang[:i]
+		}
+		if l, ok = locales[lang]; !ok {
+			l = locales[""]
+		}
+	}
+	return &humanFormat{l, utc}
+}
+
+func knownLocale(name string) bool {
+	if i := strings.IndexAny(name, ".@"); i >= 0 {
+		name = name[:i]
+	}
+	if _, ok := locales[name]; ok {
+		return true
+	}
+	if i := strings.Index(name, "_"); i >= 0 {
+		_, ok := locales[name[:i]]
+		return ok
+	}
+	return name == "C" || name == "POSIX"
+}
+
+// Insert the group separator every three digits of 'digits'.
+func (h *humanFormat) groupDigits(digits string) string {
+	if h.group == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(h.group)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+func (h *humanFormat) int(n int64) string {
+	if n < 0 {
+		return "-" + h.groupDigits(strconv.FormatInt(-n, 10))
+	}
+	return h.groupDigits(strconv.FormatInt(n, 10))
+}
+
+// Format 'x' with 'prec' digits after the decimal mark.
+func (h *humanFormat) float(x float64, prec int) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return strconv.FormatFloat(x, 'f', prec, 64)
+	}
+	s := strconv.FormatFloat(math.Abs(x), 'f', prec, 64)
+	whole, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, frac = s[:i], h.decimal+s[i+1:]
+	}
+	sign := ""
+	if x < 0 {
+		sign = "-"
+	}
+	return sign + h.groupDigits(whole) + frac
+}
+
+// Format an audio or RF frequency in the most readable unit.
+func (h *humanFormat) freq(hz float64) string {
+	switch {
+	case math.Abs(hz) >= 1e6:
+		return h.float(hz/1e6, 3) + " MHz"
+	case math.Abs(hz) >= 1e4:
+		return h.float(hz/1e3, 2) + " kHz"
+	}
+	return h.float(hz, 0) + " Hz"
+}
+
+func (h *humanFormat) time(t time.Time) string {
+	if h.utc {
+		return t.UTC().Format(h.date) + "Z"
+	}
+	return t.Local().Format(h.date)
+}
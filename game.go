@@ -0,0 +1,374 @@
+// Practice game server.
+//
+// For club nights: the server keys a challenge, a word or a callsign,
+// and every player listening in a browser races to type what they
+// copied.  The first correct answer in a round scores three points,
+// any later correct answer one.  A round lasts gameRoundTime, and the
+// next follows shortly after.
+//
+// Each page plays over a WebSocket: the game's events go out to it as
+// they happen, and the player's answers come back the same way.  The
+// challenge's audio is fetched as a WAV file.  The leaderboard is kept
+// in a JSON file, so that it survives restarts.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gameRoundTime = 30 * time.Second
+	gameBreak     = 5 * time.Second
+	gameRate      = 8000 // sample rate of challenge audio
+	gameMaxName   = 20
+	gameMaxAnswer = 1024 // bytes in an answer message
+)
+
+// Challenges used when no word list is given.
+var gameWords = []string{
+	"CQ", "DE", "TEST", "RST", "599", "QTH", "NAME", "73", "TNX", "FB",
+	"W1AW", "K1ABC", "G4XYZ", "JA1ZZ", "VK2DX", "DL5ABC", "OK", "RIG",
+	"ANT", "WX", "HR", "UR", "ES", "PSE", "AGN", "QRZ", "QSL", "QRM",
+}
+
+type gameScore struct {
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+	Wins   int    `json:"wins"` // rounds answered first
+}
+
+type gameRound struct {
+	ID       int
+	text     string
+	audio    []byte
+	answered map[string]bool // players who have got it right
+	ends     time.Time
+}
+
+type gameServer struct {
+	mu      sync.Mutex
+	ss      synthSettings // everything but the text
+	words   []string
+	file    string // leaderboard file, if any
+	scores  map[string]*gameScore
+	round   *gameRound
+	nextID  int
+	clients map[chan string]bool
+}
+
+func newGameServer(ss synthSettings, words []string, file string) (*gameServer, error) {
+	g := &gameServer{
+		ss:      ss,
+		words:   words,
+		file:    file,
+		scores:  make(map[string]*gameScore),
+		clients: make(map[chan string]bool),
+	}
+	if len(g.words) == 0 {
+		g.words = gameWords
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &g.scores)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Run rounds forever.
+func (g *gameServer) run() {
+	for {
+		g.start()
+		time.Sleep(gameRoundTime)
+		g.finish()
+		time.Sleep(gameBreak)
+	}
+}
+
+func (g *gameServer) start() {
+	ss := g.ss
+	ss.text = strings.ToUpper(g.words[rand.Intn(len(g.words))])
+	audio := wavBytes(synthesize(ss, gameRate), gameRate)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextID++
+	g.round = &gameRound{
+		ID:       g.nextID,
+		text:     ss.text,
+		audio:    audio,
+		answered: make(map[string]bool),
+		ends:     time.Now().Add(gameRoundTime),
+	}
+	g.publish(map[string]interface{}{"type": "round", "round": g.round.ID, "wpm": ss.wpm})
+}
+
+func (g *gameServer) finish() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.publish(map[string]interface{}{"type": "end", "round": g.round.ID, "text": g.round.text})
+	g.round = nil
+}
+
+// Judge the answer 'text' from player 'name' to round 'id'; return the
+// points it scores.
+func (g *gameServer) answer(name string, id int, text string) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r := g.round
+	if r == nil || r.ID != id {
+		return 0, fmt.Errorf("round %d is over", id)
+	}
+	if r.answered[name] {
+		return 0, fmt.Errorf("already answered")
+	}
+	if strings.Join(strings.Fields(strings.ToUpper(text)), " ") != r.text {
+		return 0, nil
+	}
+	points := 1
+	s := g.scores[name]
+	if s == nil {
+		s = &gameScore{Name: name}
+		g.scores[name] = s
+	}
+	if len(r.answered) == 0 {
+		points = 3
+		s.Wins++
+	}
+	r.answered[name] = true
+	s.Points += points
+	g.save()
+	g.publish(map[string]interface{}{"type": "correct", "round": id, "name": name, "points": points})
+	return points, nil
+}
+
+// The leaderboard, best first.  Must be called with g.mu held.
+func (g *gameServer) leaderboard() []gameScore {
+	board := make([]gameScore, 0, len(g.scores))
+	for _, s := range g.scores {
+		board = append(board, *s)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Points != board[j].Points {
+			return board[i].Points > board[j].Points
+		}
+		return board[i].Name < board[j].Name
+	})
+	return board
+}
+
+// Write out the leaderboard.  Must be called with g.mu held.
+func (g *gameServer) save() {
+	if g.file == "" {
+		return
+	}
+	data, err := json.Marshal(g.scores)
+	if err == nil {
+		err = os.WriteFile(g.file+".tmp", data, 0666)
+	}
+	if err == nil {
+		err = os.Rename(g.file+".tmp", g.file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "game: %v\n", err)
+	}
+}
+
+// Push an event to all open pages.  Must be called with g.mu held.
+func (g *gameServer) publish(ev map[string]interface{}) {
+	data, _ := json.Marshal(ev)
+	for ch := range g.clients {
+		select {
+		case ch <- string(data):
+		default:
+		}
+	}
+}
+
+func (g *gameServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, gamePage)
+	})
+	mux.HandleFunc("/socket", g.serveSocket)
+	mux.HandleFunc("/audio", func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		round := g.round
+		g.mu.Unlock()
+		if round == nil || fmt.Sprint(round.ID) != r.FormValue("round") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(round.audio)
+	})
+	mux.HandleFunc("/scores", func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		board := g.leaderboard()
+		g.mu.Unlock()
+		writeJSON(w, http.StatusOK, board)
+	})
+	return mux
+}
+
+// A player's WebSocket: the current round, if any, then each event as
+// it happens go out, while answers, {"name", "round", "text"}, come in
+// and each gets a "result" or an "error" back.
+func (g *gameServer) serveSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := acceptWebSocket(w, r, gameMaxAnswer)
+	if err != nil {
+		return
+	}
+	defer ws.close()
+
+	ch := make(chan string, 16)
+	g.mu.Lock()
+	if g.round != nil {
+		data, _ := json.Marshal(map[string]interface{}{"type": "round", "round": g.round.ID, "wpm": g.ss.wpm})
+		ch <- string(data)
+	}
+	g.clients[ch] = true
+	g.mu.Unlock()
+	done := make(chan bool)
+	defer func() {
+		close(done)
+		g.mu.Lock()
+		delete(g.clients, ch)
+		g.mu.Unlock()
+	}()
+
+	go func() {
+		for {
+			select {
+			case s := <-ch:
+				if ws.write([]byte(s)) != nil {
+					ws.close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		data, err := ws.read()
+		if err != nil {
+			return
+		}
+		reply, _ := json.Marshal(g.judge(data))
+		if ws.write(reply) != nil {
+			return
+		}
+	}
+}
+
+// Judge the answer message 'data', and return the reply.
+func (g *gameServer) judge(data []byte) map[string]interface{} {
+	var req struct {
+		Name  string `json:"name"`
+		Round int    `json:"round"`
+		Text  string `json:"text"`
+	}
+	if json.Unmarshal(data, &req) != nil {
+		return map[string]interface{}{"type": "error", "error": "send {name, round, text}"}
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > gameMaxName {
+		return map[string]interface{}{"type": "error", "error": "bad name"}
+	}
+	points, err := g.answer(name, req.Round, req.Text)
+	if err != nil {
+		return map[string]interface{}{"type": "error", "error": err.Error()}
+	}
+	return map[string]interface{}{"type": "result", "round": req.Round, "correct": points > 0, "points": points}
+}
+
+const gamePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Morse copy game</title>
+<style>
+body { font: 16px sans-serif; margin: 2em; }
+#answer { font: 2em monospace; width: 12em; }
+#log { white-space: pre-wrap; }
+td { padding: 0 1em; }
+</style>
+</head>
+<body>
+<p>Name: <input id="name" size="12"></p>
+<p><audio id="audio" controls></audio></p>
+<p><input id="answer" disabled autocomplete="off"></p>
+<p id="status">Waiting for the next round...</p>
+<table id="scores"></table>
+<script>
+var round = 0;
+var who = document.getElementById("name");
+var guess = document.getElementById("answer");
+var note = document.getElementById("status");
+who.value = localStorage.getItem("name") || "";
+who.onchange = function() { localStorage.setItem("name", who.value); };
+
+function scores() {
+  fetch("/scores").then(function(r) { return r.json(); }).then(function(board) {
+    var t = document.getElementById("scores");
+    t.innerHTML = "";
+    board.slice(0, 10).forEach(function(s) {
+      var row = t.insertRow();
+      row.insertCell().textContent = s.name;
+      row.insertCell().textContent = s.points;
+    });
+  });
+}
+
+var sock = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/socket");
+sock.onclose = function() { note.textContent = "Lost the server; reload to play again."; };
+
+guess.onkeydown = function(e) {
+  if (e.key != "Enter") return;
+  sock.send(JSON.stringify({name: who.value, round: round, text: guess.value}));
+};
+
+sock.onmessage = function(e) {
+  var ev = JSON.parse(e.data);
+  if (ev.type == "error") {
+    note.textContent = ev.error;
+  } else if (ev.type == "result") {
+    if (ev.correct) { note.textContent = "Correct! +" + ev.points; guess.disabled = true; }
+    else note.textContent = "Not quite; try again.";
+  } else if (ev.type == "round") {
+    round = ev.round;
+    var audio = document.getElementById("audio");
+    audio.src = "/audio?round=" + round;
+    audio.play();
+    guess.value = "";
+    guess.disabled = false;
+    guess.focus();
+    note.textContent = "Round " + round + " at " + ev.wpm + " WPM: what did you copy?";
+  } else if (ev.type == "end") {
+    guess.disabled = true;
+    note.textContent = "It was " + ev.text + ".";
+    scores();
+  } else if (ev.type == "correct") {
+    scores();
+  }
+};
+scores();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A bare WebSocket client, for talking to the game.
+type testSocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTestSocket(t *testing.T, url string) *testSocket {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /socket HTTP/1.1\r\nHost: game\r\n"+
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The example key and answer from RFC 6455.
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s, accept %q", resp.Status, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &testSocket{conn, r}
+}
+
+// Send one masked frame.
+func (s *testSocket) send(fin bool, op byte, payload string) {
+	head := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		head[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	data := []byte(payload)
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	s.conn.Write(append(append(head, mask...), data...))
+}
+
+// Read one unmasked frame.
+func (s *testSocket) recv(t *testing.T) (byte, string) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(s.r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(s.r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, string(data)
+}
+
+func (s *testSocket) event(t *testing.T) map[string]interface{} {
+	t.Helper()
+	op, data := s.recv(t)
+	if op != wsText {
+		t.Fatalf("got opcode %d, want text", op)
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestGameSocket(t *testing.T) {
+	g, err := newGameServer(synthSettings{"", 20, 700, 0}, []string{"k1abc"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.start()
+	srv := httptest.NewServer(g.handler())
+	defer srv.Close()
+	ws := dialTestSocket(t, srv.URL)
+	defer ws.conn.Close()
+
+	if ev := ws.event(t); ev["type"] != "round" || ev["round"] != 1.0 {
+		t.Fatalf("first event %v, want round 1", ev)
+	}
+
+	ws.send(true, wsPing, "hi")
+	if op, data := ws.recv(t); op != wsPong || data != "hi" {
+		t.Errorf("ping answered with opcode %d %q", op, data)
+	}
+
+	ws.send(true, wsText, `{"name": "ann", "round": 1, "text": "k1abd"}`)
+	if ev := ws.event(t); ev["type"] != "result" || ev["correct"] != false {
+		t.Errorf("wrong answer got %v", ev)
+	}
+
+	// A fragmented answer.
+	ws.send(false, wsText, `{"name": "ann", "round": 1, `)
+	ws.send(true, wsContinuation, `"text": "K1ABC"}`)
+	// The reply and the broadcast to every player may come in
+	// either order.
+	got := make(map[interface{}]map[string]interface{})
+	for i := 0; i < 2; i++ {
+		ev := ws.event(t)
+		got[ev["type"]] = ev
+	}
+	if ev := got["result"]; ev["correct"] != true || ev["points"] != 3.0 {
+		t.Errorf("right answer got %v, want 3 points", ev)
+	}
+	if ev := got["correct"]; ev["name"] != "ann" {
+		t.Errorf("broadcast %v, want ann correct", ev)
+	}
+
+	ws.send(true, wsText, `{"name": "ann", "round": 1, "text": "K1ABC"}`)
+	if ev := ws.event(t); ev["type"] != "error" {
+		t.Errorf("second answer got %v, want an error", ev)
+	}
+
+	ws.send(true, wsClose, "")
+	if op, _ := ws.recv(t); op != wsClose {
+		t.Errorf("close answered with opcode %d", op)
+	}
+}
+
+func TestGameSocketRefusesPlainRequests(t *testing.T) {
+	g, err := newGameServer(synthSettings{"", 20, 700, 0}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(g.handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET got %s", resp.Status)
+	}
+}
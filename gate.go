@@ -0,0 +1,88 @@
+// Noise gate.
+//
+// Between transmissions the band is rarely silent: there's hiss, hum
+// and distant chatter, and every detector turns some of it into
+// amplitudes for the quantizer to key on.  A noise gate mutes the audio
+// whenever its level is under a threshold, so that what reaches the
+// detector between overs is nothing at all.
+//
+// The gate opens over the attack time once the level rises above the
+// threshold, stays open for the hold time after it falls back, so that
+// the spaces inside a character or word don't shut it, and then closes
+// over the release time.  The ramps keep it from clicking, which would
+// otherwise key the detector itself.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Time constant of the level the gate compares with its threshold:
+// long enough to ride over the troughs of a tone, short enough to
+// follow the keying.
+const gateLevelDecay = 5 * time.Millisecond
+
+type noiseGate struct {
+	threshold float64 // level, in sample units, above which it opens
+	decay     float64 // per sample, of the level
+	attack    float64 // gain gained per sample while opening
+	release   float64 // gain lost per sample while closing
+	hold      int     // samples to stay open once the level has fallen
+
+	level float64 // peak level, decaying
+	gain  float64 // 0 closed, 1 open
+	quiet int     // samples since the level was last above threshold
+}
+
+// Make a gate for audio sampled at 'rate' which opens above 'threshold'
+// dBFS.  A zero 'attack' or 'release' opens or closes it at once.
+func newNoiseGate(threshold float64, attack, hold, release time.Duration, rate int) *noiseGate {
+	r := float64(rate)
+	ramp := func(d time.Duration) float64 {
+		if n := d.Seconds() * r; n > 1 {
+			return 1 / n
+		}
+		return 1
+	}
+	g := &noiseGate{
+		threshold: math.Pow(10, threshold/20) * math.MaxInt32,
+		decay:     math.Exp(-1 / (gateLevelDecay.Seconds() * r)),
+		attack:    ramp(attack),
+		release:   ramp(release),
+		hold:      int(hold.Seconds() * r),
+	}
+	g.quiet = g.hold
+	return g
+}
+
+// Return 'chunk', gated.
+func (g *noiseGate) process(chunk []int32) []int32 {
+	out := make([]int32, len(chunk))
+	for i, v := range chunk {
+		g.level = math.Max(math.Abs(float64(v)), g.level*g.decay)
+		if g.level >= g.threshold {
+			g.quiet = 0
+			g.gain = math.Min(g.gain+g.attack, 1)
+		} else if g.quiet < g.hold {
+			g.quiet++
+		} else {
+			g.gain = math.Max(g.gain-g.release, 0)
+		}
+		out[i] = int32(float64(v) * g.gain)
+	}
+	return out
+}
+
+// Read audio chunks from 'chunks' and pass them on through 'g'.
+func getNoiseGatePipe(chunks chan []int32, g *noiseGate) chan []int32 {
+	out := make(chan []int32)
+	go func() {
+		for chunk := range chunks {
+			out <- g.process(chunk)
+		}
+		close(out)
+	}()
+	return out
+}
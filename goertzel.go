@@ -0,0 +1,120 @@
+// Goertzel tone detector.
+//
+// RMS keys on any loud sound in the passband: on a crowded HF band
+// that means every other signal, and the static crashes too.  The
+// Goertzel algorithm measures the energy in a single frequency bin,
+// like one output of a DFT, so the decoder hears only the tone it is
+// tuned to.
+//
+// The bin width is roughly the sample rate divided by the number of
+// samples analyzed, which for any useful selectivity is far more than
+// one chunk.  So the detector keeps a sliding window of the most
+// recent samples and analyzes the whole window for every chunk.
+
+package main
+
+import "math"
+
+// Tone pitch the detectors listen for unless told otherwise; a common
+// sidetone setting.
+const defaultToneFreq = 700
+
+// Width of the Goertzel detector's bin, in Hz: as narrow as a good CW
+// filter.
+const defaultBandwidth = 100
+
+type goertzel struct {
+	rate   int
+	freq   float64
+	coeff  float64
+	window []float64 // ring of the most recent samples
+	taper  []float64 // weights for 'window', oldest first; nil for none
+	pos    int       // oldest sample in 'window'
+	skip   int       // chunks until the next analysis
+	last   int32     // amplitude found by the last analysis
+}
+
+// Make a detector for 'freq' Hz in audio sampled at 'rate', with a bin
+// 'bandwidth' Hz wide.
+func newGoertzel(freq, bandwidth float64, rate int) *goertzel {
+	n := int(float64(rate) / bandwidth)
+	if n < 1 {
+		n = 1
+	}
+	g := &goertzel{
+		rate:   rate,
+		window: make([]float64, n),
+	}
+	g.tune(freq)
+	return g
+}
+
+// Move the detector to 'freq' Hz.
+func (g *goertzel) tune(freq float64) {
+	g.freq = freq
+	g.coeff = goertzelCoeff(freq, g.rate)
+}
+
+// The Goertzel coefficient for 'freq' Hz at 'rate' samples a second.
+func goertzelCoeff(freq float64, rate int) float64 {
+	return 2 * math.Cos(2*math.Pi*freq/float64(rate))
+}
+
+// Taper the window with a Hann window.  That trades a main lobe twice
+// as wide for sidelobes far lower, so that a strong signal several
+// bins away doesn't leak into this one.
+func (g *goertzel) hann() {
+	n := len(g.window)
+	g.taper = make([]float64, n)
+	for i := range g.taper {
+		g.taper[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+	}
+}
+
+// Add 'chunk' to the window, and return the amplitude of the tone
+// across the window, in the same units as the samples.
+//
+// Under CPU pressure the window is only analyzed every second or
+// fourth chunk, and the last result repeated in between.
+func (g *goertzel) amplitude(chunk []int32) int32 {
+	for _, v := range chunk {
+		g.window[g.pos] = float64(v)
+		g.pos = (g.pos + 1) % len(g.window)
+	}
+	if g.skip > 0 {
+		g.skip--
+		return g.last
+	}
+	switch lvl := load.level(); {
+	case lvl >= 3:
+		g.skip = 3
+	case lvl >= 2:
+		g.skip = 1
+	}
+	g.last = int32(g.magnitude(g.coeff))
+	return g.last
+}
+
+// Return the amplitude across the window of the tone whose Goertzel
+// coefficient is 'coeff'.
+func (g *goertzel) magnitude(coeff float64) float64 {
+	var s1, s2 float64
+	gain := float64(len(g.window))
+	if g.taper != nil {
+		gain = 0
+		for _, w := range g.taper {
+			gain += w
+		}
+	}
+	for i := range g.window {
+		x := g.window[(g.pos+i)%len(g.window)]
+		if g.taper != nil {
+			x *= g.taper[i]
+		}
+		s := x + coeff*s1 - s2
+		s2 = s1
+		s1 = s
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * math.Sqrt(math.Max(power, 0)) / gain
+}
@@ -0,0 +1,57 @@
+// Analytic-signal (Hilbert) envelope detector.
+//
+// Rectifying a tone and smoothing it, as the envelope follower does,
+// leaves ripple at twice the tone frequency unless the smoothing is
+// slow, and slow smoothing blurs the element edges.  The analytic
+// signal avoids the trade: pair each sample with its Hilbert
+// transform, the same tone shifted by 90 degrees, and the magnitude of
+// the pair is the tone's envelope, with no ripple to smooth away.
+//
+// The transform is a windowed FIR filter, with the direct path delayed
+// to match.  Its low-frequency limit is roughly the sample rate over
+// its length, so it is made long enough to reach well under any
+// sidetone pitch.
+
+package main
+
+import "math"
+
+// Lowest frequency the transformer should handle well, in Hz.
+const hilbertMinFreq = 100
+
+type hilbertDetector struct {
+	taps []float64 // odd taps only; the even ones are zero
+	hist []float64 // ring of the most recent samples
+	pos  int       // next slot in 'hist'
+}
+
+func newHilbertDetector(rate int) *hilbertDetector {
+	n := rate/hilbertMinFreq | 1 // filter length, odd
+	c := n / 2
+	h := &hilbertDetector{hist: make([]float64, n)}
+	for k := 1; k <= c; k += 2 {
+		w := 0.54 + 0.46*math.Cos(math.Pi*float64(k)/float64(c)) // Hamming
+		h.taps = append(h.taps, 2/(math.Pi*float64(k))*w)
+	}
+	return h
+}
+
+func (h *hilbertDetector) amplitude(chunk []int32) int32 {
+	n := len(h.hist)
+	c := n / 2
+	var sum float64
+	for _, v := range chunk {
+		h.hist[h.pos] = float64(v)
+		h.pos = (h.pos + 1) % n
+		// The newest sample is at pos-1, the centre one c older.
+		mid := h.pos - 1 - c + n
+		re := h.hist[mid%n]
+		var im float64
+		for i, t := range h.taps {
+			k := 2*i + 1
+			im += t * (h.hist[(mid-k)%n] - h.hist[(mid+k)%n])
+		}
+		sum += math.Sqrt(re*re + im*im)
+	}
+	return int32(math.Min(sum/float64(len(chunk)), math.MaxInt32))
+}
@@ -0,0 +1,124 @@
+// Status LEDs on GPIO pins, so that headless field units (typically a
+// Raspberry Pi) show what the decoder is up to at a glance.
+//
+// Pins are driven through the Linux sysfs GPIO interface, which needs
+// no extra libraries.  Three LEDs are supported, each optional:
+//
+//   signal    lit while marks (dits and dahs) are being heard
+//   decoding  lit while letters and words are being assembled
+//   error     flashes whenever the decoder can't make sense of a mark
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const gpioRoot = "/sys/class/gpio"
+
+type gpioPin struct {
+	value *os.File
+}
+
+// Export GPIO pin 'n' and set it up as an output.
+func openGPIO(n int) (*gpioPin, error) {
+	dir := fmt.Sprintf("%s/gpio%d", gpioRoot, n)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err := os.WriteFile(gpioRoot+"/export", []byte(strconv.Itoa(n)), 0)
+		if err != nil {
+			return nil, err
+		}
+		// udev may take a moment to make the new files writable.
+		for i := 0; i < 20; i++ {
+			if f, err := os.OpenFile(dir+"/direction", os.O_WRONLY, 0); err == nil {
+				f.Close()
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if err := os.WriteFile(dir+"/direction", []byte("out"), 0); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(dir+"/value", os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &gpioPin{f}, nil
+}
+
+func (p *gpioPin) set(on bool) {
+	v := "0"
+	if on {
+		v = "1"
+	}
+	p.value.WriteAt([]byte(v), 0)
+}
+
+// An LED which stays lit for a while after each time it is poked.
+type statusLED struct {
+	pin   *gpioPin
+	hold  time.Duration
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func (l *statusLED) poke() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.timer == nil {
+		l.timer = time.AfterFunc(l.hold, func() { l.pin.set(false) })
+	} else {
+		l.timer.Reset(l.hold)
+	}
+	l.pin.set(true)
+}
+
+type statusLEDs struct {
+	signal, decoding, errors *statusLED
+}
+
+// Set up whichever of the three LEDs have a pin assigned (negative
+// pin numbers mean no LED).
+func newStatusLEDs(signalPin, decodingPin, errorPin int) (*statusLEDs, error) {
+	var leds statusLEDs
+	for _, l := range []struct {
+		led  **statusLED
+		pin  int
+		hold time.Duration
+	}{
+		{&leds.signal, signalPin, 300 * time.Millisecond},
+		{&leds.decoding, decodingPin, 3 * time.Second},
+		{&leds.errors, errorPin, time.Second},
+	} {
+		if l.pin < 0 {
+			continue
+		}
+		pin, err := openGPIO(l.pin)
+		if err != nil {
+			return nil, err
+		}
+		pin.set(false)
+		*l.led = &statusLED{pin: pin, hold: l.hold}
+	}
+	return &leds, nil
+}
+
+// Update the LEDs for a token coming out of the pipeline.
+func (s *statusLEDs) token(t token) {
+	switch t {
+	case dit, dah:
+		s.signal.poke()
+	case endLetter, endWord:
+		s.decoding.poke()
+	case cwError:
+		s.errors.poke()
+	}
+}
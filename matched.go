@@ -0,0 +1,74 @@
+// Matched filter on the amplitude stream.
+//
+// Near the noise floor a single amplitude says little about whether
+// the key is down; the best linear detector for a pulse of known shape
+// is a filter matched to that shape.  For keyed CW the pulse is a
+// rectangle one dit long, so its matched filter is simply a moving
+// average over one dit.  The dit length isn't known up front, so the
+// filter follows the unit duration the tokenizer estimates, and passes
+// amplitudes through untouched until there is one.
+
+package main
+
+import "sync/atomic"
+
+// Longest integration time, in amplitudes, which is about a second: a
+// unit estimate longer than that is a mistake.
+const matchedMaxUnit = 700
+
+type matchedFilter struct {
+	unit int32 // in amplitudes; accessed atomically
+}
+
+// Follow a new unit estimate, as carried by symbols from stage 3.
+func (m *matchedFilter) setUnit(unit int32) {
+	if unit > matchedMaxUnit {
+		unit = matchedMaxUnit
+	}
+	atomic.StoreInt32(&m.unit, unit)
+}
+
+// The filter stage itself.
+func (m *matchedFilter) stage(amplitudes chan int32) chan int32 {
+	out := make(chan int32)
+	go func() {
+		var hist [matchedMaxUnit]int32
+		pos, seen := 0, 0
+		for amp := range amplitudes {
+			hist[pos] = amp
+			pos = (pos + 1) % matchedMaxUnit
+			seen++
+			n := int(atomic.LoadInt32(&m.unit))
+			if n > seen {
+				n = seen
+			}
+			if n <= 1 {
+				out <- amp
+				continue
+			}
+			var sum int64
+			for i := 1; i <= n; i++ {
+				sum += int64(hist[(pos-i+matchedMaxUnit)%matchedMaxUnit])
+			}
+			out <- int32(sum / int64(n))
+		}
+		close(out)
+	}()
+	return out
+}
+
+// Pass symbols from 'symbols' through, feeding their unit estimates
+// back to 'm'.
+func getUnitFeedbackPipe(symbols chan symbol, m *matchedFilter) chan symbol {
+	out := make(chan symbol)
+	go func() {
+		for s := range symbols {
+			if s.unit > 0 {
+				m.setUnit(s.unit)
+			}
+			out <- s
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,126 @@
+// Message segmentation.
+//
+// Most consumers don't want a stream of characters, they want whole
+// transmissions: everything one station sent between two long
+// silences, or up to its SK prosign.  The message assembler groups
+// tokens into such messages and measures how long each lasted and how
+// fast it was sent.
+
+package main
+
+import (
+	"strings"
+)
+
+type message struct {
+	Text    string  `json:"text"`
+	Seconds float64 `json:"seconds"`
+	WPM     float64 `json:"wpm"`
+	SNR     float64 `json:"snr"`  // average over its marks, in dB
+	Freq    float64 `json:"freq"` // where the detector was tuned as it ended, in Hz; 0 if untuned
+
+	// Each character of the text, with how sure the decoder was of
+	// it.
+	Letters []scoredLetter `json:"letters"`
+}
+
+type scoredLetter struct {
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"`
+}
+
+type messageAssembler struct {
+	chunkSeconds float64    // duration of one chunk
+	tuned        *tunedFreq // where the detector reports its frequency
+
+	text    strings.Builder
+	letters []scoredLetter
+	dec     *textDecoder
+	letter  string // dits and dahs of the letter in progress
+	length  int64  // message duration so far, in chunks
+	gap     int64  // trailing silence not yet counted in 'length'
+	unitSum int64  // sum and count of unit durations, for WPM
+	units   int64
+	snrSum  float64
+}
+
+func newMessageAssembler(chunkSeconds float64, tuned *tunedFreq) *messageAssembler {
+	m := &messageAssembler{chunkSeconds: chunkSeconds, tuned: tuned}
+	m.start()
+	return m
+}
+
+// Start a new message.
+func (m *messageAssembler) start() {
+	*m = messageAssembler{chunkSeconds: m.chunkSeconds, tuned: m.tuned, dec: newTextDecoder(0)}
+	m.dec.scored = func(l decodedLetter) {
+		m.letters = append(m.letters, scoredLetter{l.text, l.confidence})
+	}
+}
+
+// Feed one symbol to the assembler.  Returns the message it completes,
+// if any.
+func (m *messageAssembler) add(s symbol) *message {
+	switch s.tok {
+	case pause:
+		return m.flush()
+	case dit, dah, cwError:
+		m.length += m.gap + int64(s.duration)
+		m.gap = 0
+		m.unitSum += int64(s.unit)
+		m.units++
+		m.snrSum += s.snr
+		if s.tok == dit {
+			m.letter += "."
+		} else if s.tok == dah {
+			m.letter += "-"
+		}
+	default:
+		if m.units == 0 {
+			// Silence before the message starts.
+			return nil
+		}
+		m.gap += int64(s.duration)
+	}
+	m.text.WriteString(m.dec.add(s))
+
+	if s.tok == endLetter || s.tok == endWord {
+		sk := m.letter == prosigns["SK"]
+		m.letter = ""
+		if sk {
+			return m.flush()
+		}
+	}
+	return nil
+}
+
+// Return whatever message is in progress, and start afresh.
+func (m *messageAssembler) flush() *message {
+	if m.units == 0 {
+		return nil
+	}
+	m.text.WriteString(m.dec.flush())
+	msg := &message{
+		Text:    strings.TrimSpace(m.text.String()),
+		Seconds: float64(m.length) * m.chunkSeconds,
+		SNR:     m.snrSum / float64(m.units),
+		Freq:    m.tuned.get(),
+		Letters: m.letters,
+	}
+	if unit := float64(m.unitSum) / float64(m.units) * m.chunkSeconds; unit > 0 {
+		msg.WPM = 1.2 / unit // PARIS timing
+	}
+	m.start()
+	return msg
+}
+
+func (msg *message) emit() {
+	events.emit("message", map[string]interface{}{
+		"text":    msg.Text,
+		"seconds": msg.Seconds,
+		"wpm":     msg.WPM,
+		"snr":     msg.SNR,
+		"freq":    msg.Freq,
+		"letters": msg.Letters,
+	})
+}
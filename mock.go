@@ -0,0 +1,116 @@
+// Mock audio backend: plays a scripted band.
+//
+// Testing anything that cares about signals coming and going (the
+// skimmer, squelch, acquisition) needs a band where stations appear
+// and disappear on cue.  A mock script describes one:
+//
+//   {"seconds": 60, "noise": 0.02, "seed": 1,
+//    "stations": [
+//      {"start": 0,  "stop": 30, "freq": 700, "wpm": 20, "level": 0.3, "text": "CQ DE W1AW"},
+//      {"start": 20, "stop": 60, "freq": 950, "wpm": 28, "level": 0.1, "text": "TEST K1ABC"}
+//    ]}
+//
+// Each station sends its text over and over from 'start' until 'stop'
+// seconds, cut off mid-character if need be, at 'level' relative to
+// full scale.  Adding "fade_period" and "fade_depth" makes it fade by
+// that many dB and back every so many seconds, and "drift" makes its
+// pitch wander off by that many Hz a second.  The noise is seeded,
+// so a script always produces exactly the same audio.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+)
+
+type mockStation struct {
+	Start float64 `json:"start"`
+	Stop  float64 `json:"stop"`
+	Freq  float64 `json:"freq"`
+	WPM   float64 `json:"wpm"`
+	Level float64 `json:"level"`
+	Text  string  `json:"text"`
+
+	// Optional QSB: the level sinks by up to 'FadeDepth' dB and
+	// back every 'FadePeriod' seconds.
+	FadePeriod float64 `json:"fade_period"`
+	FadeDepth  float64 `json:"fade_depth"`
+
+	// Optional drift, in Hz per second.
+	Drift float64 `json:"drift"`
+}
+
+type mockScript struct {
+	Seconds  float64       `json:"seconds"`
+	Noise    float64       `json:"noise"`
+	Seed     int64         `json:"seed"`
+	Stations []mockStation `json:"stations"`
+}
+
+func loadMockScript(filename string) (*mockScript, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &mockScript{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Seconds <= 0 {
+		return nil, errors.New("mock script needs a positive length in seconds")
+	}
+	for i, st := range m.Stations {
+		if st.WPM <= 0 || st.Freq <= 0 || st.Stop <= st.Start || st.Start < 0 {
+			return nil, fmt.Errorf("mock station %d: bad wpm, freq, start or stop", i)
+		}
+		if err := checkSynthText(st.Text); err != nil {
+			return nil, fmt.Errorf("mock station %d: %v", i, err)
+		}
+	}
+	return m, nil
+}
+
+// Render the whole script at 'rate' samples per second.
+func (m *mockScript) render(rate int) []int32 {
+	mix := make([]float64, int(m.Seconds*float64(rate)))
+	for _, st := range m.Stations {
+		env := keyEnvelope(st.Text, st.WPM, rate)
+		if len(env) == 0 {
+			continue
+		}
+		start := int(st.Start * float64(rate))
+		stop := int(math.Min(st.Stop*float64(rate), float64(len(mix))))
+		for t := start; t < stop; t++ {
+			e := env[(t-start)%len(env)]
+			level := st.Level
+			if st.FadePeriod > 0 {
+				phase := 2 * math.Pi * float64(t) / (st.FadePeriod * float64(rate))
+				level *= math.Pow(10, -st.FadeDepth/20*(0.5-0.5*math.Cos(phase)))
+			}
+			secs := float64(t) / float64(rate)
+			mix[t] += level * e * math.Sin(2*math.Pi*(st.Freq+st.Drift*secs/2)*secs)
+		}
+	}
+	noise := rand.New(rand.NewSource(m.Seed))
+	samples := make([]int32, len(mix))
+	for t, v := range mix {
+		v += noise.NormFloat64() * m.Noise
+		v = math.Max(-1, math.Min(1, v))
+		samples[t] = int32(v * math.MaxInt32)
+	}
+	return samples
+}
+
+// Return a capture function which plays the script into the pipeline
+// in real time, as synthCapture does.
+func mockCapture(m *mockScript) func(captureSettings, chan []int32, chan os.Signal, *wavWriter) error {
+	return func(cs captureSettings, chunks chan []int32, stop chan os.Signal, rec *wavWriter) error {
+		defer close(chunks)
+		return play(m.render(cs.rate), cs, chunks, stop, rec)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Load the mock script 'script' through a file, as -mock does.
+func loadTestMock(t *testing.T, script string) *mockScript {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "band.json")
+	if err := os.WriteFile(name, []byte(script), 0666); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadMockScript(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Collect the events emitted while 'f' runs.
+func collectEvents(t *testing.T, f func()) []map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	events = &eventLog{w: &buf}
+	defer func() { events = nil }()
+	f()
+	var evs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var ev map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestSkimmerFollowsMockStation(t *testing.T) {
+	// Long enough for one whole CQ, then gone for longer than skimIdle.
+	m := loadTestMock(t, `{"seconds": 40, "noise": 0.02, "seed": 1, "stations": [
+		{"start": 0.5, "stop": 9, "freq": 700, "wpm": 20, "level": 0.3, "text": "CQ DE W1AW"}]}`)
+	cs := captureSettings{rate: 8000, chunk: chunkSize * 8000 / sampleRate}
+	samples := m.render(cs.rate)
+
+	s := newSkimmer(defaultConfig(), cs)
+	var heard []string
+	s.report = func(freq float64, msg *message) {
+		heard = append(heard, msg.Text)
+	}
+	evs := collectEvents(t, func() {
+		chunks := make(chan []int32)
+		go func() {
+			for i := 0; i+cs.chunk <= len(samples); i += cs.chunk {
+				chunks <- samples[i : i+cs.chunk]
+			}
+			close(chunks)
+		}()
+		s.run(chunks)
+	})
+
+	var starts, stops []float64
+	for _, ev := range evs {
+		switch ev["event"] {
+		case "skim_start":
+			starts = append(starts, ev["freq"].(float64))
+		case "skim_stop":
+			stops = append(stops, ev["freq"].(float64))
+		}
+	}
+	if len(starts) != 1 || math.Abs(starts[0]-700) > s.cfg.Bandwidth/2 {
+		t.Fatalf("channels started at %v Hz, want one near 700", starts)
+	}
+	if len(stops) != 1 || stops[0] != starts[0] {
+		t.Errorf("channels stopped at %v Hz, want the one at %v", stops, starts[0])
+	}
+	if len(s.channels) != 0 {
+		t.Errorf("%d channels still open after the station left", len(s.channels))
+	}
+	if !strings.Contains(strings.Join(heard, " "), "W1AW") {
+		t.Errorf("skimmer heard %q, want W1AW", heard)
+	}
+}
+
+func TestSquelchHoldsMockNoise(t *testing.T) {
+	const rate = 8000
+	noise := loadTestMock(t, `{"seconds": 10, "noise": 0.05, "seed": 2}`).render(rate)
+	band := loadTestMock(t, `{"seconds": 14, "noise": 0.05, "seed": 2, "stations": [
+		{"start": 6, "stop": 14, "freq": 700, "wpm": 20, "level": 0.5, "text": "CQ DE W1AW"}]}`).render(rate)
+
+	// The window quantizer keys on whatever its window holds, noise
+	// included, which leaves the squelch something to hold back.
+	cfg := defaultConfig()
+	cfg.Detector, cfg.Freq, cfg.Quantizer = "goertzel", 700, "window"
+	if text, _ := decodeSamples(cfg, noise, rate); strings.TrimSpace(text) == "" {
+		t.Fatal("nothing decoded from noise without squelch; the test proves nothing")
+	}
+
+	cfg.Squelch = 10
+	var text string
+	evs := collectEvents(t, func() {
+		text, _ = decodeSamples(cfg, noise, rate)
+	})
+	if strings.TrimSpace(text) != "" {
+		t.Errorf("squelched noise decoded as %q", text)
+	}
+	for _, ev := range evs {
+		if ev["event"] == "squelch_open" {
+			t.Errorf("squelch opened on noise: %v", ev)
+		}
+	}
+
+	opened := 0
+	evs = collectEvents(t, func() {
+		text, _ = decodeSamples(cfg, band, rate)
+	})
+	for _, ev := range evs {
+		if ev["event"] == "squelch_open" {
+			opened++
+		}
+	}
+	if opened != 1 || !strings.Contains(text, "W1AW") {
+		t.Errorf("squelch opened %d times on the station, which decoded as %q; want once, with W1AW", opened, text)
+	}
+}
@@ -0,0 +1,298 @@
+// The international Morse code table.
+
+package main
+
+// Dits are '.', dahs are '-'.
+var morseTable = map[rune]string{
+	'A': ".-",
+	'B': "-...",
+	'C': "-.-.",
+	'D': "-..",
+	'E': ".",
+	'F': "..-.",
+	'G': "--.",
+	'H': "....",
+	'I': "..",
+	'J': ".---",
+	'K': "-.-",
+	'L': ".-..",
+	'M': "--",
+	'N': "-.",
+	'O': "---",
+	'P': ".--.",
+	'Q': "--.-",
+	'R': ".-.",
+	'S': "...",
+	'T': "-",
+	'U': "..-",
+	'V': "...-",
+	'W': ".--",
+	'X': "-..-",
+	'Y': "-.--",
+	'Z': "--..",
+	'0': "-----",
+	'1': ".----",
+	'2': "..---",
+	'3': "...--",
+	'4': "....-",
+	'5': ".....",
+	'6': "-....",
+	'7': "--...",
+	'8': "---..",
+	'9': "----.",
+
+	// Punctuation, the whole of ITU-R M.1677's.
+	'.':  ".-.-.-",
+	',':  "--..--",
+	':':  "---...",
+	'?':  "..--..",
+	'\'': ".----.",
+	'-':  "-....-",
+	'/':  "-..-.",
+	'(':  "-.--.",
+	')':  "-.--.-",
+	'"':  ".-..-.",
+	'=':  "-...-",
+	'+':  ".-.-.",
+	'@':  ".--.-.",
+}
+
+// Prosigns: procedure signals sent as one character, with no letter gap
+// between their letters.  They take precedence over the table above
+// when decoding, so BT reads as a prosign rather than '=', AR rather
+// than '+' and KN rather than '('.
+var prosigns = map[string]string{
+	"AR":  ".-.-.",
+	"AS":  ".-...",
+	"BT":  "-...-",
+	"KN":  "-.--.",
+	"SK":  "...-.-",
+	"SOS": "...---...",
+}
+
+// The extended international table: accented letters and the like,
+// sent in some languages.  Several letters may share a pattern (Ü and
+// Ŭ, Ö and Ø), in which case the commonest stands for them all.
+var morseExtended = map[string]string{
+	".--.-": "À",
+	".-.-":  "Ä",
+	"-.-..": "Ç",
+	"----":  "CH",
+	"..-..": "É",
+	".-..-": "È",
+	"--.-.": "Ĝ",
+	".---.": "Ĵ",
+	"--.--": "Ñ",
+	"---.":  "Ö",
+	"...-.": "Ŝ",
+	".--..": "Þ",
+	"..--.": "Ü",
+}
+
+// The Russian table, used for Cyrillic traffic.  Its letters take the
+// patterns of the Latin letters they sound like where there is one;
+// digits and punctuation are as in the international table.
+var morseCyrillic = map[rune]string{
+	'А': ".-",
+	'Б': "-...",
+	'В': ".--",
+	'Г': "--.",
+	'Д': "-..",
+	'Е': ".",
+	'Ж': "...-",
+	'З': "--..",
+	'И': "..",
+	'Й': ".---",
+	'К': "-.-",
+	'Л': ".-..",
+	'М': "--",
+	'Н': "-.",
+	'О': "---",
+	'П': ".--.",
+	'Р': ".-.",
+	'С': "...",
+	'Т': "-",
+	'У': "..-",
+	'Ф': "..-.",
+	'Х': "....",
+	'Ц': "-.-.",
+	'Ч': "---.",
+	'Ш': "----",
+	'Щ': "--.-",
+	'Ъ': "--.--",
+	'Ы': "-.--",
+	'Ь': "-..-",
+	'Э': "..-..",
+	'Ю': "..--",
+	'Я': ".-.-",
+}
+
+// The Greek table.  Like the Russian, it follows the Latin patterns
+// where it can.
+var morseGreek = map[rune]string{
+	'Α': ".-",
+	'Β': "-...",
+	'Γ': "--.",
+	'Δ': "-..",
+	'Ε': ".",
+	'Ζ': "--..",
+	'Η': "....",
+	'Θ': "-.-.",
+	'Ι': "..",
+	'Κ': "-.-",
+	'Λ': ".-..",
+	'Μ': "--",
+	'Ν': "-.",
+	'Ξ': "-..-",
+	'Ο': "---",
+	'Π': ".--.",
+	'Ρ': ".-.",
+	'Σ': "...",
+	'Τ': "-",
+	'Υ': "-.--",
+	'Φ': "..-.",
+	'Χ': "----",
+	'Ψ': "--.-",
+	'Ω': ".--",
+}
+
+// The Hebrew table.
+var morseHebrew = map[rune]string{
+	'א': ".-",
+	'ב': "-...",
+	'ג': "--.",
+	'ד': "-..",
+	'ה': "---",
+	'ו': ".",
+	'ז': "--..",
+	'ח': "....",
+	'ט': "..-",
+	'י': "..",
+	'כ': "-.-",
+	'ל': ".-..",
+	'מ': "--",
+	'נ': "-.",
+	'ס': "-.-.",
+	'ע': ".---",
+	'פ': ".--.",
+	'צ': ".--",
+	'ק': "--.-",
+	'ר': ".-.",
+	'ש': "...",
+	'ת': "-",
+}
+
+// The Arabic table.
+var morseArabic = map[rune]string{
+	'ا': ".-",
+	'ب': "-...",
+	'ت': "-",
+	'ث': "-.-.",
+	'ج': ".---",
+	'ح': "....",
+	'خ': "---",
+	'د': "-..",
+	'ذ': "--..",
+	'ر': ".-.",
+	'ز': "---.",
+	'س': "...",
+	'ش': "----",
+	'ص': "-..-",
+	'ض': "...-",
+	'ط': "..-",
+	'ظ': "-.--",
+	'ع': ".-.-",
+	'غ': "--.",
+	'ف': "..-.",
+	'ق': "--.-",
+	'ك': "-.-",
+	'ل': ".-..",
+	'م': "--",
+	'ن': "-.",
+	'ه': "..-..",
+	'و': ".--",
+	'ي': "..",
+	'ء': ".",
+}
+
+// Wabun, the Japanese code: one pattern per kana.  Digits are as in
+// the international table.  A transmission switches to Wabun with the
+// DO prosign and back with SN.
+var morseWabun = map[rune]string{
+	'イ': ".-",
+	'ロ': ".-.-",
+	'ハ': "-...",
+	'ニ': "-.-.",
+	'ホ': "-..",
+	'ヘ': ".",
+	'ト': "..-..",
+	'チ': "..-.",
+	'リ': "--.",
+	'ヌ': "....",
+	'ル': "-.--.",
+	'ヲ': ".---",
+	'ワ': "-.-",
+	'カ': ".-..",
+	'ヨ': "--",
+	'タ': "-.",
+	'レ': "---",
+	'ソ': "---.",
+	'ツ': ".--.",
+	'ネ': "--.-",
+	'ナ': ".-.",
+	'ラ': "...",
+	'ム': "-",
+	'ウ': "..-",
+	'ヰ': ".-..-",
+	'ノ': "..--",
+	'オ': ".-...",
+	'ク': "...-",
+	'ヤ': ".--",
+	'マ': "-..-",
+	'ケ': "-.--",
+	'フ': "--..",
+	'コ': "----",
+	'エ': "-.---",
+	'テ': ".-.--",
+	'ア': "--.--",
+	'サ': "-.-.-",
+	'キ': "-.-..",
+	'ユ': "-..--",
+	'メ': "-...-",
+	'ミ': "..-.-",
+	'シ': "--.-.",
+	'ヱ': ".--..",
+	'ヒ': "--..-",
+	'モ': "-..-.",
+	'セ': ".---.",
+	'ス': "---.-",
+	'ン': ".-.-.",
+	'゛': "..",
+	'゜': "..--.",
+	'ー': ".--.-",
+	'、': ".-.-.-",
+	'」': ".-.-..",
+	'（': "-.--.-",
+	'）': ".-..-.",
+}
+
+// The prosigns that switch into and out of Wabun.
+const (
+	wabunDO = "-..---"
+	wabunSN = "...-."
+)
+
+// Alphabets the letters can be decoded in, by name.  Nil stands for
+// the international table's own Latin letters.  Japanese traffic is
+// Latin until switched to Wabun, except that with the "ja" charset it
+// starts out in Wabun.  The "american" charset is a different code
+// altogether (see american.go).
+var charsets = map[string]map[rune]string{
+	"latin":    nil,
+	"ru":       morseCyrillic,
+	"el":       morseGreek,
+	"he":       morseHebrew,
+	"ar":       morseArabic,
+	"ja":       nil,
+	"american": nil,
+}
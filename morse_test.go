@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+// Every punctuation mark of ITU-R M.1677, through the decode table.
+// Three share a prosign's pattern, and the prosign wins.
+func TestDecodePunctuation(t *testing.T) {
+	tests := []struct {
+		code, want string
+	}{
+		{".-.-.-", "."},
+		{"--..--", ","},
+		{"---...", ":"},
+		{"..--..", "?"},
+		{".----.", "'"},
+		{"-....-", "-"},
+		{"-..-.", "/"},
+		{"-.--.-", ")"},
+		{".-..-.", "\""},
+		{".--.-.", "@"},
+		{"-...-", "<BT>"}, // not '='
+		{".-.-.", "<AR>"}, // not '+'
+		{"-.--.", "<KN>"}, // not '('
+	}
+	for _, extended := range []bool{false, true} {
+		table := decodeTable("latin", extended)
+		for _, tt := range tests {
+			if got := table[tt.code]; got != tt.want {
+				t.Errorf("%s (extended %v) decodes as %q, want %q", tt.code, extended, got, tt.want)
+			}
+		}
+	}
+}
+
+// Every ITU punctuation mark is in the table, and no two characters
+// share a pattern there.
+func TestPunctuationPatternsDistinct(t *testing.T) {
+	seen := make(map[string]rune)
+	for r, code := range morseTable {
+		if other, ok := seen[code]; ok {
+			t.Errorf("%q and %q share %s", r, other, code)
+		}
+		seen[code] = r
+	}
+	for _, r := range ".,:?'-/()\"=+@" {
+		if morseTable[r] == "" {
+			t.Errorf("no pattern for %q", r)
+		}
+	}
+}
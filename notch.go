@@ -0,0 +1,110 @@
+// Notch filters for steady interference.
+//
+// A birdie, a neighbour's carrier or the hum off a mains-powered
+// receiver sits at one pitch and never keys.  Inside the detection
+// bandwidth it lifts the floor under every element, or, loud enough,
+// keys the detector solid.  A notch takes out a narrow slice around it
+// and leaves the rest.  Notches are given as a comma-separated list:
+//
+//   F          notch 'notchWidth' Hz wide at F Hz
+//   F:W        notch W Hz wide at F Hz
+//   hum:F      notch every harmonic of F Hz (e.g. 50 or 60) within
+//              the detection bandwidth either side of the tone
+//
+// so "-notch 1020,hum:60" takes out a birdie and the mains hum.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Width of a notch unless given, in Hz: narrow enough to leave a tone
+// a few tens of Hz away alone.
+const notchWidth = 10
+
+type notchSpec struct {
+	freq  float64 // Hz
+	width float64 // Hz
+	hum   bool    // whether to notch all of 'freq's harmonics
+}
+
+// Parse a list of notches, as described above.
+func parseNotches(spec string) ([]notchSpec, error) {
+	var specs []notchSpec
+	if spec == "" {
+		return nil, nil
+	}
+	for _, f := range strings.Split(spec, ",") {
+		s := notchSpec{width: notchWidth}
+		arg := strings.TrimSpace(f)
+		if strings.HasPrefix(arg, "hum:") {
+			s.hum, arg = true, strings.TrimPrefix(arg, "hum:")
+		}
+		freq, width, hasWidth := strings.Cut(arg, ":")
+		var err error
+		s.freq, err = strconv.ParseFloat(freq, 64)
+		if err == nil && hasWidth && !s.hum {
+			s.width, err = strconv.ParseFloat(width, 64)
+		}
+		switch {
+		case err != nil:
+		case hasWidth && s.hum:
+			err = fmt.Errorf("hum takes no width")
+		case s.freq <= 0 || s.width <= 0:
+			err = fmt.Errorf("frequency and width must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("notch %q: %v", f, err)
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// Return the notch filters 'specs' call for, for a tone at 'freq' Hz
+// detected 'bandwidth' Hz wide, in audio sampled at 'rate'.  Anything
+// at or above the Nyquist frequency is left out.
+func notchFilters(specs []notchSpec, freq, bandwidth float64, rate int) []*biquad {
+	var filters []*biquad
+	add := func(f, width float64) {
+		if f < float64(rate)/2 {
+			filters = append(filters, newNotchBiquad(f, f/width, float64(rate)))
+		}
+	}
+	for _, s := range specs {
+		if !s.hum {
+			add(s.freq, s.width)
+			continue
+		}
+		first := math.Max(1, math.Ceil((freq-bandwidth)/s.freq))
+		for h := first; h*s.freq <= freq+bandwidth; h++ {
+			add(h*s.freq, s.width)
+		}
+	}
+	return filters
+}
+
+// Read audio chunks from 'chunks' and pass them on through each of
+// 'filters' in turn.
+func getNotchPipe(chunks chan []int32, filters []*biquad) chan []int32 {
+	out := make(chan []int32)
+	go func() {
+		for chunk := range chunks {
+			notched := make([]int32, len(chunk))
+			for i, v := range chunk {
+				x := float64(v)
+				for _, f := range filters {
+					x = f.process(x)
+				}
+				notched[i] = int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, x)))
+			}
+			out <- notched
+		}
+		close(out)
+	}()
+	return out
+}
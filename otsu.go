@@ -0,0 +1,140 @@
+// Otsu threshold quantizer.
+//
+// The window quantizer keys on above the midpoint of its window's
+// range.  That's only where marks and spaces divide when the window
+// holds about as much of each: a slow dah-heavy character leaves a few
+// noise amplitudes at the bottom of the range and many mark amplitudes
+// at the top, and the noise spikes decide where the midpoint falls.
+//
+// Otsu's method instead makes a histogram of the window and picks the
+// threshold which best splits it in two: the one that makes the two
+// classes' means furthest apart, weighted by how many amplitudes each
+// holds.
+
+package main
+
+import "math"
+
+const (
+	// Histogram bins across the window's range.
+	otsuBins = 32
+
+	// Share of the window's variance the split must account for
+	// before it's trusted.  A window of nothing but marks, or nothing
+	// but noise, has one hump, and Otsu's method cuts it in half;
+	// for a normal distribution that accounts for 2/π of it.  Nor is
+	// a split trusted unless the window's range spans minPeakToFloor,
+	// as the detector's ripple along a long mark can have two humps.
+	otsuSeparation = 0.8
+)
+
+// Return a quantizer which judges each amplitude against a threshold
+// chosen by Otsu's method over the last 'size' of them, or over a
+// window sized to the sending speed given a unit estimate 'est'.
+//
+// While the window holds no clear split, or isn't yet full, it keeps
+// the last threshold it found.  Until it has found one, it uses half
+// the window's peak as it stands at each amplitude, since the first
+// marks start on the key-down ramp, far below the peak to come; but
+// it keys on nothing until that threshold stands minPeakToFloor above
+// the window's mean, so the noise ahead of the first mark isn't taken
+// for one.  Given a calibration 'cal', it never keys on amplitudes
+// down in the noise.
+func otsuQuantizer(size int, cal *calibration, est *unitEstimate) func(chan int32, chan bool) {
+	var gate int32
+	if cal != nil {
+		gate = int32(cal.gate())
+	}
+	capacity := size
+	if est != nil {
+		capacity = est.quantizeCapacity(size)
+	}
+	return func(amplitudes chan int32, quants chan bool) {
+		window := make([]float64, capacity)
+		seen := 0
+		var threshold float64
+		trusted := false // whether 'threshold' is from a split
+		for amp := range amplitudes {
+			if est != nil {
+				size = est.quantizeWindow(size)
+				if size > capacity {
+					size = capacity
+				}
+			}
+			level := float64(amp)
+			window[seen%capacity] = level
+			seen++
+			n := seen
+			if n > size {
+				n = size
+			}
+			lo, hi := level, level
+			var sum float64
+			for i := 1; i <= n; i++ {
+				l := window[(seen-i)%capacity]
+				lo = math.Min(lo, l)
+				hi = math.Max(hi, l)
+				sum += l
+			}
+			// As with the window quantizer, no range at all means
+			// there's nothing to key on.
+			keyed := false
+			if hi > lo {
+				var hist [otsuBins]int
+				width := (hi - lo) / otsuBins
+				for i := 1; i <= n; i++ {
+					b := int((window[(seen-i)%capacity] - lo) / width)
+					if b >= otsuBins {
+						b = otsuBins - 1
+					}
+					hist[b]++
+				}
+				split, separation := otsuSplit(hist[:])
+				switch {
+				case separation >= otsuSeparation && n == size && hi >= minPeakToFloor*lo:
+					threshold = lo + float64(split+1)*width
+					trusted = true
+				case trusted:
+				case hi/2 < minPeakToFloor*sum/float64(n):
+					threshold = math.Inf(1)
+				default:
+					threshold = hi / 2
+				}
+				keyed = level >= threshold && amp >= gate
+			}
+			quants <- keyed
+		}
+		close(quants)
+	}
+}
+
+// Return the bin of 'hist' that ends the lower of the two classes
+// Otsu's method splits it into, and the share of the variance that the
+// split accounts for.
+func otsuSplit(hist []int) (int, float64) {
+	var total, sum, squares float64
+	for i, h := range hist {
+		total += float64(h)
+		sum += float64(i * h)
+		squares += float64(i * i * h)
+	}
+	var w0, sum0, best float64
+	split := len(hist) / 2
+	for i, h := range hist[:len(hist)-1] {
+		w0 += float64(h)
+		sum0 += float64(i * h)
+		w1 := total - w0
+		if w0 == 0 || w1 == 0 {
+			continue
+		}
+		d := sum0/w0 - (sum-sum0)/w1
+		if v := w0 * w1 * d * d; v > best {
+			best, split = v, i
+		}
+	}
+	variance := squares/total - (sum/total)*(sum/total)
+	if variance == 0 {
+		return split, 0
+	}
+	return split, best / (total * total) / variance
+}
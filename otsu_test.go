@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The default quantizer has nothing to split until its window fills,
+// so the first letter rests on its cold-start threshold.
+func TestDefaultConfigDecodesFirstLetter(t *testing.T) {
+	const rate = 8000
+	for _, noise := range []float64{0, 0.005, 0.02, 0.05} {
+		samples := synthesize(synthSettings{"PARIS PARIS", 20, 700, noise}, rate)
+		text, _ := decodeSamples(defaultConfig(), samples, rate)
+		if !strings.HasPrefix(strings.TrimSpace(text), "PARIS PARIS") {
+			t.Errorf("at noise %v decoded %q, want PARIS PARIS", noise, text)
+		}
+	}
+}
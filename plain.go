@@ -0,0 +1,139 @@
+// Plain-language copy.
+//
+// CW contacts are written in a shorthand of their own: "TNX FER CALL
+// ES FB RPT OM" is "thanks for the call and fine business report old
+// man" to an operator, and line noise to anyone else.  Alongside the
+// literal copy the decoder can write a second, plain one, with each
+// abbreviation, Q code and prosign it knows spelled out in English.
+// The literal copy is left exactly as decoded.
+
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Common CW abbreviations, and what they stand for.
+var abbreviations = map[string]string{
+	"73":    "best regards",
+	"88":    "love and kisses",
+	"5NN":   "599",
+	"ABT":   "about",
+	"AGN":   "again",
+	"ANT":   "antenna",
+	"BCNU":  "be seeing you",
+	"BK":    "back to you",
+	"CFM":   "confirm",
+	"CPY":   "copy",
+	"CQ":    "calling any station",
+	"CUL":   "see you later",
+	"DE":    "from",
+	"DR":    "dear",
+	"DX":    "distant station",
+	"ES":    "and",
+	"FB":    "fine business",
+	"FER":   "for",
+	"GA":    "good afternoon",
+	"GE":    "good evening",
+	"GL":    "good luck",
+	"GM":    "good morning",
+	"GN":    "good night",
+	"GUD":   "good",
+	"HI":    "(laughter)",
+	"HPE":   "hope",
+	"HR":    "here",
+	"HW":    "how",
+	"HW?":   "how do you copy?",
+	"K":     "over",
+	"MNI":   "many",
+	"NR":    "number",
+	"NW":    "now",
+	"OM":    "old man",
+	"OP":    "operator",
+	"PSE":   "please",
+	"PWR":   "power",
+	"R":     "received",
+	"RIG":   "radio",
+	"RPT":   "report",
+	"RST":   "signal report",
+	"SIG":   "signal",
+	"SRI":   "sorry",
+	"TKS":   "thanks",
+	"TNX":   "thanks",
+	"TU":    "thank you",
+	"UR":    "your",
+	"VY":    "very",
+	"WID":   "with",
+	"WX":    "weather",
+	"XYL":   "wife",
+	"YL":    "young lady",
+	"<AR>":  "(end of message)",
+	"<AS>":  "(wait)",
+	"<BT>":  "(break)",
+	"<KN>":  "(over to you only)",
+	"<SK>":  "(end of contact)",
+	"<SOS>": "(distress)",
+}
+
+// Write a plain-language copy of decoded text.
+type plainCopy struct {
+	w    io.Writer
+	word strings.Builder // the word in progress
+}
+
+// Open a plain copy writing to 'filename'; "-" means standard error.
+func openPlainCopy(filename string) (*plainCopy, error) {
+	if filename == "-" {
+		return &plainCopy{w: os.Stderr}, nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &plainCopy{w: f}, nil
+}
+
+// Take the next piece of decoded text, writing out each word it
+// completes.
+func (p *plainCopy) add(text string) {
+	if p == nil {
+		return
+	}
+	for _, r := range text {
+		if r != ' ' && r != '\n' {
+			p.word.WriteRune(r)
+			continue
+		}
+		io.WriteString(p.w, plainWord(p.word.String())+string(r))
+		p.word.Reset()
+	}
+}
+
+// Write out the last word, at the end of the stream.
+func (p *plainCopy) flush() {
+	if p == nil || p.word.Len() == 0 {
+		return
+	}
+	io.WriteString(p.w, plainWord(p.word.String())+"\n")
+	p.word.Reset()
+}
+
+// Return the plain-language form of 'word'.
+func plainWord(word string) string {
+	if s, ok := abbreviations[word]; ok {
+		return s
+	}
+	code := strings.TrimSuffix(word, "?")
+	if s, ok := abbreviations[code]; ok && code != word {
+		return s + "?"
+	}
+	if meanings, ok := qCodes[code]; ok {
+		if code != word {
+			return meanings[0]
+		}
+		return meanings[1]
+	}
+	return word
+}
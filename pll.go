@@ -0,0 +1,90 @@
+// Phase-locked loop detector.
+//
+// The energy detectors measure how much there is of everything near
+// the tone: the tone itself, but also the noise in phase with it and
+// the noise 90 degrees out, and anything else close by.  A receiver
+// that knows the tone's phase can ignore half of that.  The loop here
+// runs a local oscillator, mixes the audio down with it to an in-phase
+// and a quadrature arm, and steers the oscillator to keep the
+// quadrature arm at zero.  Once it's locked the tone sits entirely in
+// the in-phase arm, which is the amplitude reported: half the noise
+// power, so about 3 dB better than an envelope, and a signal off the
+// tone beats against the oscillator and averages away.
+//
+// Between elements there's nothing to lock to, and a loop left running
+// would chase the noise.  So the loop only steers while the arms hold
+// clearly more than the noise floor, and otherwise coasts at the
+// frequency it last found.  Nor can it pull the oscillator further
+// than half the bandwidth either side of the tone.
+
+package main
+
+import "math"
+
+const (
+	// Bandwidth of the loop, in Hz: how quickly it pulls in at the
+	// start of each element, and how much noise jitters its phase.
+	pllLoopBandwidth = 25
+
+	// How far above the noise floor the arms must be for the loop to
+	// steer: 6 dB.
+	pllLockLevel = 2
+)
+
+type pllDetector struct {
+	freq   float64 // the oscillator's centre, in radians per sample
+	pull   float64 // furthest it may stray from 'freq'
+	alpha  float64 // phase gain
+	beta   float64 // frequency gain
+	phase  float64 // the oscillator's
+	offset float64 // its frequency from 'freq', in radians per sample
+	i, q   *biquad // arm filters
+	fast   float64 // smoothing for the floor falling
+	slow   float64 // and rising
+	floor  float64 // noise floor of the arms' magnitude
+}
+
+// Make a detector for 'freq' Hz, with arms 'bandwidth' Hz wide, in
+// audio sampled at 'rate'.
+func newPLLDetector(freq, bandwidth float64, rate int) *pllDetector {
+	r := float64(rate)
+	// A second-order loop damped by 1/√2, which has a noise bandwidth
+	// of about 0.53 of its natural frequency.
+	wn := pllLoopBandwidth / 0.53 / r
+	return &pllDetector{
+		freq:  2 * math.Pi * freq / r,
+		pull:  math.Pi * bandwidth / r,
+		alpha: math.Sqrt2 * wn,
+		beta:  wn * wn,
+		i:     newLowpassBiquad(bandwidth/2, math.Sqrt2/2, r),
+		q:     newLowpassBiquad(bandwidth/2, math.Sqrt2/2, r),
+		fast:  smoothing(levelFast, 1/r),
+		slow:  smoothing(levelSlow, 1/r),
+	}
+}
+
+func (p *pllDetector) amplitude(chunk []int32) int32 {
+	var sum float64
+	for _, v := range chunk {
+		x := float64(v)
+		sin, cos := math.Sincos(p.phase)
+		i := p.i.process(x * cos)
+		q := p.q.process(-x * sin)
+		m := math.Hypot(i, q)
+		if m < p.floor {
+			p.floor += p.fast * (m - p.floor)
+		} else {
+			p.floor += p.slow * (m - p.floor)
+		}
+		var err float64
+		if m > pllLockLevel*p.floor {
+			err = math.Atan2(q, i)
+			p.offset = math.Max(-p.pull, math.Min(p.pull, p.offset+p.beta*err))
+		}
+		p.phase = math.Mod(p.phase+p.freq+p.offset+p.alpha*err, 2*math.Pi)
+		// Mixing halves the tone; an arm pointing the wrong way is
+		// not a tone at all.
+		sum += math.Max(2*i, 0)
+	}
+	return int32(math.Min(sum/float64(len(chunk)), math.MaxInt32))
+}
@@ -0,0 +1,111 @@
+// Narrowband pre-filter, ahead of amplitude detection.
+//
+// An adjacent signal or a burst of broadband noise inflates the
+// quantizer's min/max window and swamps the signal we want.  This
+// stage passes only a band around the CW tone, using a windowed-sinc
+// FIR bandpass applied by FFT overlap-add: each block of input is
+// transformed, multiplied by the filter's spectrum and transformed
+// back, with the tail of each block's convolution added into the next.
+
+package main
+
+import "math"
+
+type overlapAdd struct {
+	block  int          // input samples per FFT
+	kernel []complex128 // spectrum of the filter kernel
+	tail   []float64    // convolution overlap carried to the next block
+	buf    []complex128
+}
+
+// Make a bandpass filter 'width' Hz wide centred on 'freq' Hz, for
+// audio sampled at 'rate'.
+func newBandpass(freq, width float64, rate int) *overlapAdd {
+	// Narrower bands need longer kernels; four cycles of the band
+	// edge frequency gives reasonably steep skirts.
+	m := int(4*float64(rate)/width) | 1
+	if m > 4095 {
+		m = 4095
+	}
+	n := nextPow2(2 * m)
+	o := &overlapAdd{
+		block:  n - m + 1,
+		kernel: make([]complex128, n),
+		tail:   make([]float64, m-1),
+		buf:    make([]complex128, n),
+	}
+	for i, h := range bandpassKernel(m, freq, width, float64(rate)) {
+		o.kernel[i] = complex(h, 0)
+	}
+	fft(o.kernel, false)
+	return o
+}
+
+// Filter one block of exactly o.block samples.
+func (o *overlapAdd) process(in []float64) []float64 {
+	for i := range o.buf {
+		o.buf[i] = 0
+	}
+	for i, v := range in {
+		o.buf[i] = complex(v, 0)
+	}
+	fft(o.buf, false)
+	for i := range o.buf {
+		o.buf[i] *= o.kernel[i]
+	}
+	fft(o.buf, true)
+
+	out := make([]float64, len(in))
+	for i := range out {
+		out[i] = real(o.buf[i])
+		if i < len(o.tail) {
+			out[i] += o.tail[i]
+		}
+	}
+	// New tail: the rest of this block's convolution, plus whatever
+	// of the old tail reaches past this block.
+	for i := range o.tail {
+		v := real(o.buf[len(in)+i])
+		if len(in)+i < len(o.tail) {
+			v += o.tail[len(in)+i]
+		}
+		o.tail[i] = v
+	}
+	return out
+}
+
+// Read audio chunks from 'chunks', bandpass filter them with 'o', and
+// push chunks of the same size onto the returned channel.
+func getPrefilterPipe(chunks chan []int32, o *overlapAdd) chan []int32 {
+	filtered := make(chan []int32)
+	go func() {
+		var pending []float64
+		var out []int32
+		size := 0
+		for chunk := range chunks {
+			if load.level() >= 4 {
+				// Bypassed to save CPU; whatever was in flight
+				// is stale by now.
+				pending, out = pending[:0], out[:0]
+				filtered <- chunk
+				continue
+			}
+			size = len(chunk)
+			for _, v := range chunk {
+				pending = append(pending, float64(v))
+			}
+			for len(pending) >= o.block {
+				for _, v := range o.process(pending[:o.block]) {
+					out = append(out, int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, v))))
+				}
+				pending = pending[o.block:]
+			}
+			for len(out) >= size {
+				filtered <- out[:size:size]
+				out = out[size:]
+			}
+		}
+		close(filtered)
+	}()
+	return filtered
+}
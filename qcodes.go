@@ -0,0 +1,111 @@
+// Q-code annotation.
+//
+// On-air contacts are full of Q codes: three letters starting with Q,
+// each standing for a question when followed by "?" and for its answer
+// otherwise.  Someone new to CW knows few of them, so the decoder can
+// spell each out as it's copied, either inline after the code or on
+// standard error, leaving the copy itself as it was.  Either way each
+// is also logged as a "q_code" event.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Meanings of the Q codes in amateur use: the question, then the
+// answer.
+var qCodes = map[string][2]string{
+	"QRA": {"what is the name of your station?", "the name of my station is"},
+	"QRG": {"what is my exact frequency?", "your exact frequency is"},
+	"QRK": {"how readable are my signals?", "the readability of your signals is"},
+	"QRL": {"is this frequency in use?", "this frequency is in use"},
+	"QRM": {"is there interference?", "there is interference"},
+	"QRN": {"are you troubled by static?", "I am troubled by static"},
+	"QRO": {"shall I increase power?", "increase power"},
+	"QRP": {"shall I decrease power?", "decrease power"},
+	"QRQ": {"shall I send faster?", "send faster"},
+	"QRS": {"shall I send more slowly?", "send more slowly"},
+	"QRT": {"shall I stop sending?", "I am stopping sending"},
+	"QRU": {"have you anything for me?", "I have nothing for you"},
+	"QRV": {"are you ready?", "I am ready"},
+	"QRX": {"when will you call me again?", "wait, I will call you again"},
+	"QRZ": {"who is calling me?", "you are being called by"},
+	"QSB": {"are my signals fading?", "your signals are fading"},
+	"QSK": {"can you hear me between your signals?", "I can hear you between my signals"},
+	"QSL": {"can you acknowledge receipt?", "I acknowledge receipt"},
+	"QSO": {"can you contact ... ?", "I can contact ..."},
+	"QSP": {"will you relay to ... ?", "I will relay to ..."},
+	"QSY": {"shall I change frequency?", "change frequency"},
+	"QTH": {"what is your location?", "my location is"},
+	"QTR": {"what is the correct time?", "the correct time is"},
+}
+
+// Ways of annotating Q codes, by name.
+var qCodeModes = map[string]bool{"inline": true, "stderr": true}
+
+// Spell out the Q codes in decoded text.
+type qAnnotator struct {
+	inline bool            // whether to annotate inline, rather than on stderr
+	word   strings.Builder // the word in progress
+}
+
+func newQAnnotator(mode string) *qAnnotator {
+	return &qAnnotator{inline: mode == "inline"}
+}
+
+// Take the next piece of decoded text, and return it with any Q codes
+// it completes annotated, if annotating inline.  A nil annotator
+// returns the text as it is.
+func (a *qAnnotator) add(text string) string {
+	if a == nil {
+		return text
+	}
+	var out strings.Builder
+	for _, r := range text {
+		if r != ' ' && r != '\n' {
+			out.WriteRune(r)
+			a.word.WriteRune(r)
+			continue
+		}
+		if s := a.finish(); s != "" {
+			out.WriteString(" " + strings.TrimSuffix(s, " "))
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// Finish the word in progress, at the end of the stream.
+func (a *qAnnotator) flush() string {
+	if a == nil {
+		return ""
+	}
+	if s := a.finish(); s != "" {
+		return " " + strings.TrimSuffix(s, " ")
+	}
+	return ""
+}
+
+// Annotate the word in progress, if it's a Q code, and start the next.
+func (a *qAnnotator) finish() string {
+	word := a.word.String()
+	a.word.Reset()
+	code := strings.TrimSuffix(word, "?")
+	meanings, ok := qCodes[code]
+	if !ok {
+		return ""
+	}
+	meaning := meanings[1]
+	if code != word {
+		meaning = meanings[0]
+	}
+	events.emit("q_code", map[string]interface{}{"code": word, "meaning": meaning})
+	if !a.inline {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", word, meaning)
+		return ""
+	}
+	return "[" + meaning + "] "
+}
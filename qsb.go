@@ -0,0 +1,75 @@
+// QSB (fading) compensation.
+//
+// On HF a signal often fades by 20 dB or more over a few seconds.  The
+// quantizer's threshold can follow a slow fade, but not one deep
+// enough to drop marks below the level the spaces were at a moment
+// before, and a single fade cycle garbles several letters.
+//
+// AGC helps, but it follows whatever is loudest, noise included.  The
+// QSB compensator instead measures the key-down level alone, as the
+// loud end of the amplitudes over the last second or so, and scales
+// the stream to hold that level steady.  It won't scale the key-down
+// level up to less than qsbContrast times the key-up level, so that
+// when the signal fades out entirely the noise isn't blown up to take
+// its place.  Nor will it let a signal arriving out of silence through
+// at more than twice the level.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	qsbHigh     = 0.9     // percentile of the window taken as key-down
+	qsbLow      = 0.1     // and as key-up
+	qsbContrast = 10      // 20 dB; noise alone spans about 13 dB between the percentiles
+	qsbLevel    = 1 << 24 // key-down level held, as for AGC
+)
+
+// Return a stage which compensates for fades over 'window', for a
+// stream of amplitudes measured every 'step' seconds.
+func qsbStage(window time.Duration, step float64) func(chan int32) chan int32 {
+	n := int(window.Seconds() / step)
+	if n < 16 {
+		n = 16
+	}
+	return func(amplitudes chan int32) chan int32 {
+		out := make(chan int32)
+		go func() {
+			hist := make([]int32, 0, n)
+			sorted := make([]int32, n)
+			gain := 0.0
+			for i := 0; ; i++ {
+				amp, ok := <-amplitudes
+				if !ok {
+					break
+				}
+				if len(hist) < n {
+					hist = append(hist, amp)
+				} else {
+					hist[i%n] = amp
+				}
+				// Re-measure eight times per window.
+				if i%(n/8) == 0 {
+					s := sorted[:len(hist)]
+					copy(s, hist)
+					sort.Sort(byInt32(s))
+					high := float64(s[int(qsbHigh*float64(len(s)-1))])
+					low := float64(s[int(qsbLow*float64(len(s)-1))])
+					gain = qsbLevel / math.Max(math.Max(high, qsbContrast*low), 1)
+				}
+				// A signal arriving out of silence mustn't be
+				// blown up until the next measurement.
+				if float64(amp)*gain > 2*qsbLevel {
+					gain = qsbLevel / float64(amp)
+				}
+				out <- int32(math.Min(float64(amp)*gain, math.MaxInt32))
+			}
+			close(out)
+		}()
+		return out
+	}
+}
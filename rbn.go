@@ -0,0 +1,215 @@
+// Validation against the Reverse Beacon Network.
+//
+// The RBN's skimmers spot every CW station they hear, and its archive
+// of spots is public.  Given a recording, when it started, and the
+// dial frequency it was made on, this tool runs the recording through
+// the skimmer, picks the callsigns out of what it decoded, and
+// compares them with the calls the RBN spotted in the same stretch of
+// band over the same period:
+//
+//   cw-decode -rbn https://data.reversebeacon.net/rbn_history/20240115.zip \
+//       -rbn-wav band.wav -rbn-start 2024-01-15T14:00:00Z -rbn-dial 14025
+//
+// The spots may be a URL or a local file, either the archive's daily
+// zip or the CSV inside it.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type rbnSpot struct {
+	call string
+	freq float64 // kHz
+	when time.Time
+}
+
+// Fetch spots from 'src', a URL or file name.
+func loadRBN(src string) ([]rbnSpot, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		var resp *http.Response
+		resp, err = http.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", src, resp.Status)
+		}
+		data, err = io.ReadAll(resp.Body)
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("PK")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		if len(zr.File) == 0 {
+			return nil, errors.New("empty RBN archive")
+		}
+		f, err := zr.File[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseRBN(f)
+	}
+	return parseRBN(bytes.NewReader(data))
+}
+
+// Parse the RBN's CSV, finding columns by their header names.
+func parseRBN(r io.Reader) ([]rbnSpot, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.TrimSpace(h)] = i
+	}
+	for _, h := range []string{"dx", "freq", "date"} {
+		if _, ok := col[h]; !ok {
+			return nil, fmt.Errorf("RBN data has no %q column", h)
+		}
+	}
+	var spots []rbnSpot
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) <= col["dx"] || len(rec) <= col["freq"] || len(rec) <= col["date"] {
+			continue
+		}
+		freq, err1 := strconv.ParseFloat(rec[col["freq"]], 64)
+		when, err2 := time.Parse("2006-01-02 15:04:05", rec[col["date"]])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		spots = append(spots, rbnSpot{strings.ToUpper(rec[col["dx"]]), freq, when})
+	}
+	return spots, nil
+}
+
+// Decode the recording with the skimmer, and return the callsigns
+// heard in it.
+func skimCalls(cfg *config, filename string) (map[string]bool, float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	samples, rate, err := readWav(f)
+	f.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	if rate <= 0 {
+		return nil, 0, fmt.Errorf("bad sample rate %d", rate)
+	}
+	n := chunkSize * rate / sampleRate
+	if n < 1 {
+		n = 1
+	}
+	chunks := make(chan []int32)
+	go func() {
+		for rest := samples; len(rest) >= n; rest = rest[n:] {
+			chunks <- rest[:n]
+		}
+		close(chunks)
+	}()
+	calls := make(map[string]bool)
+	s := newSkimmer(cfg, captureSettings{rate: rate, chunk: n})
+	s.report = func(freq float64, m *message) {
+		for _, c := range callsigns(m.Text) {
+			calls[c] = true
+		}
+	}
+	s.run(chunks)
+	return calls, float64(len(samples)) / float64(rate), nil
+}
+
+// Run the comparison 'cfg' describes, and print the results.
+func compareRBN(cfg *config) error {
+	start, err := time.Parse(time.RFC3339, cfg.RBNStart)
+	if err != nil {
+		return fmt.Errorf("rbn-start: %v", err)
+	}
+	spots, err := loadRBN(cfg.RBN)
+	if err != nil {
+		return err
+	}
+	heard, seconds, err := skimCalls(cfg, cfg.RBNRecording)
+	if err != nil {
+		return err
+	}
+	end := start.Add(time.Duration(seconds * float64(time.Second)))
+	lo := cfg.RBNDial + cfg.TuneMin/1000
+	hi := cfg.RBNDial + cfg.TuneMax/1000
+
+	spotted := make(map[string]bool)
+	for _, sp := range spots {
+		if sp.when.Before(start) || sp.when.After(end) || sp.freq < lo || sp.freq > hi {
+			continue
+		}
+		spotted[sp.call] = true
+	}
+	var both, missed, extra []string
+	for c := range spotted {
+		if heard[c] {
+			both = append(both, c)
+		} else {
+			missed = append(missed, c)
+		}
+	}
+	for c := range heard {
+		if !spotted[c] {
+			extra = append(extra, c)
+		}
+	}
+	sort.Strings(both)
+	sort.Strings(missed)
+	sort.Strings(extra)
+
+	fmt.Printf("RBN spotted %s calls between %s and %s kHz; we heard %s\n",
+		human.int(int64(len(spotted))), human.float(lo, 2), human.float(hi, 2), human.int(int64(len(heard))))
+	fmt.Printf("both:   %s\nmissed: %s\nextra:  %s\n",
+		strings.Join(both, " "), strings.Join(missed, " "), strings.Join(extra, " "))
+	if len(spotted) > 0 {
+		fmt.Printf("recall %s%%", human.float(100*float64(len(both))/float64(len(spotted)), 1))
+		if len(heard) > 0 {
+			fmt.Printf(", precision %s%%", human.float(100*float64(len(both))/float64(len(heard)), 1))
+		}
+		fmt.Println()
+	}
+	events.emit("rbn_compare", map[string]interface{}{
+		"spotted": len(spotted),
+		"heard":   len(heard),
+		"both":    both,
+		"missed":  missed,
+		"extra":   extra,
+	})
+	return nil
+}
@@ -0,0 +1,170 @@
+// Instant replay: decode a recent span of live audio again.
+//
+// When a contact goes by garbled, the question is whether different
+// settings would have copied it: a narrower filter, another detector
+// or quantizer, no debouncing.  With -replay the decoder keeps the
+// last -replay-length of audio in memory (about 10 MB a minute at the
+// usual 44.1 kHz), and serves decodes of any of it over HTTP while the
+// live decode carries on:
+//
+//   curl 'http://localhost:8083/replay?from=2m&to=1m30s&bandwidth=100'
+//
+// decodes the half minute from two minutes ago to a minute and a half
+// ago, which 'to' may leave out for up to now.  Every other parameter
+// is a flag to decode with in place of the live one, and the reply is
+// the same JSON as server mode's.  Only settings the pipeline reads as
+// it's built can change: asking for one of those main sets up once,
+// such as -charset or -correct, is refused rather than ignored.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flags main applies once, for the whole run, which a replay can't
+// change.
+var replayFixed = map[string]bool{
+	"charset":      true,
+	"extended":     true,
+	"clamp-bounds": true,
+	"key":          true,
+	"correct":      true,
+	"segment":      true,
+	"spell":        true,
+	"dictionary":   true,
+}
+
+// The last stretch of audio, as a ring of samples.
+type replayBuffer struct {
+	rate int
+
+	mu      sync.Mutex
+	samples []int32
+	next    int  // where the next sample goes
+	full    bool // whether 'samples' has wrapped around
+}
+
+// Make a buffer which keeps the last 'length' of audio sampled at
+// 'rate'.
+func newReplayBuffer(rate int, length time.Duration) *replayBuffer {
+	return &replayBuffer{rate: rate, samples: make([]int32, int(length.Seconds()*float64(rate)))}
+}
+
+func (b *replayBuffer) add(chunk []int32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range chunk {
+		b.samples[b.next] = s
+		if b.next++; b.next == len(b.samples) {
+			b.next, b.full = 0, true
+		}
+	}
+}
+
+// Return a copy of the samples from 'from' ago to 'to' ago, or as much
+// of that span as is still kept.
+func (b *replayBuffer) span(from, to time.Duration) []int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kept := b.next
+	if b.full {
+		kept = len(b.samples)
+	}
+	start := int(from.Seconds() * float64(b.rate))
+	end := int(to.Seconds() * float64(b.rate))
+	if start > kept {
+		start = kept
+	}
+	if end < 0 {
+		end = 0
+	}
+	var out []int32
+	for ago := start; ago > end; ago-- {
+		i := b.next - ago
+		if i < 0 {
+			i += len(b.samples)
+		}
+		out = append(out, b.samples[i])
+	}
+	return out
+}
+
+// Keep the audio from 'chunks' in 'b' as it passes.
+func getReplayPipe(chunks chan []int32, b *replayBuffer) chan []int32 {
+	out := make(chan []int32)
+	go func() {
+		for chunk := range chunks {
+			b.add(chunk)
+			out <- chunk
+		}
+		close(out)
+	}()
+	return out
+}
+
+// Serve decodes of the kept audio at /replay, with 'cfg' as changed by
+// the request's parameters.
+func (b *replayBuffer) handler(cfg *config) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replay", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, err := time.ParseDuration(q.Get("from"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResult{"from: " + err.Error()})
+			return
+		}
+		var to time.Duration
+		if q.Get("to") != "" {
+			if to, err = time.ParseDuration(q.Get("to")); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResult{"to: " + err.Error()})
+				return
+			}
+		}
+		if to >= from {
+			writeJSON(w, http.StatusBadRequest, errorResult{"from must be longer ago than to"})
+			return
+		}
+		var fixed []string
+		for name := range q {
+			if replayFixed[name] {
+				fixed = append(fixed, name)
+			}
+		}
+		if len(fixed) > 0 {
+			sort.Strings(fixed)
+			writeJSON(w, http.StatusBadRequest, errorResult{"set for the whole run, can't change on replay: " + strings.Join(fixed, ", ")})
+			return
+		}
+		c := *cfg
+		fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+		c.registerFlags(fs)
+		for name, values := range q {
+			if name == "from" || name == "to" {
+				continue
+			}
+			if err := fs.Set(name, values[len(values)-1]); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResult{name + ": " + err.Error()})
+				return
+			}
+		}
+		if err := c.validate(); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResult{err.Error()})
+			return
+		}
+		samples := b.span(from, to)
+		text, msgs := decodeSamples(&c, samples, b.rate)
+		writeJSON(w, http.StatusOK, decodeResult{
+			Text:       text,
+			Messages:   msgs,
+			SampleRate: b.rate,
+			Seconds:    float64(len(samples)) / float64(b.rate),
+		})
+	})
+	return mux
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	const rate = 8000
+	b := newReplayBuffer(rate, 20*time.Second)
+	b.add(synthesize(synthSettings{"PARIS PARIS", 20, 700, 0.02}, rate))
+	srv := httptest.NewServer(b.handler(defaultConfig()))
+	defer srv.Close()
+
+	get := func(query string) (int, map[string]interface{}) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/replay?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var v map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, v
+	}
+
+	if status, v := get("from=20s&min-wpm=10"); status != http.StatusOK || !strings.Contains(v["text"].(string), "PARIS") {
+		t.Errorf("replay got %d %v, want PARIS", status, v)
+	}
+	// These only take effect as main starts, so can't be honoured.
+	status, v := get("from=20s&charset=ru&correct=true")
+	if msg, _ := v["error"].(string); status != http.StatusBadRequest || !strings.Contains(msg, "charset, correct") {
+		t.Errorf("replay with -charset and -correct got %d %v, want them refused", status, v)
+	}
+}
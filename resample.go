@@ -0,0 +1,145 @@
+// Sample rate conversion.
+//
+// Every stage after capture costs CPU in proportion to the sample
+// rate, and a CW tone needs nowhere near 44.1kHz; a device that can't
+// be asked for a lower rate can still be resampled down to one.  How
+// well that's done is a trade: a Pi decoding many channels can't
+// afford the best resampler for all of them.  So there are three:
+//
+//   fast      linear interpolation between neighbouring samples; cheap,
+//             but lets through aliases of anything above the new
+//             Nyquist frequency
+//   balanced  polyphase windowed-sinc, 16 taps, with the filter
+//             precomputed at 64 fractional phases
+//   best      windowed-sinc, 64 taps, computed exactly for each output
+//             sample
+//
+// The sinc filters cut off at the lower of the two Nyquist
+// frequencies, so downsampling doesn't alias.
+
+package main
+
+import "math"
+
+var resamplerTaps = map[string]int{ // taps either side of each output sample
+	"fast":     1,
+	"balanced": 8,
+	"best":     32,
+}
+
+const resamplerPhases = 64 // for "balanced"
+
+type resampler struct {
+	step   float64     // input samples per output sample
+	cutoff float64     // as a fraction of the input Nyquist frequency
+	half   int         // taps either side
+	table  [][]float64 // per-phase kernels, if precomputed
+	linear bool
+	hist   []float64 // input not yet finished with
+	t      float64   // position of the next output sample in 'hist'
+}
+
+// Make a resampler from 'from' to 'to' Hz of the given 'quality'.
+func newResampler(from, to int, quality string) *resampler {
+	r := &resampler{
+		step:   float64(from) / float64(to),
+		cutoff: math.Min(1, float64(to)/float64(from)),
+		half:   resamplerTaps[quality],
+		linear: quality == "fast",
+	}
+	r.hist = make([]float64, r.half) // silence before the first sample
+	r.t = float64(r.half - 1)
+	if quality == "balanced" {
+		r.table = make([][]float64, resamplerPhases+1)
+		for p := range r.table {
+			r.table[p] = r.kernel(float64(p) / resamplerPhases)
+		}
+	}
+	return r
+}
+
+// Filter weights for the 2*r.half input samples around an output
+// sample 'frac' of the way between two of them.
+func (r *resampler) kernel(frac float64) []float64 {
+	k := make([]float64, 2*r.half)
+	sum := 0.0
+	for i := range k {
+		x := float64(i-r.half+1) - frac
+		h := r.cutoff
+		if x != 0 {
+			h = math.Sin(math.Pi*r.cutoff*x) / (math.Pi * x)
+		}
+		w := 0.5 + 0.5*math.Cos(math.Pi*x/float64(r.half)) // Hann
+		k[i] = h * w
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum // unity gain at DC
+	}
+	return k
+}
+
+// Add 'in' to the input, and return whatever output it completes.
+func (r *resampler) process(in []float64) []float64 {
+	r.hist = append(r.hist, in...)
+	var out []float64
+	for {
+		i := int(r.t)
+		if i+r.half >= len(r.hist) {
+			break
+		}
+		frac := r.t - float64(i)
+		var v float64
+		switch {
+		case r.linear:
+			v = r.hist[i]*(1-frac) + r.hist[i+1]*frac
+		default:
+			var k []float64
+			if r.table != nil {
+				k = r.table[int(frac*resamplerPhases+0.5)]
+			} else {
+				k = r.kernel(frac)
+			}
+			for j, w := range k {
+				v += w * r.hist[i-r.half+1+j]
+			}
+		}
+		out = append(out, v)
+		r.t += r.step
+	}
+	// Drop input no future output sample will reach.
+	drop := int(r.t) - r.half + 1
+	if drop > len(r.hist) {
+		drop = len(r.hist)
+	}
+	if drop > 0 {
+		r.hist = append(r.hist[:0], r.hist[drop:]...)
+		r.t -= float64(drop)
+	}
+	return out
+}
+
+// Read audio chunks from 'chunks', resample them with 'r', and push
+// chunks of 'size' samples onto the returned channel.
+func getResamplePipe(chunks chan []int32, r *resampler, size int) chan []int32 {
+	resampled := make(chan []int32)
+	go func() {
+		var in []float64
+		var out []int32
+		for chunk := range chunks {
+			in = in[:0]
+			for _, v := range chunk {
+				in = append(in, float64(v))
+			}
+			for _, v := range r.process(in) {
+				out = append(out, int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, v))))
+			}
+			for len(out) >= size {
+				resampled <- out[:size:size]
+				out = out[size:]
+			}
+		}
+		close(resampled)
+	}()
+	return resampled
+}
@@ -0,0 +1,88 @@
+// Word segmentation.
+//
+// Hand senders are loose with their word gaps: "THE QUICK" comes out
+// as "THEQUICK" when the gap is short, and "QUI CK" when a letter gap
+// runs long.  A copyist sorts that out by reading for words, and with
+// -segment the decoder does too.  Wherever the tokenizer wasn't sure
+// whether a gap was between letters or between words, it holds the
+// letters until a gap it was sure of, then tries every way of reading
+// the doubtful gaps and keeps the one whose words are likeliest.
+//
+// The model is a simple one: any word is as likely as its letters
+// drawn at random, except that a dictionary word is segmentKnown times
+// likelier.  So between two unknown words it's left to the timing, and
+// where a known word can be read it tips the balance: a doubtful gap
+// that sounded like a letter gap takes more convincing to become a
+// word gap than one that sounded like a word gap.
+
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	// Confidence in a letter or word gap below which it may be read
+	// as the other.
+	segmentDoubt = 0.95
+
+	// Most letters held for doubtful gaps before they're released
+	// regardless.
+	segmentHold = 40
+
+	// How much likelier a dictionary word is than any other of as
+	// many letters.
+	segmentKnown = 100
+)
+
+// Dictionary words to segment by, set by main if -segment is given.
+var segmentWords *dictionary
+
+// Split 'letters', ended by a word gap, into its likeliest words,
+// splitting only at the doubtful gaps.
+func (d *dictionary) segment(letters []decodedLetter) [][]decodedLetter {
+	n := len(letters)
+	// best[i] is the log chance of the likeliest reading of the first
+	// 'i' letters ending in a word gap, and from[i] where its last
+	// word starts.
+	best := make([]float64, n+1)
+	from := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(-1)
+		joined := 0.0 // log chance of the gaps within letters[j:i] being letter gaps
+		for j := i - 1; j >= 0; j-- {
+			if j < i-1 {
+				joined += math.Log(1 - letters[j].wordGap)
+			}
+			split := 0.0
+			if j > 0 {
+				if letters[j-1].wordGap == 0 {
+					continue
+				}
+				split = math.Log(letters[j-1].wordGap)
+			}
+			if s := best[j] + split + joined + d.wordChance(letters[j:i]); s > best[i] {
+				best[i], from[i] = s, j
+			}
+		}
+	}
+	var words [][]decodedLetter
+	for i := n; i > 0; i = from[i] {
+		words = append([][]decodedLetter{letters[from[i]:i]}, words...)
+	}
+	return words
+}
+
+// Return the log chance of the word 'letters'.
+func (d *dictionary) wordChance(letters []decodedLetter) float64 {
+	var w strings.Builder
+	for _, l := range letters {
+		w.WriteString(l.text)
+	}
+	chance := -float64(len(letters)) * math.Log(float64(len(morseLetters)))
+	if d.known[w.String()] {
+		chance += math.Log(segmentKnown)
+	}
+	return chance
+}
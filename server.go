@@ -0,0 +1,65 @@
+// Server mode: decode audio clips POSTed over HTTP, so that other tools
+// can use the decoder without linking any Go code.
+//
+//   curl --data-binary @clip.wav http://localhost:8080/decode
+//
+// replies with the decoded text as JSON.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Largest clip we're willing to decode in one request.
+const maxUpload = 64 << 20
+
+type decodeResult struct {
+	Text       string     `json:"text"`
+	Messages   []*message `json:"messages"`
+	SampleRate int        `json:"sample_rate"`
+	Seconds    float64    `json:"seconds"`
+}
+
+type errorResult struct {
+	Error string `json:"error"`
+}
+
+func decodeHandler(cfg *config) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/decode", func(w http.ResponseWriter, r *http.Request) {
+		serveDecode(cfg, w, r)
+	})
+	return mux
+}
+
+func serveDecode(cfg *config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, errorResult{"POST a WAV file"})
+		return
+	}
+	samples, rate, err := readWav(http.MaxBytesReader(w, r.Body, maxUpload))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResult{err.Error()})
+		return
+	}
+	if rate <= 0 {
+		writeJSON(w, http.StatusBadRequest, errorResult{"bad sample rate"})
+		return
+	}
+	text, msgs := decodeSamples(cfg, samples, rate)
+	writeJSON(w, http.StatusOK, decodeResult{
+		Text:       text,
+		Messages:   msgs,
+		SampleRate: rate,
+		Seconds:    float64(len(samples)) / float64(rate),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
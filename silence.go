@@ -0,0 +1,53 @@
+// Silent input detection.
+//
+// An unplugged cable, a muted mixer or a sound card that has stopped
+// delivering produces digital silence: every sample zero, or stuck at
+// one value.  Nothing downstream can decode that, and the stages have
+// to be careful not to key on it, but it's also worth telling someone
+// about, since from the outside it looks just like a quiet band.  So
+// input that stays constant for a while is reported, and reported
+// again when it comes back.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// How long input must stay constant to count as silent.
+const silenceAfter = 2 * time.Second
+
+// Read audio chunks sampled at 'rate' from 'chunks', and pass them on
+// unchanged, reporting when the input goes silent and when it returns.
+func getSilencePipe(chunks chan []int32, rate int) chan []int32 {
+	out := make(chan []int32)
+	limit := int(silenceAfter.Seconds() * float64(rate))
+	go func() {
+		var last int32 // value the input has been stuck at
+		run := 0       // samples it has been stuck there
+		silent := false
+		for chunk := range chunks {
+			for _, v := range chunk {
+				if v != last {
+					last, run = v, 0
+				}
+				run++
+			}
+			switch {
+			case !silent && run >= limit:
+				silent = true
+				fmt.Fprintf(os.Stderr, "%s: input silent\n", human.time(time.Now()))
+				events.emit("input_silent", map[string]interface{}{"value": last})
+			case silent && run < limit:
+				silent = false
+				fmt.Fprintf(os.Stderr, "%s: input resumed\n", human.time(time.Now()))
+				events.emit("input_resumed", nil)
+			}
+			out <- chunk
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,239 @@
+// Skimmer: decode every CW signal in the passband at once.
+//
+// Every second or so the skimmer looks at the spectrum of the audio,
+// and wherever a carrier stands clear of the noise without a decoder
+// already on it, it starts a complete decode pipeline with a Goertzel
+// detector on that frequency.  A pipeline whose carrier hasn't been
+// seen for skimIdle is shut down again, and so, one a second, is the
+// one with the weakest carrier while the CPU can't keep up with them
+// all (see degrade.go).  Each pipeline's messages are
+// printed as they complete, tagged with its frequency, so the output
+// is an interleaved transcript of the whole band.
+//
+// With -bank, signals are found by their keying in a Goertzel filter
+// bank instead, so a steady carrier doesn't get a decoder of its own.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	skimBinWidth    = 10 // Hz, roughly, of each FFT bin
+	skimPeakRatio   = 10 // over the median bin: 10 dB
+	skimMaxChannels = 16
+	skimIdle        = 30 * time.Second
+)
+
+type skimChannel struct {
+	freq   float64
+	chunks chan []int32
+	seen   int     // sample count when the carrier was last seen
+	snr    float64 // of the carrier then, in dB
+}
+
+type skimmer struct {
+	cfg      *config
+	cs       captureSettings
+	size     int       // FFT size
+	buf      []float64 // most recent samples
+	window   []float64 // Hann window
+	spectrum []complex128
+	channels []*skimChannel
+	bank     *goertzelBank // finds signals instead of the FFT, if set
+	done     sync.WaitGroup
+	out      sync.Mutex // one transcript line at a time
+
+	// Called with each message and the frequency it was heard on,
+	// one at a time; prints it unless replaced.
+	report func(freq float64, m *message)
+}
+
+func newSkimmer(cfg *config, cs captureSettings) *skimmer {
+	size := nextPow2(cs.rate / skimBinWidth)
+	s := &skimmer{
+		cfg:      cfg,
+		cs:       cs,
+		size:     size,
+		window:   make([]float64, size),
+		spectrum: make([]complex128, size),
+	}
+	for i := range s.window {
+		s.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+	}
+	if cfg.Bank {
+		s.bank = newGoertzelBank(cfg.TuneMin, cfg.TuneMax, cfg.Bandwidth, cs.rate)
+	}
+	s.report = s.print
+	return s
+}
+
+// Skim the audio arriving on 'chunks' until it runs out.
+func (s *skimmer) run(chunks chan []int32) {
+	now := 0  // samples so far
+	next := 0 // when to scan next
+	for chunk := range chunks {
+		now += len(chunk)
+		for _, v := range chunk {
+			s.buf = append(s.buf, float64(v))
+		}
+		if len(s.buf) > s.size {
+			s.buf = append(s.buf[:0], s.buf[len(s.buf)-s.size:]...)
+		}
+		if s.bank != nil {
+			s.bank.add(chunk)
+		}
+		if now >= next {
+			switch lvl := load.level(); {
+			case lvl < 1 && (s.bank != nil || len(s.buf) == s.size):
+				s.scan(now)
+				next = now + s.cs.rate
+			case lvl >= 5 && len(s.channels) > 1:
+				s.retireWeakest()
+				next = now + s.cs.rate
+			}
+		}
+		for _, ch := range s.channels {
+			ch.chunks <- chunk
+		}
+	}
+	for _, ch := range s.channels {
+		close(ch.chunks)
+	}
+	s.done.Wait()
+}
+
+// Look for signals, start pipelines on new ones and retire those that
+// have gone quiet.
+func (s *skimmer) scan(now int) {
+	var found []bankBin
+	if s.bank != nil {
+		found = s.bank.active()
+	} else {
+		found = s.carriers()
+	}
+	for _, b := range found {
+		if ch := s.nearest(b.Freq); ch != nil {
+			ch.seen, ch.snr = now, b.SNR
+		} else if len(s.channels) < skimMaxChannels {
+			s.start(b.Freq, b.SNR, now)
+		}
+	}
+
+	idle := int(skimIdle.Seconds() * float64(s.cs.rate))
+	live := s.channels[:0]
+	for _, ch := range s.channels {
+		if now-ch.seen > idle {
+			close(ch.chunks)
+			continue
+		}
+		live = append(live, ch)
+	}
+	s.channels = live
+}
+
+// Return the carriers in the latest block of audio, with their
+// strength over the median bin.
+func (s *skimmer) carriers() []bankBin {
+	for i, v := range s.buf {
+		s.spectrum[i] = complex(v*s.window[i], 0)
+	}
+	fft(s.spectrum, false)
+
+	binHz := float64(s.cs.rate) / float64(s.size)
+	lo := int(math.Max(1, math.Ceil(s.cfg.TuneMin/binHz)))
+	hi := int(math.Min(float64(s.size/2-2), s.cfg.TuneMax/binHz))
+	if hi <= lo {
+		return nil
+	}
+	mags := make([]float64, hi-lo+1)
+	for k := lo; k <= hi; k++ {
+		mags[k-lo] = cmplx.Abs(s.spectrum[k])
+	}
+	sorted := append([]float64(nil), mags...)
+	sort.Float64s(sorted)
+	floor := sorted[len(sorted)/2]
+
+	var found []bankBin
+	for k := lo + 1; k < hi; k++ {
+		m := mags[k-lo]
+		if m < skimPeakRatio*floor || m < mags[k-lo-1] || m < mags[k-lo+1] {
+			continue
+		}
+		found = append(found, bankBin{Freq: float64(k) * binHz, SNR: 20 * math.Log10(m/floor)})
+	}
+	return found
+}
+
+// Shut down the channel with the weakest carrier.
+func (s *skimmer) retireWeakest() {
+	weakest := 0
+	for i, ch := range s.channels {
+		if ch.snr < s.channels[weakest].snr {
+			weakest = i
+		}
+	}
+	ch := s.channels[weakest]
+	close(ch.chunks)
+	s.channels = append(s.channels[:weakest], s.channels[weakest+1:]...)
+	events.emit("skim_retired", map[string]interface{}{"freq": ch.freq, "snr": ch.snr})
+}
+
+// The channel, if any, whose decoder would hear 'freq'.
+func (s *skimmer) nearest(freq float64) *skimChannel {
+	for _, ch := range s.channels {
+		if math.Abs(ch.freq-freq) < s.cfg.Bandwidth {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (s *skimmer) start(freq, snr float64, now int) {
+	ch := &skimChannel{freq: freq, chunks: make(chan []int32, 16), seen: now, snr: snr}
+	s.channels = append(s.channels, ch)
+	cfg := *s.cfg
+	cfg.Detector, cfg.Freq, cfg.AutoTune, cfg.Experiment = "goertzel", freq, false, ""
+	events.emit("skim_start", map[string]interface{}{"freq": freq})
+
+	var tuned tunedFreq
+	tuned.set(freq)
+
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ma := newMessageAssembler(float64(s.cs.chunk)/float64(s.cs.rate), &tuned)
+		for sym := range getDecodePipe(&cfg, s.cs, ch.chunks, &tuned) {
+			if m := ma.add(sym); m != nil {
+				s.message(freq, m)
+			}
+		}
+		if m := ma.flush(); m != nil {
+			s.message(freq, m)
+		}
+		events.emit("skim_stop", map[string]interface{}{"freq": freq})
+	}()
+}
+
+func (s *skimmer) message(freq float64, m *message) {
+	s.out.Lock()
+	defer s.out.Unlock()
+	s.report(freq, m)
+}
+
+func (s *skimmer) print(freq float64, m *message) {
+	fmt.Printf("%s  %8s  %s\n", human.time(time.Now()), human.freq(freq), m.Text)
+	events.emit("skim_message", map[string]interface{}{
+		"freq":    freq,
+		"text":    m.Text,
+		"seconds": m.Seconds,
+		"wpm":     m.WPM,
+		"calls":   callsigns(m.Text),
+	})
+}
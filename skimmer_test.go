@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestSkimmerRetiresWeakest(t *testing.T) {
+	s := newSkimmer(defaultConfig(), captureSettings{rate: 8000, chunk: 40})
+	for _, b := range []bankBin{{700, 20}, {950, 12}, {1100, 30}} {
+		s.channels = append(s.channels, &skimChannel{freq: b.Freq, snr: b.SNR, chunks: make(chan []int32)})
+	}
+	weak := s.channels[1]
+
+	evs := collectEvents(t, s.retireWeakest)
+	if len(s.channels) != 2 || s.channels[0].freq != 700 || s.channels[1].freq != 1100 {
+		t.Errorf("channels left after retiring: %v, %v", s.channels[0].freq, s.channels[1].freq)
+	}
+	if _, open := <-weak.chunks; open {
+		t.Error("retired channel's audio still open")
+	}
+	if len(evs) != 1 || evs[0]["event"] != "skim_retired" || evs[0]["freq"] != 950.0 {
+		t.Errorf("events %v, want skim_retired at 950 Hz", evs)
+	}
+}
@@ -0,0 +1,144 @@
+// Post-detection smoothing filters for the amplitude stream.
+//
+// Different noise wants different smoothing: a moving average or a
+// one-pole low-pass evens out hiss, while a median filter knocks out
+// static crashes and clicks without rounding off the element edges.
+// Filters are chosen with a comma-separated list, applied in order:
+//
+//   ma:N       moving average of the last N amplitudes
+//   median:N   median of the last N amplitudes
+//   iir:T      one-pole low-pass with time constant T (e.g. 5ms)
+//   lp:F       two-pole Butterworth low-pass cutting off at F Hz
+//
+// so "-smooth median:5,iir:3ms" takes out impulses and then smooths.
+//
+// The one-pole filter rolls off gently, so a time constant long enough
+// to steady a noisy amplitude stream also smears the element edges.
+// The Butterworth filter is flat up to its cutoff and falls twice as
+// steeply after it: a cutoff two or three times the dit rate (about 40
+// Hz at 30 WPM) keeps the keying and drops most of the chunk-to-chunk
+// jitter that otherwise fragments the runs.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type smoothSpec struct {
+	kind string
+	n    int           // for ma and median
+	tau  time.Duration // for iir
+	freq float64       // for lp, in Hz
+}
+
+// Parse a list of smoothing filters, as described above.
+func parseSmoothing(spec string) ([]smoothSpec, error) {
+	var specs []smoothSpec
+	if spec == "" {
+		return nil, nil
+	}
+	for _, f := range strings.Split(spec, ",") {
+		kind, arg, _ := strings.Cut(strings.TrimSpace(f), ":")
+		s := smoothSpec{kind: kind}
+		var err error
+		switch kind {
+		case "ma", "median":
+			s.n, err = strconv.Atoi(arg)
+			if err == nil && s.n < 1 {
+				err = fmt.Errorf("length must be at least 1")
+			}
+		case "iir":
+			s.tau, err = time.ParseDuration(arg)
+			if err == nil && s.tau <= 0 {
+				err = fmt.Errorf("time constant must be positive")
+			}
+		case "lp":
+			s.freq, err = strconv.ParseFloat(arg, 64)
+			if err == nil && s.freq <= 0 {
+				err = fmt.Errorf("cutoff must be positive")
+			}
+		default:
+			err = fmt.Errorf("unknown filter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("smoothing filter %q: %v", f, err)
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// Return the stage for 's', for amplitudes measured every 'step'
+// seconds.
+func (s smoothSpec) stage(step float64) func(chan int32) chan int32 {
+	return func(amplitudes chan int32) chan int32 {
+		out := make(chan int32)
+		go func() {
+			switch s.kind {
+			case "ma":
+				hist := make([]int32, s.n)
+				var sum int64
+				for i := 0; ; i++ {
+					amp, ok := <-amplitudes
+					if !ok {
+						break
+					}
+					sum += int64(amp) - int64(hist[i%s.n])
+					hist[i%s.n] = amp
+					n := int64(s.n)
+					if i < s.n {
+						n = int64(i + 1)
+					}
+					out <- int32(sum / n)
+				}
+			case "median":
+				hist := make([]int32, 0, s.n)
+				sorted := make([]int32, s.n)
+				for i := 0; ; i++ {
+					amp, ok := <-amplitudes
+					if !ok {
+						break
+					}
+					if len(hist) < s.n {
+						hist = append(hist, amp)
+					} else {
+						hist[i%s.n] = amp
+					}
+					m := sorted[:len(hist)]
+					copy(m, hist)
+					sort.Sort(byInt32(m))
+					out <- m[len(m)/2]
+				}
+			case "iir":
+				a := smoothing(s.tau, step)
+				y := math.NaN()
+				for amp := range amplitudes {
+					if math.IsNaN(y) {
+						y = float64(amp)
+					}
+					y += a * (float64(amp) - y)
+					out <- int32(y)
+				}
+			case "lp":
+				f := newLowpassBiquad(s.freq, math.Sqrt2/2, 1/step)
+				first := true
+				for amp := range amplitudes {
+					if first {
+						// Start settled at the first level.
+						f.settle(float64(amp))
+						first = false
+					}
+					out <- int32(math.Max(0, math.Min(math.MaxInt32, f.process(float64(amp)))))
+				}
+			}
+			close(out)
+		}()
+		return out
+	}
+}
@@ -0,0 +1,89 @@
+// Signal-to-noise estimation.
+//
+// When copy is poor it matters whether the signal is weak or the
+// decoder is confused.  The SNR meter watches the amplitudes coming out
+// of the detector over the last couple of seconds: with CW keyed at
+// any normal duty cycle, the loudest of them are key-down, tone plus
+// noise, and the quietest are key-up, noise alone.  The ratio of the
+// two levels, in dB, less what noise alone would give, is the SNR as
+// the decoder experiences it.  It is stamped on every symbol, carried
+// into messages, and printed every so often if asked.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	snrWindow = 2.0 // seconds of amplitudes to judge
+	snrLow    = 0.1 // percentile of the window taken as key-up
+	snrHigh   = 0.9 // and as key-down
+
+	// What the two percentiles make of noise alone, whose
+	// amplitudes out of a narrow filter are Rayleigh distributed:
+	// sqrt(ln(1-snrHigh) / ln(1-snrLow)), in dB.  Taken off every
+	// estimate, so that an empty band reads 0 dB.
+	snrNoiseOnly = 13.4
+)
+
+type snrMeter struct {
+	bits uint64 // math.Float64bits of the latest estimate; accessed atomically
+}
+
+// The latest estimate, in dB.
+func (m *snrMeter) current() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.bits))
+}
+
+// Return a stage which measures the SNR of a stream of amplitudes
+// measured every 'step' seconds, and passes them on unchanged.
+func (m *snrMeter) stage(step float64) func(chan int32) chan int32 {
+	n := int(snrWindow / step)
+	if n < 10 {
+		n = 10
+	}
+	return func(amplitudes chan int32) chan int32 {
+		out := make(chan int32)
+		go func() {
+			window := make([]int32, 0, n)
+			sorted := make([]int32, n)
+			pos := 0
+			for amp := range amplitudes {
+				if len(window) < n {
+					window = append(window, amp)
+				} else {
+					window[pos] = amp
+				}
+				pos = (pos + 1) % n
+				// Re-estimate four times per window.
+				if len(window) == n && pos%(n/4) == 0 {
+					copy(sorted, window)
+					sort.Sort(byInt32(sorted))
+					noise := math.Max(float64(sorted[int(snrLow*float64(n))]), 1)
+					signal := math.Max(float64(sorted[int(snrHigh*float64(n))]), noise)
+					snr := math.Max(0, 20*math.Log10(signal/noise)-snrNoiseOnly)
+					atomic.StoreUint64(&m.bits, math.Float64bits(snr))
+				}
+				out <- amp
+			}
+			close(out)
+		}()
+		return out
+	}
+}
+
+// Pass symbols from 'symbols' through, stamped with the SNR 'm' reads.
+func getSNRPipe(symbols chan symbol, m *snrMeter) chan symbol {
+	out := make(chan symbol)
+	go func() {
+		for s := range symbols {
+			s.snr = m.current()
+			out <- s
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,224 @@
+// Rolling spectrogram of the input audio, served over HTTP as a
+// waterfall.
+//
+// When the decode goes wrong, the first question is whether the
+// decoder is even listening to the right signal.  The waterfall shows
+// every signal in the audio passband scrolling down the page, with a
+// line where the tone detector is tuned, so that question answers
+// itself at a glance.
+//
+// The page at / draws the waterfall; /spectrogram?since=N serves the
+// rows after row N as JSON, for anything else that wants them.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	spectrogramRows    = 10   // rows per second
+	spectrogramHistory = 600  // rows remembered: a minute
+	spectrogramMaxFreq = 3000 // Hz; CW never sits higher in the passband
+)
+
+// Frequency the tone detector is tuned to, for marking the waterfall;
+// zero when the detector hears the whole passband.
+type tunedFreq struct {
+	bits uint64 // math.Float64bits; accessed atomically
+}
+
+// The live decoder's tuning, set whenever its detector moves.
+var tuning tunedFreq
+
+func (t *tunedFreq) set(freq float64) {
+	atomic.StoreUint64(&t.bits, math.Float64bits(freq))
+}
+
+func (t *tunedFreq) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&t.bits))
+}
+
+type spectrogram struct {
+	rate   int
+	size   int       // FFT size
+	hop    int       // samples between rows
+	bins   int       // bins kept, from 0 Hz up
+	window []float64 // Hann window
+	buf    []float64 // samples not yet analyzed
+	fft    []complex128
+
+	mu   sync.Mutex
+	rows [][]int // dB, newest last
+	next int     // number of the row after the newest
+}
+
+type spectrogramReport struct {
+	BinHz float64 `json:"bin_hz"`
+	Tuned float64 `json:"tuned"` // Hz; 0 if not tuned to a tone
+	Next  int     `json:"next"`  // pass as 'since' to get only newer rows
+	Rows  [][]int `json:"rows"`  // dB, oldest first
+}
+
+// Make a spectrogram of audio sampled at 'rate', with bins about 10 Hz
+// wide.
+func newSpectrogram(rate int) *spectrogram {
+	size := nextPow2(rate / 20)
+	bins := int(math.Min(spectrogramMaxFreq, float64(rate)/2) * float64(size) / float64(rate))
+	s := &spectrogram{
+		rate:   rate,
+		size:   size,
+		hop:    rate / spectrogramRows,
+		bins:   bins,
+		window: make([]float64, size),
+		fft:    make([]complex128, size),
+	}
+	for i := range s.window {
+		s.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+	}
+	return s
+}
+
+// Add 'chunk' to the spectrogram, analyzing a new row whenever a hop's
+// worth of samples has arrived.
+func (s *spectrogram) add(chunk []int32) {
+	for _, v := range chunk {
+		s.buf = append(s.buf, float64(v))
+	}
+	for len(s.buf) >= s.size && len(s.buf) >= s.hop {
+		for i := range s.fft {
+			s.fft[i] = complex(s.buf[i]*s.window[i], 0)
+		}
+		fft(s.fft, false)
+		row := make([]int, s.bins)
+		for k := range row {
+			// Relative to a full-scale sine filling the window.
+			m := cmplx.Abs(s.fft[k]) / (math.MaxInt32 * float64(s.size) / 4)
+			row[k] = int(math.Round(20 * math.Log10(math.Max(m, 1e-12))))
+		}
+		s.mu.Lock()
+		if len(s.rows) == spectrogramHistory {
+			s.rows = append(s.rows[:0], s.rows[1:]...)
+		}
+		s.rows = append(s.rows, row)
+		s.next++
+		s.mu.Unlock()
+		s.buf = s.buf[s.hop:]
+	}
+}
+
+// Return the rows after row 'since'.
+func (s *spectrogram) report(since int) spectrogramReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := s.next - len(s.rows) // number of the oldest row kept
+	skip := since - first
+	if skip < 0 {
+		skip = 0
+	}
+	if skip > len(s.rows) {
+		skip = len(s.rows)
+	}
+	return spectrogramReport{
+		BinHz: float64(s.rate) / float64(s.size),
+		Tuned: tuning.get(),
+		Next:  s.next,
+		Rows:  append([][]int{}, s.rows[skip:]...),
+	}
+}
+
+func (s *spectrogram) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, waterfallPage)
+	})
+	mux.HandleFunc("/spectrogram", func(w http.ResponseWriter, r *http.Request) {
+		since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+		writeJSON(w, http.StatusOK, s.report(since))
+	})
+	return mux
+}
+
+// Read audio chunks from 'chunks', add them to 's', and pass them on
+// unchanged.
+func getSpectrogramPipe(chunks chan []int32, s *spectrogram) chan []int32 {
+	out := make(chan []int32)
+	go func() {
+		for chunk := range chunks {
+			s.add(chunk)
+			out <- chunk
+		}
+		close(out)
+	}()
+	return out
+}
+
+const waterfallPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Morse decoder waterfall</title>
+<style>
+body { font-family: sans-serif; background: #000; color: #ccc; }
+canvas { display: block; }
+</style>
+</head>
+<body>
+<canvas id="scale" width="900" height="20"></canvas>
+<canvas id="fall" width="900" height="600"></canvas>
+<script>
+var fall = document.getElementById("fall"), g = fall.getContext("2d");
+var scale = document.getElementById("scale"), sg = scale.getContext("2d");
+var since = 0;
+function colour(v) {
+  // v from 0 (noise) to 1 (40 dB above it)
+  var r = Math.min(255, Math.max(0, 510 * v - 255));
+  var gr = Math.min(255, Math.max(0, 510 * v - 128));
+  var b = Math.min(255, Math.max(0, 400 * v));
+  return [r, gr, b];
+}
+function drawScale(binHz, bins, tuned) {
+  var hz = binHz * bins;
+  sg.clearRect(0, 0, scale.width, scale.height);
+  sg.fillStyle = "#ccc";
+  for (var f = 0; f < hz; f += 500) {
+    sg.fillText(f, f / hz * scale.width, 12);
+  }
+  if (tuned > 0) {
+    sg.fillStyle = "#f33";
+    sg.fillRect(tuned / hz * scale.width - 1, 0, 3, scale.height);
+  }
+}
+function addRow(row) {
+  g.drawImage(fall, 0, 0, fall.width, fall.height - 1, 0, 1, fall.width, fall.height - 1);
+  var sorted = row.slice().sort(function(a, b) { return a - b; });
+  var floor = sorted[Math.floor(sorted.length / 2)];
+  var img = g.createImageData(fall.width, 1);
+  for (var x = 0; x < fall.width; x++) {
+    var c = colour((row[Math.floor(x / fall.width * row.length)] - floor) / 40);
+    img.data.set([c[0], c[1], c[2], 255], 4 * x);
+  }
+  g.putImageData(img, 0, 0);
+}
+function update() {
+  fetch("/spectrogram?since=" + since).then(function(r) { return r.json(); }).then(function(s) {
+    s.rows.forEach(addRow);
+    if (s.rows.length > 0) {
+      drawScale(s.bin_hz, s.rows[0].length, s.tuned);
+    }
+    since = s.next;
+  });
+}
+update();
+setInterval(update, 200);
+</script>
+</body>
+</html>
+`
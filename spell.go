@@ -0,0 +1,126 @@
+// Spelling suggestions.
+//
+// -correct only touches letters the tokenizer had doubts about, and
+// only by changing their dits and dahs.  A confident mistake, such as
+// an E sent as a T, or a letter lost to a fade, gets through it.  The
+// spelling pass catches those afterwards in the text: a word that isn't
+// in the dictionary, but is one character away from exactly one word
+// that is, is printed as heard with that word after it in braces, as
+// in "PSR{PSE}".  The copy as heard is never changed, so a callsign or
+// an unusual word that happens to look like a misspelling loses
+// nothing.  Each suggestion is also logged as a "spelling" event.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Fewest letters a word must have to be checked: shorter ones are too
+// near too many others.
+const spellMinLetters = 3
+
+// Dictionary words to check spelling against, set by main if -spell
+// is given.
+var spellWords *dictionary
+
+// Suggest spellings for the words of decoded text.
+type spellChecker struct {
+	dict *dictionary
+	word strings.Builder // the word in progress
+}
+
+func newSpellChecker(dict *dictionary) *spellChecker {
+	return &spellChecker{dict: dict}
+}
+
+// Take the next piece of decoded text, and return it with a suggestion
+// after each word it completes that needs one.  A nil checker returns
+// the text as it is.
+func (c *spellChecker) add(text string) string {
+	if c == nil {
+		return text
+	}
+	var out strings.Builder
+	for _, r := range text {
+		if r != ' ' && r != '\n' {
+			out.WriteRune(r)
+			c.word.WriteRune(r)
+			continue
+		}
+		out.WriteString(c.finish())
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// Finish the word in progress, at the end of the stream.
+func (c *spellChecker) flush() string {
+	if c == nil {
+		return ""
+	}
+	return c.finish()
+}
+
+// Return the suggestion for the word in progress, if any, and start
+// the next.
+func (c *spellChecker) finish() string {
+	word := c.word.String()
+	c.word.Reset()
+	fix := c.dict.suggest(word)
+	if fix == "" {
+		return ""
+	}
+	events.emit("spelling", map[string]interface{}{"heard": word, "suggested": fix})
+	return "{" + fix + "}"
+}
+
+// Return the one dictionary word a character away from 'word', or ""
+// if the word is known, too short, has anything but letters and
+// figures in it, or has no such word or several.
+func (d *dictionary) suggest(word string) string {
+	n := len([]rune(word))
+	if n < spellMinLetters || d.known[word] || isCallsign(word) {
+		return ""
+	}
+	for _, r := range word {
+		if !unicode.IsUpper(r) && !unicode.IsDigit(r) {
+			return ""
+		}
+	}
+	found := ""
+	for m := n - 1; m <= n+1; m++ {
+		for _, w := range d.byCount[m] {
+			if !oneEdit([]rune(word), w) {
+				continue
+			}
+			if found != "" {
+				return ""
+			}
+			found = string(w)
+		}
+	}
+	return found
+}
+
+// Return whether 'b' is 'a' with one character changed, added or
+// taken away.
+func oneEdit(a, b []rune) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	if len(a) == len(b) {
+		// Changed: the rest must match.
+		return i < len(a) && string(a[i+1:]) == string(b[i+1:])
+	}
+	// Added: the rest of the shorter must match the longer past it.
+	return string(a[i:]) == string(b[i+1:])
+}
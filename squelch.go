@@ -0,0 +1,46 @@
+// Squelch: say nothing when there's nothing to hear.
+//
+// Between transmissions the quantizer still finds marks in the band
+// noise, and the tokenizer turns them into streams of errors and
+// nonsense letters.  The squelch drops every symbol decoded while the
+// SNR is under a threshold, and so stays quiet until a plausible
+// signal turns up.  It stays open for a little while after the SNR
+// falls, so that a fade in the middle of a word doesn't chop it.
+
+package main
+
+import "time"
+
+const squelchHang = 2 * time.Second
+
+// Pass on the symbols from 'symbols' decoded at an SNR of at least
+// 'threshold' dB, or within squelchHang of one that was.  Durations are
+// counted in amplitudes 'step' seconds apart.
+func getSquelchPipe(symbols chan symbol, threshold, step float64) chan symbol {
+	out := make(chan symbol)
+	hang := int64(squelchHang.Seconds() / step)
+	go func() {
+		open := false
+		since := hang + 1 // amplitudes since the SNR was last above threshold
+		for s := range symbols {
+			if s.snr >= threshold {
+				since = 0
+			} else {
+				since += int64(s.duration)
+			}
+			if now := since <= hang; now != open {
+				open = now
+				kind := "squelch_closed"
+				if open {
+					kind = "squelch_open"
+				}
+				events.emit(kind, map[string]interface{}{"snr": s.snr})
+			}
+			if open {
+				out <- s
+			}
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,59 @@
+// Timing strip: a scrolling picture of the marks and spaces the
+// decoder hears, drawn to scale against its current unit estimate,
+// for diagnosing why a decode is failing.
+//
+// Each unit takes two columns.  Marks are drawn solid, or shaded if
+// they couldn't be classified; spaces are blank, with a tick after
+// each word gap.  The strip redraws a single terminal line, so it is
+// best watched with the decoded text sent elsewhere:
+//
+//   cw-decode -strip > copy.txt
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	stripCellsPerUnit = 2
+	stripMaxUnits     = 10 // longer spaces are cut short
+)
+
+type timingStrip struct {
+	w     io.Writer
+	width int
+	cells []rune
+}
+
+func newTimingStrip(w io.Writer, width int) *timingStrip {
+	return &timingStrip{w: w, width: width}
+}
+
+func (s *timingStrip) add(sym symbol) {
+	if sym.unit <= 0 {
+		return
+	}
+	units := math.Min(float64(sym.duration)/float64(sym.unit), stripMaxUnits)
+	n := int(units*stripCellsPerUnit + 0.5)
+	cell := ' '
+	switch sym.tok {
+	case dit, dah:
+		cell = '█'
+	case cwError:
+		cell = '▒'
+	}
+	for i := 0; i < n; i++ {
+		s.cells = append(s.cells, cell)
+	}
+	if sym.tok == endWord || sym.tok == pause {
+		s.cells = append(s.cells, '┊')
+	}
+	if len(s.cells) > s.width {
+		s.cells = append(s.cells[:0], s.cells[len(s.cells)-s.width:]...)
+	}
+	fmt.Fprintf(s.w, "\r%s\x1b[K", strings.TrimRight(string(s.cells), " "))
+}
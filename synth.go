@@ -0,0 +1,134 @@
+// A synthetic CW signal source, for self-testing and demos without any
+// audio hardware.
+//
+// The text is keyed at the given speed as a tone of the given pitch,
+// with Gaussian noise mixed in, and fed to the pipeline in real time
+// just as captured audio would be.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+type synthSettings struct {
+	text  string
+	wpm   float64
+	freq  float64 // tone pitch in Hz
+	noise float64 // noise standard deviation, relative to full scale
+}
+
+// Check that every character of 'text' can be sent.
+func checkSynthText(text string) error {
+	for _, r := range strings.ToUpper(text) {
+		if _, ok := morseTable[r]; !ok && r != ' ' {
+			return fmt.Errorf("can't send %q in Morse", r)
+		}
+	}
+	return nil
+}
+
+// Key 'text' at 'wpm' into a keying envelope at 'rate' samples per
+// second: 1 while the key is down and 0 while it's up, with 5ms raised
+// cosine edges to avoid clicks.  Characters without a Morse code are
+// skipped.  The envelope starts with a word gap, and ends with one
+// after the last word.
+func keyEnvelope(text string, wpm float64, rate int) []float64 {
+	unit := int(1.2 / wpm * float64(rate)) // PARIS timing
+	ramp := rate / 200
+
+	var env []float64
+	emit := func(n int, on bool) {
+		for i := 0; i < n; i++ {
+			v := 0.0
+			if on {
+				v = 1
+				if i < ramp {
+					v = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(ramp))
+				} else if n-i < ramp {
+					v = 0.5 - 0.5*math.Cos(math.Pi*float64(n-i)/float64(ramp))
+				}
+			}
+			env = append(env, v)
+		}
+	}
+
+	emit(7*unit, false)
+	for _, word := range strings.Fields(strings.ToUpper(text)) {
+		for _, r := range word {
+			code, ok := morseTable[r]
+			if !ok {
+				continue
+			}
+			for _, e := range code {
+				if e == '.' {
+					emit(unit, true)
+				} else {
+					emit(3*unit, true)
+				}
+				emit(unit, false)
+			}
+			emit(2*unit, false) // to make a 3-unit gap between letters
+		}
+		emit(4*unit, false) // to make a 7-unit gap between words
+	}
+	return env
+}
+
+// Key 'ss.text' into audio samples at 'rate' samples per second.
+func synthesize(ss synthSettings, rate int) []int32 {
+	env := keyEnvelope(ss.text, ss.wpm, rate)
+	// Trailing silence, so the pipeline's analysis windows see the
+	// end of the text.
+	env = append(env, make([]float64, rate*3)...)
+	samples := make([]int32, len(env))
+	for t, e := range env {
+		v := 0.5 * e * math.Sin(2*math.Pi*ss.freq*float64(t)/float64(rate))
+		v += rand.NormFloat64() * ss.noise
+		v = math.Max(-1, math.Min(1, v))
+		samples[t] = int32(v * math.MaxInt32)
+	}
+	return samples
+}
+
+// Return a capture function which plays synthesized Morse into the
+// pipeline in real time, stopping at the end of the text or when
+// something arrives on 'stop'.  If 'rec' is non-nil, the samples are
+// also saved to it.
+func synthCapture(ss synthSettings) func(captureSettings, chan []int32, chan os.Signal, *wavWriter) error {
+	return func(cs captureSettings, chunks chan []int32, stop chan os.Signal, rec *wavWriter) error {
+		defer close(chunks)
+		return play(synthesize(ss, cs.rate), cs, chunks, stop, rec)
+	}
+}
+
+// Feed 'samples' to 'chunks' at the pace a sound card would, stopping
+// at the end or when something arrives on 'stop'.  If 'rec' is
+// non-nil, the samples are also saved to it.
+func play(samples []int32, cs captureSettings, chunks chan []int32, stop chan os.Signal, rec *wavWriter) error {
+	if rec != nil {
+		if err := rec.write(samples); err != nil {
+			return err
+		}
+	}
+	// Deliver a buffer's worth of chunks at a time.
+	tick := time.NewTicker(time.Duration(cs.buffer) * time.Second / time.Duration(cs.rate))
+	defer tick.Stop()
+	for len(samples) >= cs.chunk {
+		select {
+		case <-stop:
+			return nil
+		case <-tick.C:
+		}
+		for n := 0; n < cs.buffer && len(samples) >= cs.chunk; n += cs.chunk {
+			chunks <- samples[:cs.chunk]
+			samples = samples[cs.chunk:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+// Stage 4: dits and dahs to text.
+//
+// Stage 3 hands out dits, dahs and the gaps between them.  This stage
+// strings the dits and dahs of each letter together until the gap
+// that ends it, and looks the pattern up in the Morse table.  A letter
+// gap then stands for nothing more, a word gap for a space.  Prosigns
+// come out in angle brackets, so "<SK>" can't be mistaken for the
+// letters S and K.  A pause ends the line: whoever sends next may be
+// another station, or the same one starting over, and the copy of each
+// transmission reads best on a line of its own.
+//
+// The DO prosign switches to the Japanese Wabun table, and SN back;
+// both are printed, so the reader can see where the kana start.
+//
+// A pattern that isn't in the table comes out as it was received, in
+// square brackets: "[..--.-]".  A mark too long to be a dah shows in
+// it as "*".  There's no telling which letter was meant, but the
+// pattern often shows what went wrong: two letters run together, or a
+// dit lost to a fade.
+
+package main
+
+import (
+	"math"
+	"unicode"
+)
+
+// Return the printed form of an undecodable 'pattern'.
+func undecodable(pattern string) string {
+	return "[" + pattern + "]"
+}
+
+// Unicode's right-to-left isolate, and the pop that ends it.
+const (
+	rtlIsolate = "\u2067"
+	popIsolate = "\u2069"
+)
+
+// The Morse table turned around: each pattern's character, or its
+// prosign written in angle brackets, e.g. "<AR>".  Set by main for the
+// charset wanted.
+var morseLetters = decodeTable("latin", false)
+
+// What each pattern decodes to in Wabun.  Prosigns keep their meaning
+// unless a kana has the same pattern.
+var wabunLetters = func() map[string]string {
+	m := make(map[string]string, len(morseTable)+len(morseWabun))
+	for r, code := range morseTable {
+		if !unicode.IsLetter(r) {
+			m[code] = string(r)
+		}
+	}
+	for r, code := range morseWabun {
+		m[code] = string(r)
+	}
+	for name, code := range prosigns {
+		if _, ok := m[code]; !ok {
+			m[code] = "<" + name + ">"
+		}
+	}
+	return m
+}()
+
+// Whether decoding starts out in Wabun.  Set by main.
+var wabunFirst bool
+
+// Return the table of what each pattern decodes to, with the letters
+// of 'charset' (see charsets), and the extended characters if
+// 'extended'.
+func decodeTable(charset string, extended bool) map[string]string {
+	if charset == "american" {
+		m := make(map[string]string, len(morseAmerican))
+		for r, code := range morseAmerican {
+			m[code] = string(r)
+		}
+		return m
+	}
+	letters := charsets[charset]
+	m := make(map[string]string, len(morseTable)+len(prosigns)+len(morseExtended))
+	for r, code := range morseTable {
+		if letters != nil && unicode.IsLetter(r) {
+			continue
+		}
+		m[code] = string(r)
+	}
+	for r, code := range letters {
+		m[code] = string(r)
+	}
+	if extended {
+		for code, text := range morseExtended {
+			m[code] = text
+		}
+	}
+	for name, code := range prosigns {
+		m[code] = "<" + name + ">"
+	}
+	return m
+}
+
+type textDecoder struct {
+	letter    string  // the letter in progress, as in morseTable; "*" for a bad mark
+	least     float64 // the least confidence in the letter in progress
+	wabun     bool    // whether DO has switched to Wabun
+	rtl       bool    // whether to isolate each word as right-to-left
+	open      bool    // whether a word's isolate is open
+	word      bool    // whether anything has been printed since the last space
+	uncertain float64 // confidence below which letters are flagged "?"
+	dict      *dictionary
+	doubt     float64             // confidence below which correction may change a letter
+	words     *dictionary         // to re-segment words by, if set
+	held      []decodedLetter     // letters decoded but not yet returned
+	scored    func(decodedLetter) // told of each letter returned, if set
+}
+
+// Make a decoder which flags letters decoded with less than
+// 'uncertain' confidence (0: none).
+func newTextDecoder(uncertain float64) *textDecoder {
+	return &textDecoder{uncertain: uncertain, least: 1, wabun: wabunFirst}
+}
+
+// Hold each word until its end, and correct letters decoded with less
+// than 'threshold' confidence, or undecodable, against 'dict'.
+func (d *textDecoder) correct(dict *dictionary, threshold float64) {
+	d.dict, d.doubt = dict, threshold
+}
+
+// Hold the letters either side of each doubtful word or letter gap,
+// and split them into the likeliest words of 'dict' (see segment.go).
+func (d *textDecoder) segment(dict *dictionary) {
+	d.words = dict
+}
+
+// Wrap each word in a right-to-left isolate, so that a terminal or
+// browser that knows about bidirectional text shows Hebrew or Arabic
+// words the right way round, with their digits still left to right.
+func (d *textDecoder) isolateRTL() {
+	d.rtl = true
+}
+
+// Take the next symbol, and return the text it completes, if any.
+func (d *textDecoder) add(s symbol) string {
+	if americanMorse {
+		var elem string
+		elem, s.tok = americanElement(s)
+		d.letter += elem
+	} else {
+		switch s.tok {
+		case dit:
+			d.letter += "."
+		case dah:
+			d.letter += "-"
+		case cwError:
+			d.letter += "*"
+		}
+	}
+	// A letter is as sure as the least sure of its elements and
+	// gaps, counting the gap that ends it: a doubtful letter gap
+	// may have been two letters run together, or one split.
+	d.least = math.Min(d.least, s.confidence)
+	switch s.tok {
+	case dit, dah, cwError, noOp:
+		return ""
+	}
+	held := d.hold()
+	end := s.tok == endWord || s.tok == pause
+	if d.words != nil && held && s.tok != pause && s.confidence < segmentDoubt && len(d.held) < segmentHold {
+		gap := s.confidence
+		if !end {
+			gap = 1 - gap
+		}
+		d.held[len(d.held)-1].wordGap = gap
+		return ""
+	}
+	if (d.dict != nil || d.words != nil) && !end {
+		return ""
+	}
+	text := d.release()
+	if end && d.open {
+		text += popIsolate
+		d.open = false
+	}
+	if end && d.word {
+		if s.tok == pause {
+			text += "\n"
+		} else {
+			text += " "
+		}
+		d.word = false
+	}
+	if s.tok == pause {
+		// Start the next transmission in the charset it would start
+		// in from cold.
+		d.wabun = wabunFirst
+	}
+	return text
+}
+
+// Return the letter in progress, if any, and start the next.
+func (d *textDecoder) take() (decodedLetter, bool) {
+	if d.letter == "" {
+		return decodedLetter{}, false
+	}
+	table := morseLetters
+	if d.wabun {
+		table = wabunLetters
+	}
+	text, ok := table[d.letter]
+	switch {
+	case americanMorse:
+		// American Morse has no Wabun.
+	case d.letter == wabunDO && !d.wabun:
+		text, ok, d.wabun = "<DO>", true, true
+	case d.letter == wabunSN && d.wabun:
+		text, ok, d.wabun = "<SN>", true, false
+	}
+	if !ok {
+		text = undecodable(d.letter)
+	}
+	l := decodedLetter{text: text, pattern: d.letter, doubt: !ok}
+	d.letter, d.word = "", true
+	return l, true
+}
+
+// Hold the letter in progress, if any, with its confidence, and
+// return whether there was one.
+func (d *textDecoder) hold() bool {
+	l, ok := d.take()
+	if ok {
+		l.confidence = d.least
+		l.doubt = l.doubt || l.confidence < d.doubt
+		if l.confidence < d.uncertain {
+			l.flag = "?"
+		}
+		d.held = append(d.held, l)
+	}
+	d.least = 1
+	return ok
+}
+
+// Return the held letters, segmented and corrected if need be.
+func (d *textDecoder) release() string {
+	words := [][]decodedLetter{d.held}
+	if d.words != nil {
+		words = d.words.segment(d.held)
+	}
+	text := ""
+	for i, w := range words {
+		if i > 0 {
+			if d.open {
+				text += popIsolate
+				d.open = false
+			}
+			text += " "
+		}
+		if d.dict != nil {
+			d.dict.correct(w)
+		}
+		word := ""
+		for _, l := range w {
+			word += l.text + l.flag
+			if d.scored != nil {
+				d.scored(l)
+			}
+		}
+		if d.rtl && word != "" && !d.open {
+			word = rtlIsolate + word
+			d.open = true
+		}
+		text += word
+	}
+	d.held = d.held[:0]
+	return text
+}
+
+// Return whatever is still held or in progress at the end of the
+// stream.
+func (d *textDecoder) flush() string {
+	d.hold()
+	text := d.release()
+	if d.open {
+		text += popIsolate
+		d.open = false
+	}
+	return text
+}
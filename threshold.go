@@ -0,0 +1,126 @@
+// Adaptive threshold quantizer.
+//
+// The window quantizer splits each amplitude at the midpoint of the
+// range of the last 100.  When the window holds nothing but noise, max
+// and min are nearly equal and the midpoint lands in the middle of the
+// noise, which then quantizes as a wild string of on/off flips.
+//
+// This quantizer instead tracks two levels continuously: the signal
+// peak, which jumps up quickly and sags slowly, and the noise floor,
+// which drops quickly and creeps up slowly.  It switches on when the
+// amplitude climbs well above the midpoint between them, and off only
+// when it falls well below it, so an amplitude dithering around the
+// middle doesn't chatter.  Until the peak stands clear of the floor,
+// there is no signal, and everything quantizes as off.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	hysteresisOn  = 0.6 // switch on above this fraction of the way from floor to peak
+	hysteresisOff = 0.4 // switch off below this fraction
+
+	// The peak must be this many times the floor to count as a
+	// signal at all: 6 dB.
+	minPeakToFloor = 2
+
+	levelFast = 20 * time.Millisecond // time constant for peak rise, floor fall
+	levelSlow = 3 * time.Second       // time constant for peak decay, floor rise
+)
+
+// Return an adaptive quantizer for amplitudes measured every 'step'
+// seconds, starting from the levels in 'cal' if not nil.
+func adaptiveQuantizer(step float64, cal *calibration) func(chan int32, chan bool) {
+	fast := smoothing(levelFast, step)
+	slow := smoothing(levelSlow, step)
+	return func(amplitudes chan int32, quants chan bool) {
+		var peak, floor float64
+		first := true
+		if cal != nil {
+			peak, floor = cal.peak, cal.floor
+			first = false
+		}
+		on := false
+		for amp := range amplitudes {
+			a := float64(amp)
+			if first {
+				peak, floor = a, a
+				first = false
+			}
+			if a > peak {
+				peak += fast * (a - peak)
+			} else {
+				peak += slow * (a - peak)
+			}
+			if a < floor {
+				floor += fast * (a - floor)
+			} else {
+				floor += slow * (a - floor)
+			}
+
+			if peak < minPeakToFloor*floor {
+				on = false
+			} else if on {
+				on = a > floor+hysteresisOff*(peak-floor)
+			} else {
+				on = a > floor+hysteresisOn*(peak-floor)
+			}
+			quants <- on
+		}
+		close(quants)
+	}
+}
+
+// Return a quantizer which tracks the noise floor alone, and keys on
+// whenever an amplitude stands 'margin' dB above it.  The floor is a
+// long average, with time constant 'tau', of the amplitudes that are
+// clearly key-up, within half the margin of it, so marks don't move
+// it, and it keeps a sensible threshold through any length of
+// silence, where a window's min/max has nothing to go on.
+//
+// Until 'tau' has passed there's no long average to go on, so the
+// floor starts out as the median of everything heard, which with any
+// normal duty cycle is a key-up amplitude; or, given a calibration
+// 'cal', as the calibrated floor.
+func floorQuantizer(step, margin float64, tau time.Duration, cal *calibration) func(chan int32, chan bool) {
+	slow := smoothing(tau, step)
+	above := math.Pow(10, margin/20)
+	keyUp := math.Sqrt(above)
+	warmup := int(tau.Seconds() / step)
+	if cal != nil {
+		warmup = 0
+	}
+	return func(amplitudes chan int32, quants chan bool) {
+		var floor float64
+		if cal != nil {
+			floor = cal.floor
+		}
+		var heard []int32
+		for amp := range amplitudes {
+			a := float64(amp)
+			on := a > floor*above
+			switch {
+			case len(heard) < warmup:
+				heard = append(heard, amp)
+				if len(heard)%32 == 1 || len(heard) == warmup {
+					s := append([]int32(nil), heard...)
+					sort.Sort(byInt32(s))
+					floor = float64(s[len(s)/2])
+				}
+			case a < floor*keyUp:
+				floor += slow * (a - floor)
+			case on:
+				// A floor that has somehow ended up far too
+				// low must still be able to climb out.
+				floor += slow / 10 * (a - floor)
+			}
+			quants <- on
+		}
+		close(quants)
+	}
+}
@@ -0,0 +1,84 @@
+// Keying timing models.
+//
+// How far a sender's timing can be trusted depends on what they send
+// with.  An electronic keyer times every dit and dah itself, to the
+// millisecond, and leaves only the spaces between letters and words to
+// the operator's hand.  On a straight key the operator times it all,
+// and the dahs worst of all: a heavy fist stretches them to four or
+// five units, where the dits and the gaps within a letter stay near
+// one.  A bug, the semi-automatic key, is half of each: its spring
+// makes the dits, at machine speed, and the operator's thumb the dahs,
+// which are as long as they like, often five units or more.
+//
+// Told which with -key, the tokenizers take their first estimate of
+// the unit from what can be trusted: for a paddle, only the marks.  So
+// does the Bayesian decoder's weighing of speeds.  The clamp
+// tokenizer's running average, for a paddle, leaves out the gaps, and
+// for a straight key the dahs, keeping the dits and the gaps within
+// letters.  For a bug, neither counts the dahs, and both learn the
+// dah's length for itself rather than taking it as three units; the
+// cluster, hand and Viterbi tokenizers always do.  Without -key
+// everything counts, as it always has.
+
+package main
+
+import "sort"
+
+// What the unit may be estimated from.
+type keyTiming struct {
+	dahs bool // dahs, taken as three units
+	gaps bool // spaces; the gaps within letters taken as one unit
+
+	ownDahs bool // whether dahs are as long as the operator makes them
+}
+
+// Timing models, by name.
+var keyTimings = map[string]keyTiming{
+	"paddle":   {dahs: true},
+	"straight": {gaps: true},
+	"bug":      {gaps: true, ownDahs: true},
+}
+
+// The timing model in use.  Set by main.
+var keying = keyTiming{dahs: true, gaps: true}
+
+// Fewest marks a window must have for its unit to be estimated from
+// the marks alone.
+const keyingMinMarks = 4
+
+// Return the unit a window of 'durations' shows, by the 25th
+// percentile of calculateUnitDuration, leaving out the spaces if the
+// timing model doesn't trust them.  'marks' says which durations are
+// marks.
+func estimateUnit(durations []int32, marks []bool) int32 {
+	if !keying.gaps {
+		var trusted []int32
+		for i, d := range durations {
+			if marks[i] {
+				trusted = append(trusted, d)
+			}
+		}
+		if len(trusted) >= keyingMinMarks {
+			// Most marks are dits, so the 25th percentile of the
+			// marks alone is still a dit.
+			return calculateUnitDuration(trusted)
+		}
+	}
+	return calculateUnitDuration(append([]int32(nil), durations...))
+}
+
+// Return the length of a bug's dah, from the marks among 'durations'
+// longer than twice 'unit', or three units if there are none.
+func bugDah(durations []int32, marks []bool, unit float64) float64 {
+	var dahs []int32
+	for i, d := range durations {
+		if marks[i] && float64(d) > 2*unit {
+			dahs = append(dahs, d)
+		}
+	}
+	if len(dahs) == 0 {
+		return 3 * unit
+	}
+	sort.Sort(byInt32(dahs))
+	return float64(dahs[len(dahs)/2])
+}
@@ -0,0 +1,122 @@
+// Automatic tone frequency acquisition.
+//
+// Rather than making the operator tell us the pitch of the signal, look
+// at the spectrum of the last fraction of a second every now and then,
+// find the strongest tone within the configured range, and move the
+// Goertzel detector onto it.  Because the scan keeps running, the
+// detector follows the signal if it drifts or the operator retunes.
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// How far the strongest bin must stand above the median of the range
+// before we believe it's a signal and not just noise: 10 dB.
+const acquirePeakRatio = 10
+
+type autoTuner struct {
+	g        *goertzel
+	tuned    *tunedFreq
+	min, max float64   // range to search, in Hz
+	buf      []float64 // samples since the last scan
+	size     int       // FFT size
+	hop      int       // samples between scans
+	window   []float64 // Hann window
+	spectrum []complex128
+}
+
+// Wrap 'g' so that it tunes itself to the strongest tone between
+// 'min' and 'max' Hz, keeping 'tuned' up to date.
+func newAutoTuner(g *goertzel, min, max float64, tuned *tunedFreq) *autoTuner {
+	size := nextPow2(g.rate / 5)
+	a := &autoTuner{
+		g:        g,
+		tuned:    tuned,
+		min:      min,
+		max:      max,
+		size:     size,
+		hop:      g.rate / 2,
+		window:   make([]float64, size),
+		spectrum: make([]complex128, size),
+	}
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+	}
+	return a
+}
+
+func (a *autoTuner) amplitude(chunk []int32) int32 {
+	for _, v := range chunk {
+		a.buf = append(a.buf, float64(v))
+	}
+	if len(a.buf) >= a.hop && len(a.buf) >= a.size {
+		if load.level() < 1 {
+			if freq, ok := a.scan(a.buf[len(a.buf)-a.size:]); ok {
+				a.retune(freq)
+			}
+		}
+		a.buf = a.buf[:0]
+	}
+	return a.g.amplitude(chunk)
+}
+
+// Find the strongest tone in range in 'samples', if there is one.
+func (a *autoTuner) scan(samples []float64) (float64, bool) {
+	for i, v := range samples {
+		a.spectrum[i] = complex(v*a.window[i], 0)
+	}
+	fft(a.spectrum, false)
+
+	binHz := float64(a.g.rate) / float64(a.size)
+	lo := int(math.Ceil(a.min / binHz))
+	hi := int(a.max / binHz)
+	if lo < 1 {
+		lo = 1
+	}
+	if hi >= a.size/2 {
+		hi = a.size/2 - 1
+	}
+	if hi <= lo {
+		return 0, false
+	}
+	mags := make([]float64, 0, hi-lo+1)
+	peak, peakMag := lo, 0.0
+	for k := lo; k <= hi; k++ {
+		m := cmplx.Abs(a.spectrum[k])
+		mags = append(mags, m)
+		if m > peakMag {
+			peak, peakMag = k, m
+		}
+	}
+	sort.Float64s(mags)
+	if peakMag < acquirePeakRatio*mags[len(mags)/2] {
+		return 0, false
+	}
+
+	// Interpolate between bins for a better estimate.
+	l := cmplx.Abs(a.spectrum[peak-1])
+	r := cmplx.Abs(a.spectrum[peak+1])
+	offset := 0.0
+	if d := l - 2*peakMag + r; d != 0 {
+		offset = 0.5 * (l - r) / d
+	}
+	return (float64(peak) + offset) * binHz, true
+}
+
+// Move the detector to 'freq' if it's far enough from where it is now
+// to matter.
+func (a *autoTuner) retune(freq float64) {
+	binWidth := float64(a.g.rate) / float64(len(a.g.window))
+	if math.Abs(freq-a.g.freq) < binWidth/4 {
+		return
+	}
+	a.g.tune(freq)
+	a.tuned.set(freq)
+	events.emit("tuned", map[string]interface{}{
+		"freq": freq,
+	})
+}
@@ -0,0 +1,484 @@
+// Viterbi tokenizer.
+//
+// The clamp and cluster tokenizers judge each duration on its own, so
+// a dah cut short by a fade becomes a dit however little sense the
+// letter then makes.  A human copyist doesn't work that way: a
+// doubtful element is read as whatever makes a letter.
+//
+// This tokenizer does the same with a hidden Markov model.  The
+// hidden state is how much of a letter has been sent so far, a node
+// in the trie of the Morse table's patterns.  A mark moves it down to
+// the node's dit or dah child, if there is one; a gap between elements
+// leaves it where it is; and a letter or word gap, allowed only at a
+// node that completes a letter, takes it back to the root.  Each
+// class of duration is log-normally distributed about its centre, as
+// in confidence.go, and the Viterbi algorithm finds the likeliest path
+// of classes through the durations.  A pattern not in the table is
+// allowed, at a price, through a state that stands for a bad letter,
+// so that garble still comes out as garble.
+//
+// The likeliest path so far can change its mind about the recent
+// past, so durations are held until every path still in the running
+// agrees on them, or viterbiLag of them have piled up.  The class
+// centres start out as in the cluster tokenizer, and learn from the
+// durations as they are decided.
+
+package main
+
+import "math"
+
+const (
+	// Most durations held undecided before the likeliest path is
+	// taken as read.
+	viterbiLag = 40
+
+	// Paths this far below the likeliest, in log probability, are
+	// dropped.
+	viterbiBeam = 20.0
+
+	// Log probability of a mark making the letter one not in the
+	// table.
+	viterbiBadLetter = -8.0
+
+	// Spread of each class, as for classSpread, and of the classes
+	// beyond the longest dah and word gap.
+	viterbiSpread        = 0.3
+	viterbiOutlierSpread = 0.6
+)
+
+// Log probabilities of each kind of space after a mark: between
+// elements, letters and words, or a pause.
+var viterbiSpaces = [4]float64{math.Log(0.55), math.Log(0.3), math.Log(0.12), math.Log(0.03)}
+
+// The trie of Morse patterns: node 0 is the root.
+type morseTrie struct {
+	next     [][2]int // each node's dit and dah children; -1 for none
+	complete []bool   // whether each node's pattern is a letter
+}
+
+// Build the trie of the patterns in 'table'.
+func newMorseTrie(table map[string]string) *morseTrie {
+	t := &morseTrie{next: [][2]int{{-1, -1}}, complete: []bool{false}}
+	for code := range table {
+		n := 0
+		for _, e := range code {
+			k := 0
+			if e == '-' {
+				k = 1
+			}
+			if t.next[n][k] < 0 {
+				t.next[n][k] = len(t.next)
+				t.next = append(t.next, [2]int{-1, -1})
+				t.complete = append(t.complete, false)
+			}
+			n = t.next[n][k]
+		}
+		t.complete[n] = true
+	}
+	return t
+}
+
+// One step of a path: the class a duration was put in.
+type viterbiStep struct {
+	tok        token
+	duration   int32
+	unit       int32
+	confidence float64
+	depth      int // durations since the start, counting this one
+	prev       *viterbiStep
+}
+
+type viterbiTokenizer struct {
+	bounds *unitBounds
+	window int           // durations to estimate the starting unit from
+	seeded int32         // calibrated unit to start from, if any
+	est    *unitEstimate // told of the dit centre, if set
+	trie   *morseTrie
+	marks  [2]float64 // dit and dah centres, in amplitudes; 0 until started
+	spaces [3]float64 // element, letter and word gap centres
+	recent []int32    // the last 'window' durations
+	strays int        // durations in a row the window's estimate has been far from the dit centre
+	held   []int32    // durations awaiting the start
+	kinds  []bool     // whether each of 'held' is a mark
+
+	score []float64      // each state's log probability; the trie's nodes, then the bad letter
+	path  []*viterbiStep // the likeliest path to each state
+	last  *viterbiStep   // the last step handed out
+	depth int            // durations taken
+}
+
+// Make a Viterbi tokenizer which starts from a unit estimated from the
+// first 'window' durations.
+func newViterbiTokenizer(bounds *unitBounds, window int) *viterbiTokenizer {
+	table := make(map[string]string, len(morseLetters)+1)
+	for code, text := range morseLetters {
+		table[code] = text
+	}
+	if wabunFirst {
+		// Only here: elsewhere a DO would rarely be meant, and
+		// would switch the rest of the copy to kana.
+		for code, text := range wabunLetters {
+			table[code] = text
+		}
+		table[wabunDO], table[wabunSN] = "<DO>", "<SN>"
+	}
+	v := &viterbiTokenizer{bounds: bounds, window: window, trie: newMorseTrie(table)}
+	states := len(v.trie.next) + 1
+	v.score = make([]float64, states)
+	v.path = make([]*viterbiStep, states)
+	for i := range v.score {
+		v.score[i] = math.Inf(-1)
+	}
+	v.score[0] = 0
+	return v
+}
+
+// The state standing for a letter not in the table.
+func (v *viterbiTokenizer) bad() int {
+	return len(v.score) - 1
+}
+
+func (v *viterbiTokenizer) tokenize(duration int32, mark bool) []symbol {
+	v.recent = append(v.recent, duration)
+	if len(v.recent) > v.window {
+		v.recent = v.recent[len(v.recent)-v.window:]
+	}
+	if v.marks[0] == 0 {
+		v.held = append(v.held, duration)
+		v.kinds = append(v.kinds, mark)
+		if v.seeded == 0 && len(v.held) < v.window {
+			return nil
+		}
+		return v.start()
+	}
+	if len(v.recent) == v.window {
+		// Learning from decided durations can't follow a sudden
+		// change of speed any better than the clamp tokenizer's
+		// average can, so start again from the window's estimate
+		// in the same way.  But a run of figures, nearly all
+		// dahs, can fool the window for a while, so only if it
+		// stays fooled.
+		windowed := float64(v.bounds.limit(calculateUnitDuration(append([]int32(nil), v.recent...))))
+		ratio := windowed / v.marks[0]
+		v.strays++
+		if ratio < clampRelock && ratio > 1/clampRelock {
+			v.strays = 0
+		}
+		if v.strays > v.window/2 {
+			v.strays = 0
+			for i := range v.marks {
+				v.marks[i] *= ratio
+			}
+			for i := range v.spaces {
+				v.spaces[i] *= ratio
+			}
+		}
+	}
+	return v.step(duration, mark)
+}
+
+// Set the centres from the held durations' unit, or the calibrated
+// one, and take the held durations.
+func (v *viterbiTokenizer) start() []symbol {
+	unit := v.seeded
+	if unit == 0 {
+		unit = v.bounds.limit(estimateUnit(v.held, v.kinds))
+	}
+	u := float64(unit)
+	var gaps []float64
+	for i, d := range v.held {
+		if x := float64(d) / u; !v.kinds[i] && x > 2 {
+			gaps = append(gaps, x)
+		}
+	}
+	f := u * farnsworthStretch(gaps)
+	v.marks = [2]float64{u, 3 * u}
+	v.spaces = [3]float64{u, 3 * f, 7 * f}
+	held, kinds := v.held, v.kinds
+	v.held, v.kinds = nil, nil
+	var syms []symbol
+	for i, d := range held {
+		if v.marks[0] == 0 {
+			// Started again at a pause: hold the rest until
+			// there are enough to start from.
+			v.held, v.kinds = held[i:], kinds[i:]
+			break
+		}
+		syms = append(syms, v.step(d, kinds[i])...)
+	}
+	return syms
+}
+
+// Log probability of a duration 'x' in a class centred on 'centre'
+// with log spread 'spread'.
+func logDuration(x, centre, spread float64) float64 {
+	d := (math.Log(math.Max(x, 1)) - math.Log(centre)) / spread
+	return -d*d/2 - math.Log(spread)
+}
+
+// Take the next duration into every path, and return the symbols the
+// paths now agree on.
+func (v *viterbiTokenizer) step(duration int32, mark bool) []symbol {
+	x := float64(duration)
+	score := make([]float64, len(v.score))
+	path := make([]*viterbiStep, len(v.path))
+	for i := range score {
+		score[i] = math.Inf(-1)
+	}
+	unit := int32(math.Round(v.marks[0]))
+	var centres, spreads []float64
+	relax := func(to int, sc float64, tok token, from int) {
+		if sc <= score[to] {
+			return
+		}
+		score[to] = sc
+		path[to] = &viterbiStep{
+			tok:        tok,
+			duration:   duration,
+			unit:       unit,
+			confidence: classConfidence(x, centres, spreads, tokenClass(tok)),
+			depth:      v.depth + 1,
+			prev:       v.path[from],
+		}
+	}
+	if mark {
+		short, long := v.marks[0], v.marks[1]
+		centres = []float64{short, long, long + 2*(long-short)}
+		spreads = []float64{viterbiSpread, viterbiSpread, viterbiOutlierSpread}
+		like := []float64{
+			logDuration(x, centres[0], spreads[0]),
+			logDuration(x, centres[1], spreads[1]),
+			logDuration(x, centres[2], spreads[2]),
+		}
+		// In a bad letter any mark will do.
+		worst, any := token(dit), like[0]
+		if like[1] > any {
+			worst, any = dah, like[1]
+		}
+		if like[2] > any {
+			worst, any = cwError, like[2]
+		}
+		for s, sc := range v.score {
+			if math.IsInf(sc, -1) {
+				continue
+			}
+			if s == v.bad() {
+				relax(s, sc+any, worst, s)
+				continue
+			}
+			for k, tok := range []token{dit, dah} {
+				if c := v.trie.next[s][k]; c >= 0 {
+					relax(c, sc+like[k], tok, s)
+				}
+			}
+			relax(v.bad(), sc+viterbiBadLetter+any, worst, s)
+		}
+	} else {
+		elem, letter, word := v.spaces[0], v.spaces[1], v.spaces[2]
+		centres = []float64{elem, letter, word, word + (word - letter)}
+		spreads = []float64{viterbiSpread, viterbiSpread, viterbiSpread, viterbiOutlierSpread}
+		toks := []token{noOp, endLetter, endWord, pause}
+		var like [4]float64
+		for i := range like {
+			like[i] = logDuration(x, centres[i], spreads[i])
+		}
+		for s, sc := range v.score {
+			if math.IsInf(sc, -1) {
+				continue
+			}
+			if s == 0 {
+				// Between letters already: only the start of the
+				// stream, where any space will do.
+				for i, tok := range toks {
+					relax(0, sc+like[i], tok, s)
+				}
+				continue
+			}
+			relax(s, sc+viterbiSpaces[0]+like[0], noOp, s)
+			if s == v.bad() || v.trie.complete[s] {
+				for i := 1; i < len(toks); i++ {
+					relax(0, sc+viterbiSpaces[i]+like[i], toks[i], s)
+				}
+			}
+		}
+	}
+
+	// Keep the log probabilities near 0, and drop the hopeless.
+	best := math.Inf(-1)
+	for _, sc := range score {
+		best = math.Max(best, sc)
+	}
+	for i := range score {
+		if score[i] -= best; score[i] < -viterbiBeam {
+			score[i], path[i] = math.Inf(-1), nil
+		}
+	}
+	v.score, v.path = score, path
+	v.depth++
+	syms := v.decide()
+	if n := len(syms); n > 0 && syms[n-1].restarts() && v.last.depth == v.depth {
+		// Decided, with nothing after it to go back over.
+		v.restart()
+	}
+	return syms
+}
+
+// Forget the centres and every path, to start again.
+func (v *viterbiTokenizer) restart() {
+	v.marks, v.seeded, v.recent, v.strays = [2]float64{}, 0, nil, 0
+	for i := range v.score {
+		v.score[i], v.path[i] = math.Inf(-1), nil
+	}
+	v.score[0] = 0
+}
+
+// Return the steps every surviving path agrees on, or if there are too
+// many undecided, those of the likeliest path.
+func (v *viterbiTokenizer) decide() []symbol {
+	var heads []*viterbiStep
+	bestState := -1
+	for i, p := range v.path {
+		if p == nil {
+			continue
+		}
+		heads = append(heads, p)
+		if bestState < 0 || v.score[i] > v.score[bestState] {
+			bestState = i
+		}
+	}
+	// All the heads are at the same depth, so step them back together
+	// until they meet.
+	for len(heads) > 1 {
+		same := true
+		for _, h := range heads[1:] {
+			if h != heads[0] {
+				same = false
+				break
+			}
+		}
+		if same {
+			break
+		}
+		for i := range heads {
+			heads[i] = heads[i].prev
+		}
+		if heads[0] == nil {
+			break
+		}
+	}
+	agreed := heads[0]
+	if agreed == nil || (v.last != nil && agreed.depth <= v.last.depth) {
+		agreed = nil
+	}
+	lastDepth := 0
+	if v.last != nil {
+		lastDepth = v.last.depth
+	}
+	if v.depth-lastDepth > viterbiLag {
+		forced := v.path[bestState]
+		for forced.depth > v.depth-viterbiLag {
+			forced = forced.prev
+		}
+		if agreed == nil || forced.depth > agreed.depth {
+			agreed = forced
+			v.prune(agreed)
+		}
+	}
+	if agreed == nil {
+		return nil
+	}
+	return v.hand(agreed)
+}
+
+// Drop the paths that don't pass through 'step'.
+func (v *viterbiTokenizer) prune(step *viterbiStep) {
+	for i, p := range v.path {
+		for p != nil && p.depth > step.depth {
+			p = p.prev
+		}
+		if p != step {
+			v.score[i], v.path[i] = math.Inf(-1), nil
+		}
+	}
+}
+
+// Hand out the steps after the last handed out, up to 'to', learning
+// the centres from them.
+func (v *viterbiTokenizer) hand(to *viterbiStep) []symbol {
+	var steps []*viterbiStep
+	for s := to; s != nil && s != v.last; s = s.prev {
+		steps = append(steps, s)
+	}
+	syms := make([]symbol, len(steps))
+	for i := range steps {
+		s := steps[len(steps)-1-i]
+		syms[i] = symbol{tok: s.tok, duration: s.duration, unit: s.unit, confidence: s.confidence}
+		v.learn(s.tok, float64(s.duration))
+	}
+	// Nothing will look back past here again.
+	to.prev = nil
+	v.last = to
+	if v.est != nil {
+		v.est.set(int32(math.Round(v.marks[0])))
+	}
+	return syms
+}
+
+// Move the centre of class 'tok' towards a duration 'x' decided to be
+// in it, and keep the centres apart as the cluster tokenizer does.
+func (v *viterbiTokenizer) learn(tok token, x float64) {
+	var centre *float64
+	switch tok {
+	case dit:
+		centre = &v.marks[0]
+	case dah:
+		centre = &v.marks[1]
+	case noOp:
+		centre = &v.spaces[0]
+	case endLetter:
+		centre = &v.spaces[1]
+	case endWord:
+		centre = &v.spaces[2]
+	default:
+		return
+	}
+	*centre += clusterTolerances.rate * (x - *centre)
+	v.marks[0] = math.Max(float64(v.bounds.min), math.Min(float64(v.bounds.max), v.marks[0]))
+	v.marks[1] = math.Max(v.marks[1], clusterSpread*v.marks[0])
+	v.spaces[0] = math.Max(float64(v.bounds.min), math.Min(float64(v.bounds.max), v.spaces[0]))
+	for i := 1; i < len(v.spaces); i++ {
+		v.spaces[i] = math.Max(v.spaces[i], clusterSpread*v.spaces[i-1])
+	}
+}
+
+// Hand out the rest of the likeliest path that ends a letter.
+func (v *viterbiTokenizer) flush() []symbol {
+	var syms []symbol
+	if v.marks[0] == 0 {
+		if len(v.held) == 0 {
+			return nil
+		}
+		syms = v.start()
+	}
+	best := -1
+	for i, p := range v.path {
+		if p == nil || !(i == 0 || i == v.bad() || v.trie.complete[i]) {
+			continue
+		}
+		if best < 0 || v.score[i] > v.score[best] {
+			best = i
+		}
+	}
+	if best < 0 || v.path[best] == v.last {
+		return syms
+	}
+	return append(syms, v.hand(v.path[best])...)
+}
+
+func (v *viterbiTokenizer) adapt(e *unitEstimate) {
+	v.est = e
+}
+
+func (v *viterbiTokenizer) seed(unit int32) {
+	v.seeded = unit
+}
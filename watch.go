@@ -0,0 +1,98 @@
+// Watch-folder service: decode WAV files as they appear in a directory
+// (say, where an SDR recorder drops them), writing each transcript
+// alongside its recording.
+//
+// The directory is polled rather than watched through OS-specific
+// notification APIs.  A file is only decoded once its size has stayed
+// the same across two polls, so recordings still being written are
+// left alone, and one that already has a transcript is never decoded
+// again.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func transcriptName(wav string) string {
+	return strings.TrimSuffix(wav, filepath.Ext(wav)) + ".txt"
+}
+
+// Decode the WAV file 'filename' into its transcript file.
+func decodeFile(cfg *config, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	samples, rate, err := readWav(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if rate <= 0 {
+		return fmt.Errorf("bad sample rate %d", rate)
+	}
+	text, _ := decodeSamples(cfg, samples, rate)
+	transcript := transcriptName(filename)
+	if err := os.WriteFile(transcript, []byte(text+"\n"), 0666); err != nil {
+		return err
+	}
+	events.emit("file_decoded", map[string]interface{}{
+		"file":       filename,
+		"transcript": transcript,
+		"seconds":    float64(len(samples)) / float64(rate),
+		"text":       text,
+	})
+	fmt.Fprintf(os.Stderr, "%s: decoded %s\n", human.time(time.Now()), filename)
+	return nil
+}
+
+// Poll the directory given by 'cfg' for new WAV files to decode, until
+// something arrives on 'stop'.
+func watchFolder(cfg *config, stop chan os.Signal) error {
+	sizes := make(map[string]int64) // as of the previous poll
+	failed := make(map[string]bool)
+	tick := time.NewTicker(cfg.WatchInterval)
+	defer tick.Stop()
+	for {
+		names, err := filepath.Glob(filepath.Join(cfg.Watch, "*.[wW][aA][vV]"))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if failed[name] {
+				continue
+			}
+			if _, err := os.Stat(transcriptName(name)); err == nil {
+				continue
+			}
+			info, err := os.Stat(name)
+			if err != nil {
+				continue
+			}
+			if prev, ok := sizes[name]; !ok || prev != info.Size() {
+				sizes[name] = info.Size()
+				continue
+			}
+			delete(sizes, name)
+			if err := decodeFile(cfg, name); err != nil {
+				failed[name] = true
+				events.emit("file_failed", map[string]interface{}{
+					"file":  name,
+					"error": err.Error(),
+				})
+				fmt.Fprintf(os.Stderr, "%s: %s: %v\n", human.time(time.Now()), name, err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-tick.C:
+		}
+	}
+}
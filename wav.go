@@ -0,0 +1,248 @@
+// Reading and writing WAV files.
+//
+// See wave-decoder.go for a description of the header layout.  We
+// only ever write mono 32-bit little-endian PCM: exactly what
+// portaudio hands us, with nothing lost, so that questionable decodes
+// can be re-analyzed later.  Reading is more forgiving, since files
+// come from all sorts of recorders.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+type wavHeader struct {
+	RiffID        [4]byte
+	RiffSize      uint32
+	WaveID        [4]byte
+	FmtID         [4]byte
+	FmtSize       uint32
+	AudioFormat   uint16
+	NumChannels   uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	DataID        [4]byte
+	DataSize      uint32
+}
+
+const maxWavData = 0xffffffff - 36 // RIFF size limit, less the header
+
+type wavWriter struct {
+	f    *os.File
+	buf  *bufio.Writer
+	hdr  wavHeader
+	size uint32 // bytes of sample data written
+}
+
+func createWav(filename string, rate int) (*wavWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavWriter{f: f, buf: bufio.NewWriter(f)}
+	w.hdr = wavHeader{
+		RiffID:        [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      36,
+		WaveID:        [4]byte{'W', 'A', 'V', 'E'},
+		FmtID:         [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1,
+		NumChannels:   1,
+		SampleRate:    uint32(rate),
+		ByteRate:      uint32(rate) * 4,
+		BlockAlign:    4,
+		BitsPerSample: 32,
+		DataID:        [4]byte{'d', 'a', 't', 'a'},
+	}
+	if err := binary.Write(w.buf, binary.LittleEndian, &w.hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Append samples to the file.  Samples past the 4GB WAV limit are
+// quietly discarded.
+func (w *wavWriter) write(samples []int32) error {
+	if uint64(w.size)+uint64(4*len(samples)) > maxWavData {
+		samples = samples[:(maxWavData-w.size)/4]
+	}
+	w.size += uint32(4 * len(samples))
+	return binary.Write(w.buf, binary.LittleEndian, samples)
+}
+
+// Rewrite the header with the final sizes and close the file.
+func (w *wavWriter) Close() error {
+	err := w.buf.Flush()
+	if err == nil {
+		w.hdr.RiffSize = 36 + w.size
+		w.hdr.DataSize = w.size
+		_, err = w.f.Seek(0, 0)
+	}
+	if err == nil {
+		err = binary.Write(w.f, binary.LittleEndian, &w.hdr)
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type fmtBody struct {
+	AudioFormat   uint16
+	NumChannels   uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+)
+
+// Read a whole WAV file.  Returns its samples mixed down to mono and
+// scaled to the full int32 range, as portaudio delivers them, along
+// with the sample rate.  Handles 8/16/24/32-bit PCM and 32-bit float.
+func readWav(r io.Reader) ([]int32, int, error) {
+	var riff struct {
+		ID     [4]byte
+		Size   uint32
+		WaveID [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &riff); err != nil {
+		return nil, 0, fmt.Errorf("reading wav header: %v", err)
+	}
+	if string(riff.ID[:]) != "RIFF" || string(riff.WaveID[:]) != "WAVE" {
+		return nil, 0, errors.New("not a RIFF/WAVE file")
+	}
+
+	var format *fmtBody
+	for {
+		var hdr struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+			if err == io.EOF {
+				err = errors.New("no data chunk found")
+			}
+			return nil, 0, err
+		}
+		switch string(hdr.ID[:]) {
+		case "fmt ":
+			body := make([]byte, hdr.Size+hdr.Size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, 0, err
+			}
+			if len(body) < 16 {
+				return nil, 0, errors.New("short fmt chunk")
+			}
+			format = new(fmtBody)
+			binary.Read(bytes.NewReader(body), binary.LittleEndian, format)
+			if format.AudioFormat == wavExtensible && len(body) >= 26 {
+				// The real format is the start of the SubFormat GUID.
+				format.AudioFormat = binary.LittleEndian.Uint16(body[24:])
+			}
+		case "data":
+			if format == nil {
+				return nil, 0, errors.New("data chunk before fmt chunk")
+			}
+			// If the recorder died before fixing up the header,
+			// the data will be short; use what's there.
+			data, err := io.ReadAll(io.LimitReader(r, int64(hdr.Size)))
+			if err != nil {
+				return nil, 0, err
+			}
+			samples, err := wavSamples(format, data)
+			return samples, int(format.SampleRate), err
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(hdr.Size+hdr.Size%2)); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+}
+
+// Convert raw sample data to mono int32.
+func wavSamples(format *fmtBody, data []byte) ([]int32, error) {
+	chans := int(format.NumChannels)
+	width := int(format.BitsPerSample) / 8
+	if chans < 1 || width < 1 {
+		return nil, errors.New("bad wav format")
+	}
+	var cvt func(b []byte) int64
+	switch {
+	case format.AudioFormat == wavPCM && width == 1:
+		cvt = func(b []byte) int64 { return (int64(b[0]) - 128) << 24 }
+	case format.AudioFormat == wavPCM && width == 2:
+		cvt = func(b []byte) int64 { return int64(int16(binary.LittleEndian.Uint16(b))) << 16 }
+	case format.AudioFormat == wavPCM && width == 3:
+		cvt = func(b []byte) int64 {
+			return int64(int32(uint32(b[0])<<8 | uint32(b[1])<<16 | uint32(b[2])<<24))
+		}
+	case format.AudioFormat == wavPCM && width == 4:
+		cvt = func(b []byte) int64 { return int64(int32(binary.LittleEndian.Uint32(b))) }
+	case format.AudioFormat == wavFloat && width == 4:
+		cvt = func(b []byte) int64 {
+			f := math.Float32frombits(binary.LittleEndian.Uint32(b))
+			return int64(math.Max(-1, math.Min(1, float64(f))) * math.MaxInt32)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported wav format %d with %d bits per sample",
+			format.AudioFormat, format.BitsPerSample)
+	}
+
+	frame := chans * width
+	samples := make([]int32, len(data)/frame)
+	for i := range samples {
+		var sum int64
+		for c := 0; c < chans; c++ {
+			off := i*frame + c*width
+			sum += cvt(data[off : off+width])
+		}
+		samples[i] = int32(sum / int64(chans))
+	}
+	return samples, nil
+}
+
+// Encode 'samples' as a complete in-memory WAV file of 16-bit PCM,
+// which every browser can play.
+func wavBytes(samples []int32, rate int) []byte {
+	size := uint32(2 * len(samples))
+	hdr := wavHeader{
+		RiffID:        [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      36 + size,
+		WaveID:        [4]byte{'W', 'A', 'V', 'E'},
+		FmtID:         [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1,
+		NumChannels:   1,
+		SampleRate:    uint32(rate),
+		ByteRate:      uint32(rate) * 2,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+		DataID:        [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      size,
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &hdr)
+	pcm := make([]int16, len(samples))
+	for i, v := range samples {
+		pcm[i] = int16(v >> 16)
+	}
+	binary.Write(&b, binary.LittleEndian, pcm)
+	return b.Bytes()
+}
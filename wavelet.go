@@ -0,0 +1,101 @@
+// Wavelet (CWT) keying detector, experimental.
+//
+// A static crash or a click is a short burst of energy at every
+// frequency at once, so anything that measures energy near the tone
+// keys on it.  A continuous wavelet transform tells the two apart by
+// looking at more than one scale.  A tone shows up at the scale that
+// matches its pitch and hardly at all a quarter of an octave either
+// side; a crash shows up at all three.
+//
+// So the detector takes Morlet wavelet coefficients at the tone's scale
+// and its two neighbours, scales the neighbours' to what the same
+// impulse would give at the tone's scale, and reports how far the
+// tone's coefficient stands above the larger of them.
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Ratio between the tone's scale and each neighbour: a quarter octave.
+var waveletStep = math.Pow(2, 0.25)
+
+// Least fraction of the tone's coefficient reported, however much of
+// it the neighbours account for: 20 dB down.
+const waveletResidual = 0.1
+
+type waveletDetector struct {
+	wavelets [][]complex128 // at the tone's scale, then its neighbours
+	scales   []float64      // impulse response at the tone's scale over each one's
+	hist     []float64      // ring of the most recent samples
+	pos      int            // next slot in 'hist'
+}
+
+// Make a detector for 'freq' Hz, with a wavelet about 'bandwidth' Hz
+// wide at that pitch, in audio sampled at 'rate'.
+func newWaveletDetector(freq, bandwidth float64, rate int) *waveletDetector {
+	sigma := float64(rate) / (math.Pi * bandwidth) // in samples
+	d := &waveletDetector{}
+	var toneSum float64
+	for i, r := range []float64{1, 1 / waveletStep, waveletStep} {
+		// Constant Q: the wavelet narrows as the pitch rises.
+		w, sum := morlet(freq*r, sigma/r, rate)
+		if i == 0 {
+			toneSum = sum
+		}
+		d.wavelets = append(d.wavelets, w)
+		d.scales = append(d.scales, sum/toneSum)
+	}
+	d.hist = make([]float64, len(d.wavelets[1])) // the longest
+	return d
+}
+
+// Return a Morlet wavelet for 'freq' Hz with a Gaussian envelope of
+// standard deviation 'sigma' samples, scaled so that a sine of
+// amplitude A has a coefficient of magnitude A, along with the sum of
+// the envelope.
+func morlet(freq, sigma float64, rate int) ([]complex128, float64) {
+	n := int(6*sigma) | 1
+	c := n / 2
+	w := make([]complex128, n)
+	sum := 0.0
+	for i := range w {
+		t := float64(i - c)
+		g := math.Exp(-t * t / (2 * sigma * sigma))
+		w[i] = cmplx.Rect(g, -2*math.Pi*freq*t/float64(rate))
+		sum += g
+	}
+	for i := range w {
+		w[i] *= complex(2/sum, 0)
+	}
+	return w, sum
+}
+
+func (d *waveletDetector) amplitude(chunk []int32) int32 {
+	n := len(d.hist)
+	for _, v := range chunk {
+		d.hist[d.pos] = float64(v)
+		d.pos = (d.pos + 1) % n
+	}
+	// All the wavelets are centred on the same sample: the middle of
+	// the longest.
+	var tone, other float64
+	for k, w := range d.wavelets {
+		off := (n - len(w)) / 2
+		var c complex128
+		for i, v := range w {
+			c += v * complex(d.hist[(d.pos+off+i)%n], 0)
+		}
+		if m := cmplx.Abs(c) * d.scales[k]; k == 0 {
+			tone = m
+		} else {
+			other = math.Max(other, m)
+		}
+	}
+	// Never quite zero, so that quantizers which track the noise
+	// floor still have one to track.
+	excess := math.Max(tone-other, waveletResidual*tone)
+	return int32(math.Min(excess, math.MaxInt32))
+}
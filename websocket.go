@@ -0,0 +1,208 @@
+// WebSockets, server side.
+//
+// Just enough of RFC 6455 for a page to exchange small text messages
+// with us: the opening handshake, text frames either way, fragmented
+// messages from the page, pings, and the closing handshake.  Binary
+// messages are read like text ones; extensions and subprotocols are
+// never offered.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWSTooBig = errors.New("websocket message too big")
+
+type wsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	maxSize int // longest message we'll read
+
+	mu sync.Mutex // one frame written at a time
+	w  *bufio.Writer
+}
+
+// Complete the opening handshake of the WebSocket request 'r', and
+// take over its connection.  Messages longer than 'maxSize' bytes are
+// refused.  On failure, an error has been sent in reply.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, maxSize int) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerHas(r.Header, "Connection", "upgrade") || key == "" {
+		http.Error(w, "websocket expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets unsupported", http.StatusInternalServerError)
+		return nil, errors.New("can't hijack connection")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader, w: rw.Writer, maxSize: maxSize}, nil
+}
+
+// Return whether the comma-separated header 'name' lists 'token'.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read the next whole message, answering pings on the way.  Returns
+// io.EOF once the page has closed the connection.
+func (c *wsConn) read() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if started {
+				return nil, errors.New("websocket message interrupted")
+			}
+			started = true
+		case wsContinuation:
+			if !started {
+				return nil, errors.New("websocket continuation without a message")
+			}
+		default:
+			return nil, errors.New("unknown websocket opcode")
+		}
+		if len(msg)+len(payload) > c.maxSize {
+			c.writeFrame(wsClose, []byte{0x03, 0xf1}) // 1009: too big
+			return nil, errWSTooBig
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// Read one frame, unmasking its payload.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		return
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[1]&0x80 == 0 {
+		err = errors.New("unmasked websocket frame from client")
+		return
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > uint64(c.maxSize) {
+		c.writeFrame(wsClose, []byte{0x03, 0xf1})
+		err = errWSTooBig
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// Send 'text' as a message.
+func (c *wsConn) write(text []byte) error {
+	return c.writeFrame(wsText, text)
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.WriteByte(0x80 | op)
+	switch n := len(payload); {
+	case n < 126:
+		c.w.WriteByte(byte(n))
+	case n <= 0xffff:
+		c.w.WriteByte(126)
+		binary.Write(c.w, binary.BigEndian, uint16(n))
+	default:
+		c.w.WriteByte(127)
+		binary.Write(c.w, binary.BigEndian, uint64(n))
+	}
+	c.w.Write(payload)
+	return c.w.Flush()
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,89 @@
+// WPM-adaptive analysis windows.
+//
+// The window quantizer's 100 amplitudes and the clamp tokenizer's 20
+// durations suit sending at around 20 WPM.  At 5 WPM a dah lasts
+// longer than the quantizer's whole window, which then can't see the
+// space it's meant to be measured against; and 20 durations reach back
+// ten seconds, so the unit estimate is slow to follow the sender.  At
+// 40 WPM the same 20 durations come and go in little over a second,
+// too few to estimate the unit from reliably.
+//
+// So with adaptive windows both are sized from the latest unit
+// estimate instead: the quantizer's window to span a fixed number of
+// units, and the tokenizer's a fixed stretch of time, within
+// bounds.  Until there is an estimate, the configured sizes stand.
+
+package main
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+const (
+	quantizeUnits = 2.5 // units the quantizer window spans: 100 amplitudes at 20 WPM
+	tokenSpan     = 2.4 // seconds of sending per token window: 20 durations at 20 WPM
+
+	minTokenWindow = 10
+	maxTokenWindow = 60
+)
+
+// The tokenizer's latest unit estimate, in amplitudes, for the stages
+// that size themselves by it.
+type unitEstimate struct {
+	unit    int32 // accessed atomically; 0 until the first estimate
+	maxUnit int32 // the longest unit it can be
+}
+
+// Make an estimate to be kept within 'bounds'.
+func newUnitEstimate(bounds *unitBounds) *unitEstimate {
+	return &unitEstimate{maxUnit: bounds.max}
+}
+
+func (e *unitEstimate) set(unit int32) {
+	atomic.StoreInt32(&e.unit, unit)
+}
+
+func (e *unitEstimate) get() int32 {
+	return atomic.LoadInt32(&e.unit)
+}
+
+// A tokenizer which can report its unit estimates to 'e', and size its
+// groups by them.
+type windowAdapter interface {
+	adapt(e *unitEstimate)
+}
+
+// Amplitudes the quantizer window should span, or 'def' if there's no
+// estimate yet.
+func (e *unitEstimate) quantizeWindow(def int) int {
+	unit := e.get()
+	if unit <= 0 {
+		return def
+	}
+	return int(math.Max(1, math.Round(quantizeUnits*float64(unit))))
+}
+
+// The largest window the quantizer will need, given that it would
+// otherwise be 'def'.
+func (e *unitEstimate) quantizeCapacity(def int) int {
+	return int(math.Max(float64(def), math.Round(quantizeUnits*float64(e.maxUnit))))
+}
+
+// Durations the token window should hold, for amplitudes 'step' seconds
+// apart, or 'def' if there's no estimate yet.  Durations average about
+// two units.
+func (e *unitEstimate) tokenWindow(def int, step float64) int {
+	unit := e.get()
+	if unit <= 0 {
+		return def
+	}
+	n := int(math.Round(tokenSpan / (2 * float64(unit) * step)))
+	if n < minTokenWindow {
+		n = minTokenWindow
+	}
+	if n > maxTokenWindow {
+		n = maxTokenWindow
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+// Whole-word output.
+//
+// Printed token by token, copy dribbles out a letter at a time, and
+// anything downstream parsing it (a notifier, a QSO logger) sees half
+// words.  The word buffer holds each word back until its trailing word
+// gap has been heard, then hands it on whole.  If no gap comes, say
+// because the sender stopped mid-word, the word goes out anyway after
+// a timeout.  On a poor channel a word gap is easily lost in a fade,
+// so below wordPoorSNR the timeout is doubled rather than split words
+// early.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	wordTimeout = 2 * time.Second
+	wordPoorSNR = 10 // dB
+)
+
+type wordBuffer struct {
+	mu    sync.Mutex
+	word  string
+	emit  func(string)
+	timer *time.Timer
+}
+
+// Make a buffer which passes finished words, with the separator that
+// ended each, to 'emit'.
+func newWordBuffer(emit func(string)) *wordBuffer {
+	return &wordBuffer{emit: emit}
+}
+
+// Add symbol 's', printed as 'text'.
+func (b *wordBuffer) add(s symbol, text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.word += text
+	if s.tok == endWord || s.tok == pause {
+		b.flushLocked()
+		return
+	}
+	timeout := wordTimeout
+	if s.snr < wordPoorSNR {
+		timeout *= 2
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(timeout, b.flush)
+	} else {
+		b.timer.Reset(timeout)
+	}
+}
+
+// Hand on whatever is held.
+func (b *wordBuffer) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+func (b *wordBuffer) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if b.word != "" {
+		b.emit(b.word)
+		b.word = ""
+	}
+}